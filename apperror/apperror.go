@@ -0,0 +1,145 @@
+package apperror
+
+import (
+	"net/http"
+
+	"shopifyx/validation"
+)
+
+// Code is a stable, machine readable identifier of an error. Clients should
+// branch on the code, never on the message.
+type Code string
+
+const (
+	CodeInternal         Code = "INTERNAL_ERROR"
+	CodeBadRequest       Code = "BAD_REQUEST"
+	CodeInvalidBody      Code = "INVALID_REQUEST_BODY"
+	CodeValidationFailed Code = "VALIDATION_FAILED"
+	CodeUnauthorized     Code = "UNAUTHORIZED"
+	CodeTokenMissing     Code = "TOKEN_MISSING"
+	CodeForbidden        Code = "FORBIDDEN"
+	CodeNotFound         Code = "NOT_FOUND"
+	CodeMethodNotAllowed Code = "METHOD_NOT_ALLOWED"
+	CodeConflict         Code = "CONFLICT"
+	CodeTooManyRequests  Code = "TOO_MANY_REQUESTS"
+
+	CodeUsernameTaken       Code = "USERNAME_TAKEN"
+	CodeUserNotFound        Code = "USER_NOT_FOUND"
+	CodeUserBanned          Code = "USER_BANNED"
+	CodeInvalidCredentials  Code = "INVALID_CREDENTIALS"
+	CodeInvalidRefreshToken Code = "INVALID_REFRESH_TOKEN"
+	CodeInvalidRoles        Code = "INVALID_ROLES"
+
+	CodeProductNotFound      Code = "PRODUCT_NOT_FOUND"
+	CodeVariantNotFound      Code = "VARIANT_NOT_FOUND"
+	CodeProductImageNotFound Code = "PRODUCT_IMAGE_NOT_FOUND"
+	CodeBankAccountNotFound  Code = "BANK_ACCOUNT_NOT_FOUND"
+	CodeOrderNotFound        Code = "ORDER_NOT_FOUND"
+	CodeCartItemNotFound     Code = "CART_ITEM_NOT_FOUND"
+	CodeCartEmpty            Code = "CART_EMPTY"
+	CodeReservationNotFound  Code = "RESERVATION_NOT_FOUND"
+
+	CodeInsufficientStock        Code = "INSUFFICIENT_STOCK"
+	CodeInvalidQuantity          Code = "INVALID_QUANTITY"
+	CodeVariantRequired          Code = "VARIANT_REQUIRED"
+	CodeProductHasVariants       Code = "PRODUCT_HAS_VARIANTS"
+	CodeLastProductImage         Code = "LAST_PRODUCT_IMAGE"
+	CodeTooManyProductImages     Code = "TOO_MANY_PRODUCT_IMAGES"
+	CodeInvalidImageOrder        Code = "INVALID_IMAGE_ORDER"
+	CodePaymentDetailsInvalid    Code = "PAYMENT_DETAILS_INVALID"
+	CodeSellerBankAccountMissing Code = "SELLER_BANK_ACCOUNT_MISSING"
+	CodeCartVariantsUnsupported  Code = "CART_VARIANTS_UNSUPPORTED"
+	CodeInvalidStatusTransition  Code = "INVALID_STATUS_TRANSITION"
+	CodeInvalidFilter            Code = "INVALID_FILTER"
+	CodeRestoreWindowExpired     Code = "RESTORE_WINDOW_EXPIRED"
+	CodeReservationExpired       Code = "RESERVATION_EXPIRED"
+	CodeReservationMismatch      Code = "RESERVATION_MISMATCH"
+	CodeReservationExists        Code = "RESERVATION_EXISTS"
+	CodeStockReserved            Code = "STOCK_RESERVED"
+
+	CodeInvalidIdempotencyKey    Code = "INVALID_IDEMPOTENCY_KEY"
+	CodeIdempotencyKeyInProgress Code = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	CodeIdempotencyKeyReused     Code = "IDEMPOTENCY_KEY_REUSED"
+
+	CodeFileRequired           Code = "FILE_REQUIRED"
+	CodeUnsupportedFileFormat  Code = "UNSUPPORTED_FILE_FORMAT"
+	CodeInvalidFileSize        Code = "INVALID_FILE_SIZE"
+	CodeUploadNotFound         Code = "UPLOAD_NOT_FOUND"
+	CodeInvalidUploadSignature Code = "INVALID_UPLOAD_SIGNATURE"
+)
+
+// AppError is returned by handlers and middlewares instead of writing the
+// response themselves. The central HTTP error handler renders it, so every
+// error reaches the client in the same shape.
+type AppError struct {
+	Status  int
+	Code    Code
+	Message string
+	Details interface{}
+
+	// Err is the underlying cause. It is logged but never sent to clients.
+	Err error
+}
+
+func New(status int, code Code, message string) *AppError {
+	return &AppError{Status: status, Code: code, Message: message}
+}
+
+// Internal reports an unexpected failure, keeping err for the logs.
+func Internal(message string, err error) *AppError {
+	return &AppError{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// Validation turns field errors from the validation package into a 400.
+func Validation(errs validation.Errors) *AppError {
+	return &AppError{Status: http.StatusBadRequest, Code: CodeValidationFailed, Message: "validation failed", Details: errs}
+}
+
+func (e *AppError) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *AppError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns a copy of the error that keeps err as its cause.
+func (e *AppError) Wrap(err error) *AppError {
+	copied := *e
+	copied.Err = err
+	return &copied
+}
+
+func (e *AppError) WithDetails(details interface{}) *AppError {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// CodeForStatus is the generic code used when an error carries nothing but
+// an HTTP status, e.g. echo's own 404 and 405 errors.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusTooManyRequests:
+		return CodeTooManyRequests
+	}
+	if status < http.StatusInternalServerError {
+		return CodeBadRequest
+	}
+	return CodeInternal
+}
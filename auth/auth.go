@@ -1,8 +1,14 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"net/http"
 	"os"
+	"shopifyx/apperror"
 	"shopifyx/domain"
 	"strconv"
 	"strings"
@@ -11,18 +17,42 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
+	uuid "github.com/nu7hatch/gouuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const defaultRefreshExpiredHours = 720
+
+var ErrTokenRevoked = errors.New("token has been revoked")
+
 type JwtCustomClaims struct {
-	Id   string `json:"id"`
-	Name string `json:"name"`
+	Id    string            `json:"id"`
+	Name  string            `json:"name"`
+	Roles []domain.RoleEnum `json:"roles,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// HasRole reports whether the token grants any of the given roles. A token
+// without roles grants none.
+func (c *JwtCustomClaims) HasRole(roles ...domain.RoleEnum) bool {
+	for _, have := range c.Roles {
+		for _, want := range roles {
+			if have == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RevocationChecker tells whether an access token id (jti) was revoked,
+// e.g. because its owner logged out or was banned.
+type RevocationChecker interface {
+	IsAccessTokenRevoked(jti, userId string) (bool, error)
+}
+
 func GenerateAccessToken(user *domain.User) (string, error) {
 
-	var jwtSecretKey = os.Getenv("JWT_SECRET")
 	var jwtExpiredMinutes = os.Getenv("JWT_EXPIRED_MINUTES")
 
 	var tokenExpirationTime, err = strconv.Atoi(jwtExpiredMinutes)
@@ -30,38 +60,83 @@ func GenerateAccessToken(user *domain.User) (string, error) {
 		panic(err)
 	}
 
+	tokenId, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
 	claims := &JwtCustomClaims{
-		Id:   user.Id,
-		Name: user.Name,
+		Id:    user.Id,
+		Name:  user.Name,
+		Roles: user.Roles,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId.String(),
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Duration(tokenExpirationTime) * time.Minute)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return keys.Sign(claims)
+}
 
-	t, err := token.SignedString([]byte(jwtSecretKey))
-	if err != nil {
-		return t, err
+// GenerateRefreshToken returns an opaque random refresh token, its sha256
+// hash to be stored server side and its expiry.
+func GenerateRefreshToken() (string, string, time.Time, error) {
+	refreshExpiredHours, err := strconv.Atoi(os.Getenv("JWT_REFRESH_EXPIRED_HOURS"))
+	if err != nil || refreshExpiredHours <= 0 {
+		refreshExpiredHours = defaultRefreshExpiredHours
 	}
 
-	return t, nil
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", time.Time{}, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), time.Now().Add(time.Duration(refreshExpiredHours) * time.Hour), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func ConfigJWT() echojwt.Config {
+// ConfigJWT protects every route except the public ones below and any route
+// under publicPrefixes, e.g. locally served images.
+func ConfigJWT(revocation RevocationChecker, publicPrefixes ...string) echojwt.Config {
 	return echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
 		Skipper: func(c echo.Context) bool {
-			return strings.HasPrefix(c.Path(), "/v1/user/") || strings.HasPrefix(c.Path(), "/metrics")
+			switch c.Path() {
+			case "/v1/user/register", "/v1/user/login", "/v1/user/refresh", "/.well-known/jwks.json":
+				return true
+			}
+			for _, prefix := range publicPrefixes {
+				if strings.HasPrefix(c.Path(), prefix) {
+					return true
+				}
+			}
+			return strings.HasPrefix(c.Path(), "/metrics")
 		},
-		NewClaimsFunc: func(c echo.Context) jwt.Claims {
-			return new(JwtCustomClaims)
+		ParseTokenFunc: func(c echo.Context, auth string) (interface{}, error) {
+			token, err := jwt.ParseWithClaims(auth, new(JwtCustomClaims), keys.Keyfunc, jwt.WithValidMethods(keys.ValidMethods()))
+			if err != nil {
+				return nil, err
+			}
+
+			claims := token.Claims.(*JwtCustomClaims)
+			revoked, err := revocation.IsAccessTokenRevoked(claims.ID, claims.Id)
+			if err != nil {
+				return nil, err
+			}
+			if revoked {
+				return nil, ErrTokenRevoked
+			}
+			return token, nil
 		},
 		ErrorHandler: func(c echo.Context, err error) error {
-			if err == echojwt.ErrJWTMissing {
-				return echo.NewHTTPError(http.StatusForbidden, "you dont have access")
+			if errors.Is(err, echojwt.ErrJWTMissing) {
+				return apperror.New(http.StatusForbidden, apperror.CodeTokenMissing, "you dont have access")
 			}
-			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized1")
+			return apperror.New(http.StatusUnauthorized, apperror.CodeUnauthorized, "token is invalid or expired").Wrap(err)
 		},
 	}
 }
@@ -80,8 +155,11 @@ func VerifyPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-func GetUserIdFromToken(c echo.Context) string {
+func GetClaimsFromToken(c echo.Context) *JwtCustomClaims {
 	user := c.Get("user").(*jwt.Token)
-	claims := user.Claims.(*JwtCustomClaims)
-	return claims.Id
+	return user.Claims.(*JwtCustomClaims)
+}
+
+func GetUserIdFromToken(c echo.Context) string {
+	return GetClaimsFromToken(c).Id
 }
@@ -0,0 +1,302 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrUnknownKeyId      = errors.New("unknown jwt key id")
+	ErrNoSigningKey      = errors.New("no jwt signing key configured")
+	ErrUnsupportedKey    = errors.New("unsupported jwt key type")
+	ErrSigningKeyMissing = errors.New("configured jwt signing key id has no private key")
+)
+
+var keys *KeySet
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySet holds the key used to sign new access tokens and every key that is
+// still accepted when verifying them. Keys are read from a directory of PEM
+// files named <kid>.pem: private keys can sign and verify, public keys only
+// verify, which lets a retired key keep validating tokens until they expire.
+type KeySet struct {
+	dir        string
+	signingKid string
+	secret     []byte
+	// legacyUntil is when kid-less HS256 tokens stop being accepted once
+	// asymmetric keys are loaded
+	legacyUntil time.Time
+
+	mu            sync.RWMutex
+	activeKid     string
+	signingKey    crypto.Signer
+	signingMethod jwt.SigningMethod
+	publicKeys    map[string]crypto.PublicKey
+}
+
+// InitKeys loads the key set from JWT_KEYS_DIR. When the directory is not
+// configured tokens are signed with the shared JWT_SECRET using HS256.
+// JWT_LEGACY_HS256_UNTIL, an RFC 3339 time, lets HS256 tokens issued before
+// the switch to the key directory keep working until then.
+func InitKeys() {
+	var legacyUntil time.Time
+	if value := os.Getenv("JWT_LEGACY_HS256_UNTIL"); value != "" {
+		var err error
+		if legacyUntil, err = time.Parse(time.RFC3339, value); err != nil {
+			panic(fmt.Errorf("JWT_LEGACY_HS256_UNTIL: %w", err))
+		}
+	}
+
+	var err error
+	keys, err = LoadKeySet(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_SIGNING_KID"), os.Getenv("JWT_SECRET"), legacyUntil)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func GetKeySet() *KeySet {
+	return keys
+}
+
+func LoadKeySet(dir, signingKid, secret string, legacyUntil time.Time) (*KeySet, error) {
+	k := &KeySet{dir: dir, signingKid: signingKid, secret: []byte(secret), legacyUntil: legacyUntil}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads the key directory. Without JWT_SIGNING_KID the last private
+// key by name signs, so adding a newer one rotates the signing key without
+// restarting. A JWT_SIGNING_KID is read once at startup, changing it takes a
+// restart.
+func (k *KeySet) Reload() error {
+	if k.dir == "" {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(k.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	publicKeys := make(map[string]crypto.PublicKey)
+	signers := make(map[string]crypto.Signer)
+	var lastSigner string
+
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		key, err := parsePEMKey(content)
+		if err != nil {
+			return fmt.Errorf("jwt key %s: %w", kid, err)
+		}
+
+		publicKey := key
+		if signer, ok := key.(crypto.Signer); ok {
+			publicKey = signer.Public()
+			signers[kid] = signer
+			lastSigner = kid
+		}
+		if _, err := signingMethodFor(publicKey); err != nil {
+			return fmt.Errorf("jwt key %s: %w", kid, err)
+		}
+		publicKeys[kid] = publicKey
+	}
+
+	activeKid := k.signingKid
+	if activeKid == "" {
+		activeKid = lastSigner
+	}
+	signer, ok := signers[activeKid]
+	if !ok {
+		if activeKid == "" {
+			return ErrNoSigningKey
+		}
+		return ErrSigningKeyMissing
+	}
+
+	method, err := signingMethodFor(signer.Public())
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.activeKid = activeKid
+	k.signingKey = signer
+	k.signingMethod = method
+	k.publicKeys = publicKeys
+	return nil
+}
+
+func (k *KeySet) Sign(claims jwt.Claims) (string, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.signingKey == nil {
+		if len(k.secret) == 0 {
+			return "", ErrNoSigningKey
+		}
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.secret)
+	}
+
+	token := jwt.NewWithClaims(k.signingMethod, claims)
+	token.Header["kid"] = k.activeKid
+	return token.SignedString(k.signingKey)
+}
+
+// acceptsHS256 tells whether kid-less HS256 tokens are valid: always while
+// JWT_SECRET is the only key, and until legacyUntil once keys are loaded.
+func (k *KeySet) acceptsHS256() bool {
+	if len(k.secret) == 0 {
+		return false
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.signingKey == nil || time.Now().Before(k.legacyUntil)
+}
+
+// Keyfunc picks the verification key by the kid header. Tokens without a kid
+// are HS256 tokens signed with JWT_SECRET, see acceptsHS256.
+func (k *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		if !k.acceptsHS256() || token.Method != jwt.SigningMethodHS256 {
+			return nil, ErrUnknownKeyId
+		}
+		return k.secret, nil
+	}
+
+	k.mu.RLock()
+	key, ok := k.publicKeys[kid]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, ErrUnknownKeyId
+	}
+
+	method, err := signingMethodFor(key)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != method.Alg() {
+		return nil, fmt.Errorf("jwt key %s does not sign with %s", kid, token.Method.Alg())
+	}
+	return key, nil
+}
+
+func (k *KeySet) ValidMethods() []string {
+	methods := []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}
+	if k.acceptsHS256() {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	return methods
+}
+
+func (k *KeySet) JWKS() JWKS {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	kids := make([]string, 0, len(k.publicKeys))
+	for kid := range k.publicKeys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, kid := range kids {
+		switch key := k.publicKeys[kid].(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: jwt.SigningMethodRS256.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: jwt.SigningMethodEdDSA.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(key),
+			})
+		}
+	}
+	return jwks
+}
+
+func signingMethodFor(key crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return jwt.SigningMethodRS256, nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+func parsePEMKey(content []byte) (interface{}, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(crypto.Signer); !ok {
+			return nil, ErrUnsupportedKey
+		}
+		return key, nil
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
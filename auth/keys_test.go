@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testSecret = "keys-test-secret"
+
+// writeSigningKey puts a new Ed25519 private key named kid into dir.
+func writeSigningKey(t *testing.T, dir, kid string) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func legacyToken(t *testing.T) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "legacy"}).SignedString([]byte(testSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func parse(k *KeySet, token string) error {
+	_, err := jwt.Parse(token, k.Keyfunc, jwt.WithValidMethods(k.ValidMethods()))
+	return err
+}
+
+func TestLegacyHS256(t *testing.T) {
+	dir := t.TempDir()
+	writeSigningKey(t, dir, "2024-01")
+
+	tests := []struct {
+		name        string
+		dir         string
+		legacyUntil time.Time
+		accepted    bool
+	}{
+		{"secret only", "", time.Time{}, true},
+		{"keys without a legacy window", dir, time.Time{}, false},
+		{"keys within the legacy window", dir, time.Now().Add(time.Hour), true},
+		{"keys after the legacy window", dir, time.Now().Add(-time.Hour), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := LoadKeySet(tt.dir, "", testSecret, tt.legacyUntil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := parse(k, legacyToken(t)); (err == nil) != tt.accepted {
+				t.Fatalf("got error %v, want accepted %v", err, tt.accepted)
+			}
+		})
+	}
+}
+
+func TestReloadRotatesToNewestKey(t *testing.T) {
+	dir := t.TempDir()
+	writeSigningKey(t, dir, "2024-01")
+
+	k, err := LoadKeySet(dir, "", "", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := k.Sign(jwt.RegisteredClaims{Subject: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeSigningKey(t, dir, "2024-02")
+	if err := k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	token, err := k.Sign(jwt.RegisteredClaims{Subject: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := jwt.Parse(token, k.Keyfunc, jwt.WithValidMethods(k.ValidMethods()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := parsed.Header["kid"]; kid != "2024-02" {
+		t.Fatalf("got kid %v, want the newest key 2024-02", kid)
+	}
+	if err := parse(k, old); err != nil {
+		t.Fatalf("token of the previous key no longer verifies: %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultIdempotencyRetentionHours     = 24
+	defaultIdempotencyClaimTimeoutSecond = 60
+)
+
+// IdempotencyRetention is how long a stored response is replayed for the same
+// Idempotency-Key, read from IDEMPOTENCY_RETENTION_HOURS.
+func IdempotencyRetention() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_RETENTION_HOURS"))
+	if err != nil || hours <= 0 {
+		hours = defaultIdempotencyRetentionHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// IdempotencyClaimTimeout is how long a key may stay in progress before a
+// retry takes it over, read from IDEMPOTENCY_CLAIM_TIMEOUT_SECONDS. A claim
+// is only left in progress that long when the process died mid request.
+func IdempotencyClaimTimeout() time.Duration {
+	seconds, err := strconv.Atoi(os.Getenv("IDEMPOTENCY_CLAIM_TIMEOUT_SECONDS"))
+	if err != nil || seconds <= 0 {
+		seconds = defaultIdempotencyClaimTimeoutSecond
+	}
+	return time.Duration(seconds) * time.Second
+}
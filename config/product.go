@@ -0,0 +1,32 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultProductRestoreWindowDays = 30
+	defaultStockReservationMinutes  = 15
+)
+
+// ProductRestoreWindow is how long the owner can restore a deleted product,
+// read from PRODUCT_RESTORE_WINDOW_DAYS.
+func ProductRestoreWindow() time.Duration {
+	days, err := strconv.Atoi(os.Getenv("PRODUCT_RESTORE_WINDOW_DAYS"))
+	if err != nil || days <= 0 {
+		days = defaultProductRestoreWindowDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// StockReservationTTL is how long reserved stock is held for a buyer before
+// it is released, read from STOCK_RESERVATION_TTL_MINUTES.
+func StockReservationTTL() time.Duration {
+	minutes, err := strconv.Atoi(os.Getenv("STOCK_RESERVATION_TTL_MINUTES"))
+	if err != nil || minutes <= 0 {
+		minutes = defaultStockReservationMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
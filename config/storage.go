@@ -0,0 +1,88 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	ImageStorageS3           = "s3"
+	ImageStorageS3Compatible = "s3-compatible"
+	ImageStorageLocal        = "local"
+
+	defaultS3Region           = "ap-southeast-1"
+	defaultLocalImageDir      = "uploads"
+	defaultLocalImageURLPath  = "/images"
+	defaultImagePublicBaseURL = "http://localhost:8000"
+	defaultPresignExpiryMins  = 15
+	defaultAssetGCGraceHours  = 24
+)
+
+type ImageStorageConfig struct {
+	Driver string
+
+	S3AccessKeyId     string
+	S3SecretAccessKey string
+	S3Bucket          string
+	S3Region          string
+	// S3Endpoint and S3UsePathStyle point the client at an S3 compatible
+	// server such as MinIO. S3PublicURL overrides the base of returned URLs.
+	S3Endpoint     string
+	S3UsePathStyle bool
+	S3PublicURL    string
+
+	LocalDir     string
+	LocalURLPath string
+	// LocalUploadSecret signs presigned uploads to the local driver. A random
+	// secret is used when empty, so URLs do not survive a restart.
+	LocalUploadSecret string
+	// PublicBaseURL is the address clients reach this server on, used to
+	// build absolute URLs for locally stored images.
+	PublicBaseURL string
+
+	PresignExpiry time.Duration
+	// AssetGCGracePeriod is how long an upload may stay unreferenced before
+	// it is garbage collected, giving the client time to use it.
+	AssetGCGracePeriod time.Duration
+}
+
+// ImageStorage reads the image storage settings. IMAGE_STORAGE_DRIVER picks
+// s3 (default), s3-compatible or local.
+func ImageStorage() ImageStorageConfig {
+	usePathStyle, _ := strconv.ParseBool(os.Getenv("S3_USE_PATH_STYLE"))
+
+	presignExpiryMins, err := strconv.Atoi(os.Getenv("PRESIGN_EXPIRES_MINUTES"))
+	if err != nil || presignExpiryMins <= 0 {
+		presignExpiryMins = defaultPresignExpiryMins
+	}
+
+	assetGCGraceHours, err := strconv.Atoi(os.Getenv("ASSET_GC_GRACE_HOURS"))
+	if err != nil || assetGCGraceHours <= 0 {
+		assetGCGraceHours = defaultAssetGCGraceHours
+	}
+
+	return ImageStorageConfig{
+		Driver:             getEnv("IMAGE_STORAGE_DRIVER", ImageStorageS3),
+		S3AccessKeyId:      os.Getenv("S3_ID"),
+		S3SecretAccessKey:  os.Getenv("S3_SECRET_KEY"),
+		S3Bucket:           os.Getenv("S3_BUCKET_NAME"),
+		S3Region:           getEnv("S3_REGION", defaultS3Region),
+		S3Endpoint:         os.Getenv("S3_ENDPOINT"),
+		S3UsePathStyle:     usePathStyle,
+		S3PublicURL:        os.Getenv("S3_PUBLIC_URL"),
+		LocalDir:           getEnv("LOCAL_IMAGE_DIR", defaultLocalImageDir),
+		LocalURLPath:       getEnv("LOCAL_IMAGE_URL_PATH", defaultLocalImageURLPath),
+		LocalUploadSecret:  os.Getenv("LOCAL_UPLOAD_SECRET"),
+		PublicBaseURL:      getEnv("PUBLIC_BASE_URL", defaultImagePublicBaseURL),
+		PresignExpiry:      time.Duration(presignExpiryMins) * time.Minute,
+		AssetGCGracePeriod: time.Duration(assetGCGraceHours) * time.Hour,
+	}
+}
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
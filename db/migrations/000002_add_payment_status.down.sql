@@ -0,0 +1,27 @@
+CREATE OR REPLACE VIEW total_product_sold AS
+SELECT 
+    product_id,
+    COALESCE(SUM(quantity), 0) AS total_sold
+FROM payments
+GROUP BY product_id;
+
+CREATE OR REPLACE VIEW total_users_sold AS
+SELECT u.id AS user_id,
+       u.username AS username,
+       COALESCE(SUM(py.quantity), 0) AS total_sold
+FROM users u
+LEFT JOIN products p ON u.id = p.user_id
+LEFT JOIN payments py ON p.id = py.product_id
+GROUP BY u.id, u.username;
+
+DROP INDEX IF EXISTS idx_payments_product_id;
+DROP INDEX IF EXISTS idx_payments_buyer_id;
+DROP INDEX IF EXISTS idx_payments_status;
+
+ALTER TABLE payments
+    DROP COLUMN IF EXISTS updated_at,
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS status;
+
+DELETE FROM payments WHERE payment_proof_image_url IS NULL;
+ALTER TABLE payments ALTER COLUMN payment_proof_image_url SET NOT NULL;
@@ -0,0 +1,30 @@
+-- Payments become orders that move through a lifecycle
+ALTER TABLE payments ALTER COLUMN payment_proof_image_url DROP NOT NULL;
+
+ALTER TABLE payments
+    ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'proof_submitted'
+        CHECK (status IN ('pending', 'proof_submitted', 'confirmed_by_seller', 'shipped', 'completed', 'cancelled', 'rejected')),
+    ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE INDEX idx_payments_status ON payments (status);
+CREATE INDEX idx_payments_buyer_id ON payments (buyer_id);
+CREATE INDEX idx_payments_product_id ON payments (product_id);
+
+-- Cancelled and rejected orders are not sales
+CREATE OR REPLACE VIEW total_product_sold AS
+SELECT 
+    product_id,
+    COALESCE(SUM(quantity), 0) AS total_sold
+FROM payments
+WHERE status NOT IN ('cancelled', 'rejected')
+GROUP BY product_id;
+
+CREATE OR REPLACE VIEW total_users_sold AS
+SELECT u.id AS user_id,
+       u.username AS username,
+       COALESCE(SUM(py.quantity), 0) AS total_sold
+FROM users u
+LEFT JOIN products p ON u.id = p.user_id
+LEFT JOIN payments py ON p.id = py.product_id AND py.status NOT IN ('cancelled', 'rejected')
+GROUP BY u.id, u.username;
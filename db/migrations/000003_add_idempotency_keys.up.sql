@@ -0,0 +1,15 @@
+-- Stored responses for requests sent with an Idempotency-Key header
+CREATE TABLE idempotency_keys (
+    user_id UUID NOT NULL REFERENCES users(id),
+    key VARCHAR(255) NOT NULL CHECK (LENGTH(key) >= 1),
+    method VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,
+    status_code INTEGER,
+    content_type VARCHAR(100),
+    response_body BYTEA,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX idx_idempotency_keys_created_at ON idempotency_keys (created_at);
@@ -0,0 +1,3 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS checkout_order_id;
+DROP TABLE IF EXISTS checkout_orders CASCADE;
+DROP TABLE IF EXISTS cart_items CASCADE;
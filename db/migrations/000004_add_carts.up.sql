@@ -0,0 +1,22 @@
+-- Cart items, one row per product in a user's cart
+CREATE TABLE cart_items (
+    user_id UUID NOT NULL REFERENCES users(id),
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    quantity INTEGER NOT NULL CHECK (quantity >= 1),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, product_id)
+);
+
+-- Checkout orders group the payments of one checkout that go to the same seller
+CREATE TABLE checkout_orders (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    buyer_id UUID NOT NULL REFERENCES users(id),
+    seller_id UUID NOT NULL REFERENCES users(id),
+    bank_account_id UUID NOT NULL REFERENCES bank_accounts(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_checkout_orders_buyer_id ON checkout_orders (buyer_id);
+
+ALTER TABLE payments ADD COLUMN checkout_order_id UUID REFERENCES checkout_orders(id);
@@ -0,0 +1,6 @@
+-- Roles replace the implicit "every user is a buyer and a seller"
+ALTER TABLE users
+    ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{buyer,seller}'
+        CHECK (roles <@ ARRAY['buyer', 'seller', 'admin']::TEXT[])
+        CONSTRAINT users_roles_not_empty CHECK (cardinality(roles) > 0),
+    ADD COLUMN banned_at TIMESTAMP WITH TIME ZONE;
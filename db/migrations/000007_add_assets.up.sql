@@ -0,0 +1,12 @@
+-- Uploaded files, owned by the user who uploaded them
+CREATE TABLE assets (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id),
+    key TEXT NOT NULL UNIQUE,
+    url TEXT NOT NULL,
+    size BIGINT NOT NULL CHECK (size >= 0),
+    content_type VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_assets_user_id ON assets (user_id);
@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_payments_payment_proof_image_url;
+DROP INDEX IF EXISTS idx_products_image_url;
+DROP INDEX IF EXISTS idx_assets_parent_id;
+DROP INDEX IF EXISTS idx_assets_url;
+
+ALTER TABLE assets
+    DROP COLUMN IF EXISTS rendition,
+    DROP COLUMN IF EXISTS parent_id;
@@ -0,0 +1,9 @@
+-- Renditions of an upload point at the original and are collected with it
+ALTER TABLE assets
+    ADD COLUMN parent_id UUID REFERENCES assets(id) ON DELETE CASCADE,
+    ADD COLUMN rendition VARCHAR(20) NOT NULL DEFAULT 'original';
+
+CREATE INDEX idx_assets_url ON assets (url);
+CREATE INDEX idx_assets_parent_id ON assets (parent_id);
+CREATE INDEX idx_products_image_url ON products (image_url);
+CREATE INDEX idx_payments_payment_proof_image_url ON payments (payment_proof_image_url);
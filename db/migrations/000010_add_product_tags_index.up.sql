@@ -0,0 +1,2 @@
+-- Backs the tags && (any) and @> (all) filters of product search
+CREATE INDEX idx_products_tags ON products USING GIN (tags);
@@ -0,0 +1,3 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS variant_id;
+
+DROP TABLE IF EXISTS product_variants;
@@ -0,0 +1,17 @@
+-- Variants of a product, e.g. one per size and color, each with its own stock.
+-- A product with variants keeps products.stock equal to the sum of theirs.
+CREATE TABLE product_variants (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    sku VARCHAR(64) NOT NULL,
+    options JSONB NOT NULL DEFAULT '{}',
+    -- NULL means the variant sells at the product price
+    price INTEGER CHECK (price >= 0),
+    stock INTEGER NOT NULL DEFAULT 0 CHECK (stock >= 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (product_id, sku)
+);
+
+CREATE INDEX idx_product_variants_product_id ON product_variants (product_id);
+
+ALTER TABLE payments ADD COLUMN variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL;
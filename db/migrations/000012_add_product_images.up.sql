@@ -0,0 +1,16 @@
+-- Ordered image gallery of a product. Position 0 is the primary image and is
+-- mirrored into products.image_url for older clients.
+CREATE TABLE product_images (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    url TEXT NOT NULL,
+    position INTEGER NOT NULL CHECK (position >= 0),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    -- deferred so a reorder can shuffle positions within one transaction
+    CONSTRAINT product_images_position_key UNIQUE (product_id, position) DEFERRABLE INITIALLY DEFERRED
+);
+
+CREATE INDEX idx_product_images_url ON product_images (url);
+
+INSERT INTO product_images (product_id, url, position)
+SELECT id, image_url, 0 FROM products;
@@ -0,0 +1,14 @@
+CREATE OR REPLACE VIEW seller_bank_account AS
+SELECT 
+    p.id AS product_id,
+    u.id AS seller_id,
+    ba.id AS bank_account_id,
+    p.stock AS stock,
+    p.is_purchaseable 
+FROM products p
+JOIN users u ON p.user_id = u.id
+JOIN bank_accounts ba ON u.id = ba.user_id;
+
+DROP INDEX IF EXISTS idx_products_deleted_at;
+
+ALTER TABLE products DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,17 @@
+-- Deleting a product archives it instead, payments keep pointing at it and
+-- the owner can restore it for a while.
+ALTER TABLE products ADD COLUMN deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_products_deleted_at ON products (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- An archived product cannot be bought
+CREATE OR REPLACE VIEW seller_bank_account AS
+SELECT 
+    p.id AS product_id,
+    u.id AS seller_id,
+    ba.id AS bank_account_id,
+    p.stock AS stock,
+    p.is_purchaseable AND p.deleted_at IS NULL AS is_purchaseable
+FROM products p
+JOIN users u ON p.user_id = u.id
+JOIN bank_accounts ba ON u.id = ba.user_id;
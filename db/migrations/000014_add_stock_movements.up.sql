@@ -0,0 +1,20 @@
+-- Ledger of every stock change of a product, so the current stock can be
+-- explained. Sales and cancellations point at their payment.
+CREATE TABLE stock_movements (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE SET NULL,
+    delta INTEGER NOT NULL CHECK (delta <> 0),
+    reason VARCHAR(20) NOT NULL CHECK (reason IN ('restock', 'sale', 'cancel', 'adjustment')),
+    actor_id UUID REFERENCES users(id),
+    payment_id UUID REFERENCES payments(id),
+    stock_after INTEGER NOT NULL,
+    -- clock_timestamp keeps the movements of one transaction in order
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX idx_stock_movements_product_id ON stock_movements (product_id, created_at DESC, id DESC);
+
+-- Stock from before the ledger is recorded as one opening adjustment
+INSERT INTO stock_movements (product_id, delta, reason, actor_id, stock_after)
+SELECT id, stock, 'adjustment', user_id, stock FROM products WHERE stock > 0;
@@ -0,0 +1,17 @@
+-- Stock held for a buyer while they pay. Reservations do not change
+-- products.stock, unexpired ones are subtracted from what others can buy.
+CREATE TABLE stock_reservations (
+    id UUID DEFAULT uuid_generate_v4() PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+    variant_id UUID REFERENCES product_variants(id) ON DELETE CASCADE,
+    buyer_id UUID NOT NULL REFERENCES users(id),
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_stock_reservations_product_id ON stock_reservations (product_id, expires_at);
+CREATE INDEX idx_stock_reservations_variant_id ON stock_reservations (variant_id, expires_at) WHERE variant_id IS NOT NULL;
+CREATE INDEX idx_stock_reservations_expires_at ON stock_reservations (expires_at);
+-- ReserveStock looks up the unexpired reservations a buyer holds on a product
+CREATE INDEX idx_stock_reservations_buyer_id ON stock_reservations (buyer_id, product_id, expires_at);
@@ -0,0 +1,99 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/domain"
+	"shopifyx/repository"
+	"shopifyx/util"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	InvalidRoles          = "roles must be a non empty list of buyer, seller or admin"
+	FailedToBanUser       = "failed to update user ban"
+	FailedToUpdateRoles   = "failed to update user roles"
+	FailedToUnlistProduct = "failed to unlist product"
+
+	UserBannedSuccessfully      = "user banned successfully"
+	UserUnbannedSuccessfully    = "user unbanned successfully"
+	RolesUpdatedSuccessfully    = "user roles updated successfully"
+	ProductUnlistedSuccessfully = "product unlisted successfully"
+)
+
+// AdminHandler serves moderation endpoints. Every route must be guarded with
+// RequireRole(domain.RoleAdmin).
+type AdminHandler struct {
+	users    repository.UserStore
+	products repository.ProductStore
+}
+
+func NewAdminHandler(users repository.UserStore, products repository.ProductStore) *AdminHandler {
+	return &AdminHandler{users: users, products: products}
+}
+
+func (h *AdminHandler) BanUserHandler(c echo.Context) error {
+	return h.setUserBanned(c, true, UserBannedSuccessfully)
+}
+
+func (h *AdminHandler) UnbanUserHandler(c echo.Context) error {
+	return h.setUserBanned(c, false, UserUnbannedSuccessfully)
+}
+
+func (h *AdminHandler) setUserBanned(c echo.Context, banned bool, message string) error {
+	err := h.users.SetUserBanned(c.Param("userId"), banned)
+	if err != nil {
+		if err == repository.ErrUserNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeUserNotFound, UserNotFound)
+		}
+		return apperror.Internal(FailedToBanUser, err)
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, message)
+}
+
+func (h *AdminHandler) UpdateUserRolesHandler(c echo.Context) error {
+	var request domain.UserRolesUpdate
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&request); errs != nil {
+		return apperror.Validation(errs)
+	}
+	for _, role := range request.Roles {
+		if !role.IsValid() {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInvalidRoles, InvalidRoles)
+		}
+	}
+
+	err := h.users.UpdateUserRoles(c.Param("userId"), request.Roles)
+	if err != nil {
+		if err == repository.ErrUserNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeUserNotFound, UserNotFound)
+		}
+		if repository.IsConstrainViolations(err) {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInvalidRoles, InvalidRoles)
+		}
+		return apperror.Internal(FailedToUpdateRoles, err)
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, RolesUpdatedSuccessfully)
+}
+
+func (h *AdminHandler) UnlistProductHandler(c echo.Context) error {
+	err := h.products.UnlistProduct(c.Param("productId"))
+	if err != nil {
+		if err == repository.ErrProductNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
+		}
+		return apperror.Internal(FailedToUnlistProduct, err)
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, ProductUnlistedSuccessfully)
+}
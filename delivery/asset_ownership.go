@@ -0,0 +1,41 @@
+package delivery
+
+import (
+	"shopifyx/apperror"
+	"shopifyx/repository"
+	"shopifyx/validation"
+)
+
+const (
+	FailedToCheckImage = "failed to check image"
+	ImageNotOwned      = " must be an image you uploaded"
+)
+
+type assetField struct {
+	field string
+	url   string
+}
+
+// checkAssetOwnership makes sure every non empty url was uploaded by userId,
+// reporting the offending fields like any other validation error.
+func checkAssetOwnership(assets repository.AssetStore, userId string, fields ...assetField) error {
+	var errs validation.Errors
+	for _, field := range fields {
+		if field.url == "" {
+			continue
+		}
+
+		asset, err := assets.GetAssetByURL(field.url)
+		if err != nil && err != repository.ErrAssetNotFound {
+			return apperror.Internal(FailedToCheckImage, err)
+		}
+		if err == repository.ErrAssetNotFound || asset.UserId != userId {
+			errs = append(errs, validation.FieldError{Field: field.field, Rule: "owned_asset", Message: field.field + ImageNotOwned})
+		}
+	}
+
+	if errs != nil {
+		return apperror.Validation(errs)
+	}
+	return nil
+}
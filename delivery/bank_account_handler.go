@@ -3,10 +3,12 @@ package delivery
 import (
 	"encoding/json"
 	"net/http"
+	"shopifyx/apperror"
 	"shopifyx/auth"
 	"shopifyx/domain"
 	"shopifyx/repository"
 	"shopifyx/util"
+	"shopifyx/validation"
 
 	"github.com/labstack/echo/v4"
 )
@@ -26,31 +28,43 @@ const (
 	AccountDeletedSuccessfully = "account deleted successfully"
 )
 
-func AddBankAccountHandler(c echo.Context) error {
+type BankAccountHandler struct {
+	store repository.BankAccountStore
+}
+
+func NewBankAccountHandler(store repository.BankAccountStore) *BankAccountHandler {
+	return &BankAccountHandler{store: store}
+}
+
+func (h *BankAccountHandler) AddBankAccountHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 	var bankAccount domain.BankAccount
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&bankAccount); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&bankAccount); errs != nil {
+		return apperror.Validation(errs)
 	}
 
-	err := repository.AddBankAccount(&bankAccount, userId)
+	err := h.store.AddBankAccount(&bankAccount, userId)
 
 	if err != nil {
 		if repository.IsConstrainViolations(err) {
-			return util.ErrorHandler(c, http.StatusBadRequest, RequredFieldsMissing)
+			return apperror.New(http.StatusBadRequest, apperror.CodeValidationFailed, RequredFieldsMissing)
 		}
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToAddBankAccount)
+		return apperror.Internal(FailedToAddBankAccount, err)
 	}
 	return util.ResponseHandler(c, http.StatusOK, AccountAddedSuccessfully)
 }
 
-func GetBankAccountsHandler(c echo.Context) error {
+func (h *BankAccountHandler) GetBankAccountsHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 
-	bankAccounts, err := repository.GetBankAccounts(userId)
+	bankAccounts, err := h.store.GetBankAccounts(userId)
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToAddBankAccount)
+		return apperror.Internal(FailedToAddBankAccount, err)
 	}
 
 	var bankAccountsResponse []domain.BankAccounts
@@ -67,7 +81,7 @@ func GetBankAccountsHandler(c echo.Context) error {
 	return util.GetBankAccountsResposesHandler(c, http.StatusOK, bankAccountsResponse)
 }
 
-func UpdateBankAccountHandler(c echo.Context) error {
+func (h *BankAccountHandler) UpdateBankAccountHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 
 	bankAccountId := c.Param("bankAccountId")
@@ -75,49 +89,49 @@ func UpdateBankAccountHandler(c echo.Context) error {
 	var updatedBankAccount domain.BankAccount
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&updatedBankAccount); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	result, err := repository.UpdateBankAccount(&updatedBankAccount, bankAccountId, userId)
+	if errs := validation.Struct(&updatedBankAccount); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	result, err := h.store.UpdateBankAccount(&updatedBankAccount, bankAccountId, userId)
 
 	switch result {
 	case 1:
 		return util.ResponseHandler(c, http.StatusOK, AccountUpdateSuccessfully)
 	case 2:
-		return util.ErrorHandler(c, http.StatusNotFound, BankAccountNotFound)
+		return apperror.New(http.StatusNotFound, apperror.CodeBankAccountNotFound, BankAccountNotFound)
 	case 3:
-		return util.ErrorHandler(c, http.StatusForbidden, DontHavePermission)
+		return apperror.New(http.StatusForbidden, apperror.CodeForbidden, DontHavePermission)
 	}
 
 	if err != nil {
 		if repository.IsConstrainViolations(err) {
-			return util.ErrorHandler(c, http.StatusBadRequest, RequredFieldsMissing)
+			return apperror.New(http.StatusBadRequest, apperror.CodeValidationFailed, RequredFieldsMissing)
 		}
 
 		if repository.IdNotFound(err) {
-			return util.ErrorHandler(c, http.StatusNotFound, BankAccountNotFound)
+			return apperror.New(http.StatusNotFound, apperror.CodeBankAccountNotFound, BankAccountNotFound)
 		}
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToUpdateBankAccount)
+		return apperror.Internal(FailedToUpdateBankAccount, err)
 	}
 	return nil
 }
 
-func DeleteBankAccountHandler(c echo.Context) error {
+func (h *BankAccountHandler) DeleteBankAccountHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 
 	bankAccountId := c.Param("bankAccountId")
 
-	err := repository.DeleteBankAccount(bankAccountId, userId)
+	err := h.store.DeleteBankAccount(bankAccountId, userId)
 
 	if err != nil {
-		if repository.IdNotFound(err) {
-			return util.ErrorHandler(c, http.StatusNotFound, BankAccountNotFound)
-		}
-
-		if repository.DontHavePermission(err) {
-			return util.ErrorHandler(c, http.StatusForbidden, DontHavePermission)
+		if err == repository.ErrBankAccountNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeBankAccountNotFound, BankAccountNotFound)
 		}
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToDeleteBankAccount)
+		return apperror.Internal(FailedToDeleteBankAccount, err)
 	}
 
 	return util.ResponseHandler(c, http.StatusOK, AccountDeletedSuccessfully)
@@ -0,0 +1,122 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/repository"
+	"shopifyx/util"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	FailedToFetchCart           = "failed to fetch cart"
+	CartUpdatedSuccessfully     = "cart updated successfully"
+	CheckoutCreatedSuccessfully = "checkout created successfully"
+)
+
+type CartHandler struct {
+	store  repository.CartStore
+	assets repository.AssetStore
+}
+
+func NewCartHandler(store repository.CartStore, assets repository.AssetStore) *CartHandler {
+	return &CartHandler{store: store, assets: assets}
+}
+
+func (h *CartHandler) GetCartHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	items, err := h.store.GetCart(userId)
+	if err != nil {
+		return apperror.Internal(FailedToFetchCart, err)
+	}
+
+	return util.CartResponseHandler(c, http.StatusOK, items, domain.CartTotalPrice(items))
+}
+
+func (h *CartHandler) AddCartItemHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	var item domain.CartItem
+	if err := json.NewDecoder(c.Request().Body).Decode(&item); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&item); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	err := h.store.AddCartItem(userId, item.ProductId, item.Quantity)
+	if err != nil {
+		return err
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, CartUpdatedSuccessfully)
+}
+
+func (h *CartHandler) UpdateCartItemHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	var item domain.CartItem
+	if err := json.NewDecoder(c.Request().Body).Decode(&item); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	// the product comes from the path, only the quantity is sent
+	if errs := validation.StructFields(&item, "quantity"); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	err := h.store.UpdateCartItem(userId, c.Param("productId"), item.Quantity)
+	if err != nil {
+		return err
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, CartUpdatedSuccessfully)
+}
+
+func (h *CartHandler) RemoveCartItemHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	err := h.store.RemoveCartItem(userId, c.Param("productId"))
+	if err != nil {
+		return err
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, CartUpdatedSuccessfully)
+}
+
+func (h *CartHandler) CheckoutHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	var checkout domain.Checkout
+	if err := json.NewDecoder(c.Request().Body).Decode(&checkout); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&checkout); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	proofs := make([]assetField, 0, len(checkout.Payments))
+	for i, payment := range checkout.Payments {
+		proofs = append(proofs, assetField{fmt.Sprintf("payments[%d].paymentProofImageUrl", i), payment.PaymentProofImageURL})
+	}
+	if err := checkAssetOwnership(h.assets, userId, proofs...); err != nil {
+		return err
+	}
+
+	orders, err := h.store.Checkout(userId, &checkout)
+	if err != nil {
+		return err
+	}
+
+	return util.PaymentResponseHandler(c, http.StatusCreated, CheckoutCreatedSuccessfully, orders)
+}
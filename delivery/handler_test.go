@@ -0,0 +1,1351 @@
+package delivery_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"shopifyx/auth"
+	"shopifyx/delivery"
+	"shopifyx/domain"
+	"shopifyx/job"
+	"shopifyx/middleware"
+	"shopifyx/repository"
+	"shopifyx/storage"
+
+	echojwt "github.com/labstack/echo-jwt/v4"
+	"github.com/labstack/echo/v4"
+)
+
+// testServer serves the HTTP API the way main wires it, backed by a
+// MemoryStore instead of Postgres.
+type testServer struct {
+	t      *testing.T
+	e      *echo.Echo
+	store  *repository.MemoryStore
+	images *storage.LocalImageStore
+}
+
+type testResponse struct {
+	status int
+	body   map[string]interface{}
+}
+
+func (r testResponse) data() map[string]interface{} {
+	data, _ := r.body["data"].(map[string]interface{})
+	return data
+}
+
+func (r testResponse) list() []interface{} {
+	list, _ := r.body["data"].([]interface{})
+	return list
+}
+
+func (r testResponse) code() string {
+	code, _ := r.body["code"].(string)
+	return code
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Setenv("JWT_KEYS_DIR", "")
+	t.Setenv("JWT_SIGNING_KID", "")
+	t.Setenv("JWT_SECRET", "handler-test-secret")
+	t.Setenv("JWT_EXPIRED_MINUTES", "60")
+	t.Setenv("BCRYPT_SALT", "4")
+	auth.InitKeys()
+
+	store := repository.NewMemoryStore()
+	e := echo.New()
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
+	e.Use(echojwt.WithConfig(auth.ConfigJWT(store)))
+
+	buyer := middleware.RequireRole(domain.RoleBuyer)
+	seller := middleware.RequireRole(domain.RoleSeller)
+	admin := middleware.RequireRole(domain.RoleAdmin)
+	idempotency := middleware.Idempotency(store, time.Hour, time.Minute)
+
+	userHandler := delivery.NewUserHandler(store, store)
+	productHandler := delivery.NewProductHandler(store, store, time.Hour)
+	bankAccountHandler := delivery.NewBankAccountHandler(store)
+	paymentHandler := delivery.NewPaymentHandler(store, store, time.Minute)
+	adminHandler := delivery.NewAdminHandler(store, store)
+	cartHandler := delivery.NewCartHandler(store, store)
+
+	images, err := storage.NewLocalImageStore(t.TempDir(), "http://localhost:8000", "/images", "handler-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageHandler := delivery.NewImageHandler(images, store, time.Minute)
+
+	middleware.NewRoute(e, "/v1/user/register", "POST", userHandler.RegisterUserHandler)
+	middleware.NewRoute(e, "/v1/user/login", "POST", userHandler.LoginUserHandler)
+	middleware.NewRoute(e, "/v1/user/refresh", "POST", userHandler.RefreshTokenHandler)
+	middleware.NewRoute(e, "/v1/user/logout", "POST", userHandler.LogoutUserHandler)
+	middleware.NewRoute(e, "/v1/product", "POST", productHandler.CreateProductHandler, seller, idempotency)
+	middleware.NewRoute(e, "/v1/product", "GET", productHandler.SearchProductHandler)
+	middleware.NewRoute(e, "/v1/product/tags", "GET", productHandler.PopularTagsHandler)
+	middleware.NewRoute(e, "/v1/product/:productId", "GET", productHandler.GetProductHandler)
+	middleware.NewRoute(e, "/v1/product/:productId", "PATCH", productHandler.UpdateProductHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId", "DELETE", productHandler.DeleteProductHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/restore", "POST", productHandler.RestoreProductHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/stock", "POST", productHandler.UpdateProductStockHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/variants/:variantId/stock", "POST", productHandler.UpdateVariantStockHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/stock/history", "GET", productHandler.StockHistoryHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/images", "POST", productHandler.AddProductImageHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/images", "PUT", productHandler.ReorderProductImagesHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/images/:imageId", "DELETE", productHandler.RemoveProductImageHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/images/:imageId/primary", "POST", productHandler.SetPrimaryProductImageHandler, seller)
+	middleware.NewRoute(e, "/v1/bank/account", "POST", bankAccountHandler.AddBankAccountHandler, seller)
+	middleware.NewRoute(e, "/v1/bank/account", "GET", bankAccountHandler.GetBankAccountsHandler, seller)
+	middleware.NewRoute(e, "/v1/product/:productId/reserve", "POST", paymentHandler.ReserveStockHandler, buyer, idempotency)
+	middleware.NewRoute(e, "/v1/product/:productId/buy", "POST", paymentHandler.CreatePaymentHandler, buyer, idempotency)
+	middleware.NewRoute(e, "/v1/order/:orderId", "GET", paymentHandler.GetOrderHandler)
+	middleware.NewRoute(e, "/v1/order/:orderId/proof", "POST", paymentHandler.SubmitPaymentProofHandler)
+	middleware.NewRoute(e, "/v1/order/:orderId/cancel", "POST", paymentHandler.CancelOrderHandler)
+	middleware.NewRoute(e, "/v1/order/:orderId/confirm", "POST", paymentHandler.ConfirmOrderHandler)
+	middleware.NewRoute(e, "/v1/order/:orderId/reject", "POST", paymentHandler.RejectOrderHandler)
+	middleware.NewRoute(e, "/v1/order/:orderId/ship", "POST", paymentHandler.ShipOrderHandler)
+	middleware.NewRoute(e, "/v1/order/:orderId/complete", "POST", paymentHandler.CompleteOrderHandler)
+	middleware.NewRoute(e, "/v1/user/purchases", "GET", paymentHandler.GetPurchasesHandler, buyer)
+	middleware.NewRoute(e, "/v1/seller/payments", "GET", paymentHandler.GetSellerPaymentsHandler, seller)
+	middleware.NewRoute(e, "/v1/seller/payments/:paymentId/accept", "POST", paymentHandler.AcceptPaymentHandler, seller)
+	middleware.NewRoute(e, "/v1/seller/payments/:paymentId/reject", "POST", paymentHandler.RejectPaymentHandler, seller)
+	middleware.NewRoute(e, "/v1/admin/users/:userId/roles", "PUT", adminHandler.UpdateUserRolesHandler, admin)
+	middleware.NewRoute(e, "/v1/cart", "GET", cartHandler.GetCartHandler, buyer)
+	middleware.NewRoute(e, "/v1/cart/items", "POST", cartHandler.AddCartItemHandler, buyer)
+	middleware.NewRoute(e, "/v1/cart/items/:productId", "PATCH", cartHandler.UpdateCartItemHandler, buyer)
+	middleware.NewRoute(e, "/v1/cart/items/:productId", "DELETE", cartHandler.RemoveCartItemHandler, buyer)
+	middleware.NewRoute(e, "/v1/cart/checkout", "POST", cartHandler.CheckoutHandler, buyer, idempotency)
+	middleware.NewRoute(e, "/v1/image/presign", "POST", imageHandler.PresignUploadHandler)
+	middleware.NewRoute(e, "/v1/image/confirm", "POST", imageHandler.ConfirmUploadHandler)
+
+	return &testServer{t: t, e: e, store: store, images: images}
+}
+
+func (s *testServer) do(method, path, token string, body interface{}) testResponse {
+	s.t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.e.ServeHTTP(rec, req)
+
+	response := testResponse{status: rec.Code}
+	if rec.Body.Len() > 0 {
+		if err := json.Unmarshal(rec.Body.Bytes(), &response.body); err != nil {
+			s.t.Fatalf("%s %s: invalid json %q", method, path, rec.Body.String())
+		}
+	}
+	return response
+}
+
+func (s *testServer) expect(response testResponse, status int, code string) {
+	s.t.Helper()
+	if response.status != status || (code != "" && response.code() != code) {
+		s.t.Fatalf("got %d %v, want %d %s", response.status, response.body, status, code)
+	}
+}
+
+// register signs a user up and returns its access token and id.
+func (s *testServer) register(username string) (string, string) {
+	s.t.Helper()
+
+	response := s.do("POST", "/v1/user/register", "", map[string]string{
+		"username": username,
+		"name":     username + " name",
+		"password": "password",
+	})
+	s.expect(response, http.StatusCreated, "")
+
+	token := response.data()["accessToken"].(string)
+	user, err := s.store.LoginUser(username, "password")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return token, user.Id
+}
+
+// image registers an uploaded image of userId so products may use it.
+func (s *testServer) image(userId, name string) string {
+	s.t.Helper()
+
+	asset := &domain.Asset{UserId: userId, Key: "uploads/" + userId + "/" + name, URL: "https://images.example.com/" + userId + "/" + name, ContentType: "image/jpeg"}
+	if err := s.store.CreateAsset(asset); err != nil {
+		s.t.Fatal(err)
+	}
+	return asset.URL
+}
+
+// createProduct adds a product and returns its id, found through the seller's
+// own product search.
+func (s *testServer) createProduct(token, userId string, product map[string]interface{}) string {
+	s.t.Helper()
+
+	name := product["name"].(string)
+	product["imageUrl"] = s.image(userId, name+".jpg")
+	s.expect(s.do("POST", "/v1/product", token, product), http.StatusCreated, "")
+
+	response := s.do("GET", "/v1/product?userOnly=true&showEmptyStock=true&search="+url.QueryEscape(name), token, nil)
+	s.expect(response, http.StatusOK, "")
+	for _, item := range response.list() {
+		if item := item.(map[string]interface{}); item["name"] == name {
+			return item["id"].(string)
+		}
+	}
+	s.t.Fatalf("product %s not found after creating it", name)
+	return ""
+}
+
+func (s *testServer) addBankAccount(token string) string {
+	s.t.Helper()
+
+	s.expect(s.do("POST", "/v1/bank/account", token, map[string]string{
+		"bankName":          "Bank Test",
+		"bankAccountName":   "Seller Test",
+		"bankAccountNumber": "1234567890",
+	}), http.StatusOK, "")
+
+	response := s.do("GET", "/v1/bank/account", token, nil)
+	s.expect(response, http.StatusOK, "")
+	return response.list()[0].(map[string]interface{})["id"].(string)
+}
+
+// buy orders quantity of a product and returns the order id.
+func (s *testServer) buy(token, productId, bankAccountId string, quantity int) string {
+	s.t.Helper()
+
+	response := s.do("POST", "/v1/product/"+productId+"/buy", token, map[string]interface{}{
+		"bankAccountId": bankAccountId,
+		"quantity":      quantity,
+	})
+	s.expect(response, http.StatusCreated, "")
+	return response.data()["id"].(string)
+}
+
+// stock is the stock of a product that is still available to buyers.
+func (s *testServer) stock(token, productId string) float64 {
+	s.t.Helper()
+
+	response := s.do("GET", "/v1/product/"+productId, token, nil)
+	s.expect(response, http.StatusOK, "")
+	return response.data()["product"].(map[string]interface{})["stock"].(float64)
+}
+
+// total is the total of a paginated listing.
+func (r testResponse) total() interface{} {
+	meta, _ := r.body["meta"].(map[string]interface{})
+	return meta["total"]
+}
+
+// ids lists the "id" of every item of a listing, in order.
+func (r testResponse) ids() []string {
+	var ids []string
+	for _, item := range r.list() {
+		ids = append(ids, item.(map[string]interface{})["id"].(string))
+	}
+	return ids
+}
+
+func newProduct(name string, stock int) map[string]interface{} {
+	return map[string]interface{}{
+		"name":           name,
+		"price":          10000,
+		"stock":          stock,
+		"condition":      "new",
+		"tags":           []string{"test"},
+		"isPurchaseable": true,
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice01")
+
+	s.expect(s.do("POST", "/v1/user/register", "", map[string]string{
+		"username": "alice01",
+		"name":     "alice again",
+		"password": "password",
+	}), http.StatusConflict, "USERNAME_TAKEN")
+
+	response := s.do("POST", "/v1/user/login", "", map[string]string{"username": "alice01", "password": "password"})
+	s.expect(response, http.StatusOK, "")
+	if response.data()["accessToken"] == "" {
+		t.Fatal("login returned no access token")
+	}
+
+	s.expect(s.do("POST", "/v1/user/login", "", map[string]string{"username": "alice01", "password": "wrongpass"}),
+		http.StatusUnauthorized, "INVALID_CREDENTIALS")
+	s.expect(s.do("POST", "/v1/user/login", "", map[string]string{"username": "nobody1", "password": "password"}),
+		http.StatusNotFound, "USER_NOT_FOUND")
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	s := newTestServer(t)
+	s.register("alice01")
+	login := func() (string, string) {
+		response := s.do("POST", "/v1/user/login", "", map[string]string{"username": "alice01", "password": "password"})
+		s.expect(response, http.StatusOK, "")
+		return response.data()["accessToken"].(string), response.data()["refreshToken"].(string)
+	}
+	refresh := func(refreshToken string) testResponse {
+		return s.do("POST", "/v1/user/refresh", "", map[string]string{"refreshToken": refreshToken})
+	}
+
+	_, first := login()
+	response := refresh(first)
+	s.expect(response, http.StatusOK, "")
+	second := response.data()["refreshToken"].(string)
+	if second == first {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	s.expect(s.do("GET", "/v1/user/purchases", response.data()["accessToken"].(string), nil), http.StatusOK, "")
+
+	// reusing a rotated token means it leaked, every token of the user goes
+	s.expect(refresh(first), http.StatusUnauthorized, "INVALID_REFRESH_TOKEN")
+	s.expect(refresh(second), http.StatusUnauthorized, "INVALID_REFRESH_TOKEN")
+
+	accessToken, refreshToken := login()
+	s.expect(s.do("POST", "/v1/user/logout", accessToken, map[string]string{"refreshToken": refreshToken}), http.StatusOK, "")
+	s.expect(s.do("GET", "/v1/user/purchases", accessToken, nil), http.StatusUnauthorized, "UNAUTHORIZED")
+	s.expect(refresh(refreshToken), http.StatusUnauthorized, "INVALID_REFRESH_TOKEN")
+	s.expect(refresh("not-a-token"), http.StatusUnauthorized, "INVALID_REFRESH_TOKEN")
+}
+
+// login signs username in again, picking up role changes.
+func (s *testServer) login(username string) string {
+	s.t.Helper()
+
+	response := s.do("POST", "/v1/user/login", "", map[string]string{"username": username, "password": "password"})
+	s.expect(response, http.StatusOK, "")
+	return response.data()["accessToken"].(string)
+}
+
+func TestUpdateUserRoles(t *testing.T) {
+	s := newTestServer(t)
+	_, adminId := s.register("admin01")
+	if err := s.store.UpdateUserRoles(adminId, []domain.RoleEnum{domain.RoleAdmin}); err != nil {
+		t.Fatal(err)
+	}
+	adminToken := s.login("admin01")
+	userToken, userId := s.register("user001")
+
+	s.expect(s.do("PUT", "/v1/admin/users/"+userId+"/roles", userToken, map[string]interface{}{"roles": []string{"admin"}}),
+		http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("PUT", "/v1/admin/users/"+userId+"/roles", adminToken, map[string]interface{}{"roles": []string{}}),
+		http.StatusBadRequest, "VALIDATION_FAILED")
+	s.expect(s.do("PUT", "/v1/admin/users/"+userId+"/roles", adminToken, map[string]interface{}{"roles": []string{"owner"}}),
+		http.StatusBadRequest, "INVALID_ROLES")
+	s.expect(s.do("PUT", "/v1/admin/users/"+userId+"/roles", adminToken, map[string]interface{}{"roles": []string{"buyer"}}),
+		http.StatusOK, "")
+
+	s.expect(s.do("POST", "/v1/product", s.login("user001"), newProduct("buyer only product", 1)), http.StatusForbidden, "FORBIDDEN")
+}
+
+func TestProductRequiresToken(t *testing.T) {
+	s := newTestServer(t)
+
+	s.expect(s.do("POST", "/v1/product", "", newProduct("no token product", 1)), http.StatusForbidden, "TOKEN_MISSING")
+	s.expect(s.do("POST", "/v1/product", "not-a-token", newProduct("bad token product", 1)), http.StatusUnauthorized, "UNAUTHORIZED")
+}
+
+func TestProductLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("seller01")
+	otherToken, _ := s.register("seller02")
+
+	productId := s.createProduct(token, userId, newProduct("lifecycle product", 5))
+
+	response := s.do("GET", "/v1/product/"+productId, token, nil)
+	s.expect(response, http.StatusOK, "")
+
+	update := newProduct("renamed product", 5)
+	update["imageUrl"] = response.data()["product"].(map[string]interface{})["imageUrl"]
+	s.expect(s.do("PATCH", "/v1/product/"+productId, otherToken, update), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("PATCH", "/v1/product/"+productId, token, update), http.StatusOK, "")
+
+	s.expect(s.do("DELETE", "/v1/product/"+productId, otherToken, nil), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("DELETE", "/v1/product/"+productId, token, nil), http.StatusOK, "")
+	s.expect(s.do("GET", "/v1/product/"+productId, token, nil), http.StatusNotFound, "PRODUCT_NOT_FOUND")
+	s.expect(s.do("GET", "/v1/product/"+productId+"?includeArchived=true", token, nil), http.StatusOK, "")
+
+	archived := s.do("GET", "/v1/product?userOnly=true&archived=true&showEmptyStock=true", token, nil)
+	s.expect(archived, http.StatusOK, "")
+	if len(archived.list()) != 1 {
+		t.Fatalf("got %d archived products, want 1", len(archived.list()))
+	}
+
+	s.expect(s.do("POST", "/v1/product/"+productId+"/restore", token, nil), http.StatusOK, "")
+	s.expect(s.do("GET", "/v1/product/"+productId, token, nil), http.StatusOK, "")
+
+	missingId := "6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f"
+	s.expect(s.do("GET", "/v1/product/"+missingId, token, nil), http.StatusNotFound, "PRODUCT_NOT_FOUND")
+	s.expect(s.do("POST", "/v1/cart/items", token, map[string]interface{}{"productId": missingId, "quantity": 1}), http.StatusNotFound, "PRODUCT_NOT_FOUND")
+}
+
+func TestSearchCursorPagination(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("seller01")
+
+	for i := 1; i <= 5; i++ {
+		product := newProduct(fmt.Sprintf("paged product %d", i), 1)
+		product["price"] = i * 1000
+		s.createProduct(token, userId, product)
+	}
+
+	var seen []interface{}
+	path := "/v1/product?limit=2&sortBy=price&orderBy=asc"
+	for page := 0; path != ""; page++ {
+		if page > 5 {
+			t.Fatal("cursor pagination does not end")
+		}
+		response := s.do("GET", path, token, nil)
+		s.expect(response, http.StatusOK, "")
+		for _, item := range response.list() {
+			seen = append(seen, item.(map[string]interface{})["price"])
+		}
+
+		path = ""
+		if next, ok := response.body["meta"].(map[string]interface{})["nextCursor"].(string); ok {
+			path = "/v1/product?limit=2&sortBy=price&orderBy=asc&cursor=" + url.QueryEscape(next)
+		}
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("got %d products over all pages, want 5: %v", len(seen), seen)
+	}
+	for i, price := range seen {
+		if price != float64((i+1)*1000) {
+			t.Fatalf("got prices %v, want ascending without repeats", seen)
+		}
+	}
+}
+
+func TestBuyWithVariants(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+
+	product := newProduct("variant product", 0)
+	product["variants"] = []map[string]interface{}{
+		{"sku": "VAR-S", "options": map[string]string{"size": "S"}, "stock": 1},
+		{"sku": "VAR-M", "options": map[string]string{"size": "M"}, "stock": 3},
+	}
+	productId := s.createProduct(sellerToken, sellerId, product)
+
+	response := s.do("GET", "/v1/product/"+productId, buyerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	details := response.data()["product"].(map[string]interface{})
+	if details["stock"] != float64(4) {
+		t.Fatalf("got stock %v, want the variants total 4", details["stock"])
+	}
+	variantId := details["variants"].([]interface{})[0].(map[string]interface{})["id"].(string)
+
+	buy := map[string]interface{}{"bankAccountId": bankAccountId, "quantity": 1}
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, buy), http.StatusBadRequest, "VARIANT_REQUIRED")
+
+	buy["variantId"] = variantId
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, buy), http.StatusCreated, "")
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, buy), http.StatusBadRequest, "INSUFFICIENT_STOCK")
+}
+
+func TestOrderLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, buyerId := s.register("buyer01")
+	otherToken, _ := s.register("buyer02")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("ordered product", 5))
+	proof := map[string]string{"paymentProofImageUrl": s.image(buyerId, "proof.jpg")}
+
+	orderId := s.buy(buyerToken, productId, bankAccountId, 2)
+	if stock := s.stock(buyerToken, productId); stock != 3 {
+		t.Fatalf("got stock %v, want 3 after ordering 2", stock)
+	}
+
+	response := s.do("GET", "/v1/order/"+orderId, sellerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if status := response.data()["status"]; status != "pending" {
+		t.Fatalf("got status %v, want pending", status)
+	}
+	s.expect(s.do("GET", "/v1/order/"+orderId, otherToken, nil), http.StatusForbidden, "FORBIDDEN")
+
+	// the seller can only act once the buyer sent a proof
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/confirm", sellerToken, nil), http.StatusConflict, "INVALID_STATUS_TRANSITION")
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/proof", buyerToken, proof), http.StatusOK, "")
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/confirm", buyerToken, nil), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/confirm", sellerToken, nil), http.StatusOK, "")
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/cancel", buyerToken, nil), http.StatusConflict, "INVALID_STATUS_TRANSITION")
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/complete", buyerToken, nil), http.StatusConflict, "INVALID_STATUS_TRANSITION")
+	s.expect(s.do("POST", "/v1/order/"+orderId+"/ship", sellerToken, nil), http.StatusOK, "")
+
+	response = s.do("POST", "/v1/order/"+orderId+"/complete", buyerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if status := response.data()["status"]; status != "completed" {
+		t.Fatalf("got status %v, want completed", status)
+	}
+	if stock := s.stock(buyerToken, productId); stock != 3 {
+		t.Fatalf("got stock %v, want 3 after a completed order", stock)
+	}
+}
+
+func TestCancelAndRejectRestoreStock(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, buyerId := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("restored product", 5))
+
+	cancelled := s.buy(buyerToken, productId, bankAccountId, 2)
+	s.expect(s.do("POST", "/v1/order/"+cancelled+"/cancel", sellerToken, nil), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("POST", "/v1/order/"+cancelled+"/cancel", buyerToken, nil), http.StatusOK, "")
+	if stock := s.stock(buyerToken, productId); stock != 5 {
+		t.Fatalf("got stock %v, want 5 after cancelling", stock)
+	}
+	// a cancelled order is final, its stock is not given back twice
+	s.expect(s.do("POST", "/v1/order/"+cancelled+"/cancel", buyerToken, nil), http.StatusConflict, "INVALID_STATUS_TRANSITION")
+
+	rejected := s.buy(buyerToken, productId, bankAccountId, 3)
+	s.expect(s.do("POST", "/v1/order/"+rejected+"/proof", buyerToken, map[string]string{
+		"paymentProofImageUrl": s.image(buyerId, "proof.jpg"),
+	}), http.StatusOK, "")
+	s.expect(s.do("POST", "/v1/order/"+rejected+"/reject", sellerToken, nil), http.StatusOK, "")
+	if stock := s.stock(buyerToken, productId); stock != 5 {
+		t.Fatalf("got stock %v, want 5 after rejecting", stock)
+	}
+	s.expect(s.do("POST", "/v1/order/"+rejected+"/reject", sellerToken, nil), http.StatusConflict, "INVALID_STATUS_TRANSITION")
+}
+
+func TestSellerPaymentFilters(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	otherSellerToken, otherSellerId := s.register("seller02")
+	buyerToken, buyerId := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	otherBankAccountId := s.addBankAccount(otherSellerToken)
+	shoes := s.createProduct(sellerToken, sellerId, newProduct("seller shoes", 10))
+	shirts := s.createProduct(sellerToken, sellerId, newProduct("seller shirts", 10))
+	hats := s.createProduct(otherSellerToken, otherSellerId, newProduct("other seller hats", 10))
+
+	first := s.buy(buyerToken, shoes, bankAccountId, 1)
+	second := s.buy(buyerToken, shoes, bankAccountId, 2)
+	third := s.buy(buyerToken, shirts, bankAccountId, 1)
+	s.buy(buyerToken, hats, otherBankAccountId, 1)
+	s.expect(s.do("POST", "/v1/order/"+second+"/proof", buyerToken, map[string]string{
+		"paymentProofImageUrl": s.image(buyerId, "proof.jpg"),
+	}), http.StatusOK, "")
+
+	today := time.Now().UTC().Format("2006-01-02")
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	tests := []struct {
+		query string
+		total float64
+		ids   []string
+	}{
+		{"", 3, []string{third, second, first}},
+		{"?productId=" + shoes, 2, []string{second, first}},
+		{"?status=proof_submitted", 1, []string{second}},
+		{"?status=pending&productId=" + shoes, 1, []string{first}},
+		{"?from=" + today + "&to=" + today, 3, []string{third, second, first}},
+		{"?from=" + tomorrow, 0, nil},
+		{"?limit=1&offset=1", 3, []string{second}},
+	}
+	for _, tt := range tests {
+		response := s.do("GET", "/v1/seller/payments"+tt.query, sellerToken, nil)
+		s.expect(response, http.StatusOK, "")
+		if response.total() != tt.total || fmt.Sprint(response.ids()) != fmt.Sprint(tt.ids) {
+			t.Fatalf("%q: got %v of %v, want %v of %v", tt.query, response.ids(), response.total(), tt.ids, tt.total)
+		}
+	}
+
+	s.expect(s.do("GET", "/v1/seller/payments?status=paid", sellerToken, nil), http.StatusBadRequest, "INVALID_FILTER")
+	s.expect(s.do("GET", "/v1/seller/payments?from=yesterday", sellerToken, nil), http.StatusBadRequest, "INVALID_FILTER")
+
+	listed := s.do("GET", "/v1/seller/payments?status=proof_submitted", sellerToken, nil).list()[0].(map[string]interface{})
+	if listed["productName"] != "seller shoes" || listed["buyerName"] != "buyer01 name" || listed["bankAccount"].(map[string]interface{})["id"] != bankAccountId {
+		t.Fatalf("got %v, want the product, buyer and bank account of the payment", listed)
+	}
+
+	s.expect(s.do("POST", "/v1/seller/payments/"+second+"/accept", otherSellerToken, nil), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("POST", "/v1/seller/payments/"+first+"/accept", sellerToken, nil), http.StatusConflict, "INVALID_STATUS_TRANSITION")
+	response := s.do("POST", "/v1/seller/payments/"+second+"/accept", sellerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if status := response.data()["status"]; status != "confirmed_by_seller" {
+		t.Fatalf("got status %v, want confirmed_by_seller", status)
+	}
+}
+
+func TestPurchaseHistoryFilters(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+	otherToken, _ := s.register("buyer02")
+	bankAccountId := s.addBankAccount(sellerToken)
+	shoes := s.createProduct(sellerToken, sellerId, newProduct("history shoes", 10))
+	shirts := s.createProduct(sellerToken, sellerId, newProduct("history shirts", 10))
+
+	first := s.buy(buyerToken, shoes, bankAccountId, 1)
+	second := s.buy(buyerToken, shirts, bankAccountId, 2)
+	third := s.buy(buyerToken, shoes, bankAccountId, 1)
+	s.buy(otherToken, shoes, bankAccountId, 1)
+	s.expect(s.do("POST", "/v1/order/"+first+"/cancel", buyerToken, nil), http.StatusOK, "")
+
+	tomorrow := time.Now().UTC().AddDate(0, 0, 1).Format("2006-01-02")
+	tests := []struct {
+		query string
+		total float64
+		ids   []string
+	}{
+		{"", 3, []string{third, second, first}},
+		{"?productId=" + shoes, 2, []string{third, first}},
+		{"?status=cancelled", 1, []string{first}},
+		{"?status=pending&productId=" + shoes, 1, []string{third}},
+		{"?to=" + time.Now().UTC().Add(time.Minute).Format(time.RFC3339), 3, []string{third, second, first}},
+		{"?from=" + tomorrow, 0, nil},
+		{"?limit=2", 3, []string{third, second}},
+		{"?limit=2&offset=2", 3, []string{first}},
+	}
+	for _, tt := range tests {
+		response := s.do("GET", "/v1/user/purchases"+tt.query, buyerToken, nil)
+		s.expect(response, http.StatusOK, "")
+		if response.total() != tt.total || fmt.Sprint(response.ids()) != fmt.Sprint(tt.ids) {
+			t.Fatalf("%q: got %v of %v, want %v of %v", tt.query, response.ids(), response.total(), tt.ids, tt.total)
+		}
+	}
+
+	s.expect(s.do("GET", "/v1/user/purchases?status=unknown", buyerToken, nil), http.StatusBadRequest, "INVALID_FILTER")
+	s.expect(s.do("GET", "/v1/user/purchases?to=2024-13-01", buyerToken, nil), http.StatusBadRequest, "INVALID_FILTER")
+
+	purchase := s.do("GET", "/v1/user/purchases?productId="+shirts, buyerToken, nil).list()[0].(map[string]interface{})
+	if purchase["productName"] != "history shirts" || purchase["sellerName"] != "seller01 name" || purchase["quantity"] != float64(2) {
+		t.Fatalf("got %v, want the product, seller and quantity of the purchase", purchase)
+	}
+}
+
+func TestStockReservation(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+	otherToken, _ := s.register("buyer02")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("reserved product", 3))
+
+	response := s.do("POST", "/v1/product/"+productId+"/reserve", buyerToken, map[string]interface{}{"quantity": 2})
+	s.expect(response, http.StatusCreated, "")
+	reservationId := response.data()["id"].(string)
+
+	// one reservation per buyer and product, the rest stays for others
+	s.expect(s.do("POST", "/v1/product/"+productId+"/reserve", buyerToken, map[string]interface{}{"quantity": 1}),
+		http.StatusConflict, "RESERVATION_EXISTS")
+
+	// other buyers only see and can only buy what is not reserved
+	response = s.do("GET", "/v1/product/"+productId, otherToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if stock := response.data()["product"].(map[string]interface{})["stock"]; stock != float64(1) {
+		t.Fatalf("got stock %v, want 1 left after the reservation", stock)
+	}
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", otherToken, map[string]interface{}{
+		"bankAccountId": bankAccountId,
+		"quantity":      2,
+	}), http.StatusBadRequest, "INSUFFICIENT_STOCK")
+
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", otherToken, map[string]interface{}{
+		"bankAccountId": bankAccountId,
+		"quantity":      2,
+		"reservationId": reservationId,
+	}), http.StatusNotFound, "RESERVATION_NOT_FOUND")
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, map[string]interface{}{
+		"bankAccountId": bankAccountId,
+		"quantity":      1,
+		"reservationId": reservationId,
+	}), http.StatusBadRequest, "RESERVATION_MISMATCH")
+	s.expect(s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, map[string]interface{}{
+		"bankAccountId": bankAccountId,
+		"quantity":      2,
+		"reservationId": reservationId,
+	}), http.StatusCreated, "")
+
+	response = s.do("GET", "/v1/product/"+productId, otherToken, nil)
+	if stock := response.data()["product"].(map[string]interface{})["stock"]; stock != float64(1) {
+		t.Fatalf("got stock %v, want 1 left after the reserved sale", stock)
+	}
+
+	// paying used the reservation up, so the buyer may reserve again
+	s.expect(s.do("POST", "/v1/product/"+productId+"/reserve", buyerToken, map[string]interface{}{"quantity": 1}),
+		http.StatusCreated, "")
+}
+
+func TestIdempotentBuyIsReplayed(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("idempotent product", 5))
+
+	buy := func(quantity int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"bankAccountId": bankAccountId, "quantity": quantity})
+		req := httptest.NewRequest("POST", "/v1/product/"+productId+"/buy", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+buyerToken)
+		req.Header.Set(middleware.IdempotencyKeyHeader, "buy-once")
+		rec := httptest.NewRecorder()
+		s.e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first, second := buy(2), buy(2)
+	if first.Code != http.StatusCreated || second.Code != http.StatusCreated {
+		t.Fatalf("got %d and %d, want both 201", first.Code, second.Code)
+	}
+	if second.Header().Get(middleware.IdempotencyReplayedHeader) != "true" {
+		t.Fatal("retry was not replayed")
+	}
+
+	changed := buy(1)
+	if changed.Code != http.StatusUnprocessableEntity || !strings.Contains(changed.Body.String(), "IDEMPOTENCY_KEY_REUSED") {
+		t.Fatalf("got %d %s for a retry with another body, want 422 IDEMPOTENCY_KEY_REUSED", changed.Code, changed.Body.String())
+	}
+
+	response := s.do("GET", "/v1/product/"+productId, buyerToken, nil)
+	if stock := response.data()["product"].(map[string]interface{})["stock"]; stock != float64(3) {
+		t.Fatalf("got stock %v, want 3 after a single purchase", stock)
+	}
+}
+
+func TestConfirmUploadRejectsForeignKeys(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("uploader")
+	_, victimId := s.register("victim")
+
+	victimKey := "incoming/" + victimId + "/photo.jpg"
+	if _, err := s.images.Save(context.Background(), victimKey, strings.NewReader("victim photo"), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{
+		victimKey,
+		"incoming/" + userId + "/../" + victimId + "/photo.jpg",
+		"incoming/" + userId + "//../" + victimId + "/photo.jpg",
+		"incoming/" + userId + "/./photo.jpg",
+		"uploads/" + userId + "/photo.jpg",
+	} {
+		s.expect(s.do("POST", "/v1/image/confirm", token, map[string]string{"key": key}), http.StatusForbidden, "FORBIDDEN")
+	}
+}
+
+// rawJPEG is a noisy JPEG above the minimum upload size carrying an EXIF
+// segment with marker in it.
+func rawJPEG(t *testing.T, marker string) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 200, 200))
+	random := rand.New(rand.NewSource(1))
+	random.Read(img.Pix)
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := "Exif\x00\x00" + marker
+	segment := []byte{0xFF, 0xE1, byte((len(payload) + 2) >> 8), byte(len(payload) + 2)}
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), append(segment, payload...)...), data[2:]...)
+}
+
+func TestConfirmUploadProcessesImage(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("uploader")
+	ctx := context.Background()
+
+	raw := rawJPEG(t, "secret location")
+	presigned := s.do("POST", "/v1/image/presign", token, map[string]interface{}{"contentType": "image/jpeg", "size": len(raw)})
+	s.expect(presigned, http.StatusCreated, "")
+	key := presigned.data()["key"].(string)
+	if _, err := s.images.Save(ctx, key, bytes.NewReader(raw), "image/jpeg"); err != nil {
+		t.Fatal(err)
+	}
+
+	confirmed := s.do("POST", "/v1/image/confirm", token, map[string]string{"key": key})
+	s.expect(confirmed, http.StatusCreated, "")
+	asset, err := s.store.GetAssetByURL(confirmed.data()["url"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(asset.Key, "uploads/"+userId+"/") || asset.Rendition != "original" {
+		t.Fatalf("got asset %+v, want the processed original", asset)
+	}
+
+	object, err := s.images.Open(ctx, asset.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer object.Close()
+	processed, err := io.ReadAll(object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(processed, []byte("secret location")) {
+		t.Fatal("processed image still carries the uploaded metadata")
+	}
+
+	if _, err := s.images.Stat(ctx, key); err != storage.ErrObjectNotFound {
+		t.Fatalf("got %v for the raw upload, want it deleted", err)
+	}
+	s.expect(s.do("POST", "/v1/image/confirm", token, map[string]string{"key": key}), http.StatusNotFound, "UPLOAD_NOT_FOUND")
+}
+
+func TestSearchHighlightIsEscaped(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("seller01")
+	s.createProduct(token, userId, newProduct(`<img src=x onerror=alert(1)> boots`, 1))
+
+	response := s.do("GET", "/v1/product?search=boots", token, nil)
+	s.expect(response, http.StatusOK, "")
+	if len(response.list()) != 1 {
+		t.Fatalf("got %d products, want 1", len(response.list()))
+	}
+	highlight := response.list()[0].(map[string]interface{})["highlight"]
+	if want := "&lt;img src=x onerror=alert(1)&gt; <mark>boots</mark> test"; highlight != want {
+		t.Fatalf("got highlight %q, want %q", highlight, want)
+	}
+}
+
+func TestCheckoutIsAllOrNothing(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	plentyId := s.createProduct(sellerToken, sellerId, newProduct("plenty product", 5))
+	scarceId := s.createProduct(sellerToken, sellerId, newProduct("scarce product", 1))
+
+	s.expect(s.do("POST", "/v1/cart/items", buyerToken, map[string]interface{}{"productId": plentyId, "quantity": 2}), http.StatusOK, "")
+	s.expect(s.do("POST", "/v1/cart/items", buyerToken, map[string]interface{}{"productId": scarceId, "quantity": 3}), http.StatusOK, "")
+	checkout := map[string]interface{}{
+		"payments": []map[string]string{{"sellerId": sellerId, "bankAccountId": bankAccountId}},
+	}
+
+	response := s.do("POST", "/v1/cart/checkout", buyerToken, checkout)
+	s.expect(response, http.StatusBadRequest, "INSUFFICIENT_STOCK")
+	if details, _ := response.body["details"].(map[string]interface{}); details["productId"] != scarceId {
+		t.Fatalf("got details %v, want the scarce product", response.body["details"])
+	}
+	if stock := s.stock(buyerToken, plentyId); stock != 5 {
+		t.Fatalf("got stock %v, want 5 after a failed checkout", stock)
+	}
+	response = s.do("GET", "/v1/user/purchases", buyerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if len(response.list()) != 0 {
+		t.Fatalf("got %d purchases after a failed checkout, want none", len(response.list()))
+	}
+	response = s.do("GET", "/v1/cart", buyerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if items := response.data()["items"].([]interface{}); len(items) != 2 {
+		t.Fatalf("got %d cart items after a failed checkout, want 2", len(items))
+	}
+
+	s.expect(s.do("PATCH", "/v1/cart/items/"+scarceId, buyerToken, map[string]interface{}{"quantity": 1}), http.StatusOK, "")
+	s.expect(s.do("POST", "/v1/cart/checkout", buyerToken, checkout), http.StatusCreated, "")
+	if stock := s.stock(buyerToken, plentyId); stock != 3 {
+		t.Fatalf("got stock %v, want 3 after checking out 2", stock)
+	}
+	if stock := s.stock(buyerToken, scarceId); stock != 0 {
+		t.Fatalf("got stock %v, want 0 after checking out the last one", stock)
+	}
+	response = s.do("GET", "/v1/cart", buyerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if items, _ := response.data()["items"].([]interface{}); len(items) != 0 {
+		t.Fatalf("got %d cart items after checkout, want none", len(items))
+	}
+}
+
+// upload sends an image through the presigned flow and returns the URL of
+// its processed original.
+func (s *testServer) upload(token string) string {
+	s.t.Helper()
+
+	raw := rawJPEG(s.t, "")
+	presigned := s.do("POST", "/v1/image/presign", token, map[string]interface{}{"contentType": "image/jpeg", "size": len(raw)})
+	s.expect(presigned, http.StatusCreated, "")
+	key := presigned.data()["key"].(string)
+	if _, err := s.images.Save(context.Background(), key, bytes.NewReader(raw), "image/jpeg"); err != nil {
+		s.t.Fatal(err)
+	}
+
+	confirmed := s.do("POST", "/v1/image/confirm", token, map[string]string{"key": key})
+	s.expect(confirmed, http.StatusCreated, "")
+	return confirmed.data()["url"].(string)
+}
+
+// invalidFields lists the fields a validation error reports.
+func (r testResponse) invalidFields() []string {
+	var fields []string
+	details, _ := r.body["details"].([]interface{})
+	for _, detail := range details {
+		fields = append(fields, detail.(map[string]interface{})["field"].(string))
+	}
+	return fields
+}
+
+func TestAssetOwnership(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	otherToken, _ := s.register("seller02")
+	buyerToken, _ := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("owned product", 5))
+	otherImage := s.upload(otherToken)
+
+	for name, imageURL := range map[string]string{
+		"someone else's upload": otherImage,
+		"an unknown url":        "https://images.example.com/elsewhere.jpg",
+	} {
+		product := newProduct("borrowed product", 5)
+		product["imageUrl"] = imageURL
+		response := s.do("POST", "/v1/product", sellerToken, product)
+		s.expect(response, http.StatusBadRequest, "VALIDATION_FAILED")
+		if fields := response.invalidFields(); len(fields) != 1 || fields[0] != "imageUrl" {
+			t.Fatalf("%s: got invalid fields %v, want imageUrl", name, fields)
+		}
+	}
+
+	response := s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, map[string]interface{}{
+		"bankAccountId":        bankAccountId,
+		"quantity":             1,
+		"paymentProofImageUrl": otherImage,
+	})
+	s.expect(response, http.StatusBadRequest, "VALIDATION_FAILED")
+	if fields := response.invalidFields(); len(fields) != 1 || fields[0] != "paymentProofImageUrl" {
+		t.Fatalf("got invalid fields %v, want paymentProofImageUrl", fields)
+	}
+
+	response = s.do("POST", "/v1/product/"+productId+"/buy", buyerToken, map[string]interface{}{
+		"bankAccountId":        bankAccountId,
+		"quantity":             1,
+		"paymentProofImageUrl": s.upload(buyerToken),
+	})
+	s.expect(response, http.StatusCreated, "")
+}
+
+func TestCollectOrphanedAssets(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	sellerToken, _ := s.register("seller01")
+	usedURL := s.upload(sellerToken)
+	orphanURL := s.upload(sellerToken)
+
+	product := newProduct("pictured product", 5)
+	product["imageUrl"] = usedURL
+	s.expect(s.do("POST", "/v1/product", sellerToken, product), http.StatusCreated, "")
+
+	// every rendition of an upload shares the key of its original
+	keysOf := func(url string) []string {
+		original, err := s.store.GetAssetByURL(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		prefix := strings.TrimSuffix(original.Key, ".jpg")
+		keys, err := s.images.List(ctx, "uploads/", time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var group []string
+		for _, key := range keys {
+			if strings.HasPrefix(key, prefix) {
+				group = append(group, key)
+			}
+		}
+		if len(group) < 2 {
+			t.Fatalf("got keys %v for %s, want the original and its renditions", group, url)
+		}
+		return group
+	}
+	usedKeys, orphanKeys := keysOf(usedURL), keysOf(orphanURL)
+
+	// nothing is collected within the grace period
+	if err := job.CollectOrphanedAssets(ctx, s.images, s.store, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetAssetByURL(orphanURL); err != nil {
+		t.Fatalf("got %v, want the orphan kept during the grace period", err)
+	}
+
+	if err := job.CollectOrphanedAssets(ctx, s.images, s.store, -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.GetAssetByURL(orphanURL); err != repository.ErrAssetNotFound {
+		t.Fatalf("got %v for the orphan, want it collected", err)
+	}
+	for _, key := range orphanKeys {
+		if _, err := s.images.Stat(ctx, key); err != storage.ErrObjectNotFound {
+			t.Fatalf("got %v for %s, want it deleted", err, key)
+		}
+	}
+	if _, err := s.store.GetAssetByURL(usedURL); err != nil {
+		t.Fatalf("got %v for the product image, want it kept", err)
+	}
+	for _, key := range usedKeys {
+		if _, err := s.images.Stat(ctx, key); err != nil {
+			t.Fatalf("got %v for %s, want it kept", err, key)
+		}
+	}
+}
+
+// names lists the "name" of every item of a listing, sorted.
+func (r testResponse) names() []string {
+	var names []string
+	for _, item := range r.list() {
+		names = append(names, item.(map[string]interface{})["name"].(string))
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestTagMatchAndPopularTags(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("seller01")
+	for name, tags := range map[string][]string{
+		"red shoe":   {"red", "shoe"},
+		"red hat":    {"red"},
+		"blue shoe":  {"shoe", "sale"},
+		"green sock": {"sale"},
+	} {
+		product := newProduct(name, 5)
+		product["tags"] = tags
+		s.createProduct(token, userId, product)
+	}
+
+	for _, tt := range []struct {
+		query string
+		want  []string
+	}{
+		{"tags=red,shoe", []string{"blue shoe", "red hat", "red shoe"}},
+		{"tags=red&tags=shoe&tagMatch=any", []string{"blue shoe", "red hat", "red shoe"}},
+		{"tags=red,shoe&tagMatch=all", []string{"red shoe"}},
+		{"tags=shoe,sale&tagMatch=all", []string{"blue shoe"}},
+		{"tags=red,sock&tagMatch=all", nil},
+	} {
+		response := s.do("GET", "/v1/product?"+tt.query, token, nil)
+		s.expect(response, http.StatusOK, "")
+		if got := response.names(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.query, got, tt.want)
+		}
+	}
+	s.expect(s.do("GET", "/v1/product?tags=red&tagMatch=some", token, nil), http.StatusBadRequest, "INVALID_FILTER")
+
+	response := s.do("GET", "/v1/product/tags", token, nil)
+	s.expect(response, http.StatusOK, "")
+	if got := fmt.Sprint(response.list()); got != "[map[count:2 tag:red] map[count:2 tag:sale] map[count:2 tag:shoe]]" {
+		t.Fatalf("got popular tags %s", got)
+	}
+	response = s.do("GET", "/v1/product/tags?limit=1", token, nil)
+	s.expect(response, http.StatusOK, "")
+	if got := fmt.Sprint(response.list()); got != "[map[count:2 tag:red]]" {
+		t.Fatalf("got popular tags %s with limit 1", got)
+	}
+}
+
+func TestSearchFacets(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("seller01")
+	for _, product := range []map[string]interface{}{
+		{"name": "cheap new", "price": 10000, "condition": "new", "stock": 5, "tags": []string{"a"}},
+		{"name": "used mid", "price": 75000, "condition": "second", "stock": 0, "tags": []string{"a", "b"}},
+		{"name": "pricey new", "price": 2000000, "condition": "new", "stock": 3, "tags": []string{"b"}},
+	} {
+		product["isPurchaseable"] = true
+		s.createProduct(token, userId, product)
+	}
+	facetsOf := func(query string) map[string]interface{} {
+		t.Helper()
+		response := s.do("GET", "/v1/product?showEmptyStock=true&"+query, token, nil)
+		s.expect(response, http.StatusOK, "")
+		meta, _ := response.body["meta"].(map[string]interface{})
+		facets, _ := meta["facets"].(map[string]interface{})
+		return facets
+	}
+	// counts renders a facet as value:count pairs
+	counts := func(facet interface{}) string {
+		var pairs []string
+		for _, item := range facet.([]interface{}) {
+			item := item.(map[string]interface{})
+			value := item["value"]
+			if value == nil {
+				value = item["tag"]
+			}
+			if value == nil {
+				value = int(item["min"].(float64))
+			}
+			pairs = append(pairs, fmt.Sprintf("%v:%v", value, item["count"]))
+		}
+		return strings.Join(pairs, " ")
+	}
+
+	facets := facetsOf("facets=condition,price,tags,stock")
+	for name, want := range map[string]string{
+		"condition": "new:2 second:1",
+		"price":     "0:1 50000:1 100000:0 250000:0 500000:0 1000000:1",
+		"tags":      "a:2 b:2",
+		"stock":     "in_stock:2 out_of_stock:1",
+	} {
+		if got := counts(facets[name]); got != want {
+			t.Errorf("%s facet: got %q, want %q", name, got, want)
+		}
+	}
+
+	// facets count the filtered results, not the whole catalogue
+	facets = facetsOf("condition=new&facets=stock&facets=tags")
+	if got := counts(facets["stock"]); got != "in_stock:2 out_of_stock:0" {
+		t.Errorf("filtered stock facet: got %q", got)
+	}
+	if got := counts(facets["tags"]); got != "a:1 b:1" {
+		t.Errorf("filtered tags facet: got %q", got)
+	}
+	if _, ok := facets["condition"]; ok {
+		t.Error("got a condition facet that was not asked for")
+	}
+
+	if facets := facetsOf(""); facets != nil {
+		t.Errorf("got facets %v without asking for any", facets)
+	}
+	s.expect(s.do("GET", "/v1/product?facets=color", token, nil), http.StatusBadRequest, "INVALID_FILTER")
+}
+
+func TestCartRejectsVariantProducts(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+
+	product := newProduct("variant product", 0)
+	product["variants"] = []map[string]interface{}{
+		{"sku": "VAR-S", "options": map[string]string{"size": "S"}, "stock": 2},
+	}
+	productId := s.createProduct(sellerToken, sellerId, product)
+
+	s.expect(s.do("POST", "/v1/cart/items", buyerToken, map[string]interface{}{"productId": productId, "quantity": 1}),
+		http.StatusBadRequest, "CART_VARIANTS_UNSUPPORTED")
+	response := s.do("GET", "/v1/cart", buyerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if items, _ := response.data()["items"].([]interface{}); len(items) != 0 {
+		t.Fatalf("got %d cart items, want the variant product left out", len(items))
+	}
+}
+
+func TestProductGallery(t *testing.T) {
+	s := newTestServer(t)
+	token, userId := s.register("seller01")
+	otherToken, _ := s.register("seller02")
+	productId := s.createProduct(token, userId, newProduct("gallery product", 5))
+	gallery := "/v1/product/" + productId + "/images"
+	// urls lists the gallery in order, the primary image first
+	urls := func(response testResponse) []string {
+		var urls []string
+		for i, item := range response.list() {
+			image := item.(map[string]interface{})
+			if image["primary"] != (i == 0) || image["position"] != float64(i) {
+				t.Fatalf("got image %v at %d", image, i)
+			}
+			urls = append(urls, image["url"].(string))
+		}
+		return urls
+	}
+
+	second, third := s.image(userId, "second.jpg"), s.image(userId, "third.jpg")
+	s.expect(s.do("POST", gallery, otherToken, map[string]string{"imageUrl": second}), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("POST", gallery, token, map[string]string{"imageUrl": "https://images.example.com/stranger.jpg"}), http.StatusBadRequest, "VALIDATION_FAILED")
+	s.expect(s.do("POST", gallery, token, map[string]string{"imageUrl": second}), http.StatusCreated, "")
+	response := s.do("POST", gallery, token, map[string]string{"imageUrl": third})
+	s.expect(response, http.StatusCreated, "")
+	first := urls(response)[0]
+	if got := urls(response); fmt.Sprint(got) != fmt.Sprint([]string{first, second, third}) {
+		t.Fatalf("got gallery %v after adding two images", got)
+	}
+	ids := response.ids()
+
+	response = s.do("PUT", gallery, token, map[string][]string{"imageIds": {ids[2], ids[0], ids[1]}})
+	s.expect(response, http.StatusOK, "")
+	if got := urls(response); fmt.Sprint(got) != fmt.Sprint([]string{third, first, second}) {
+		t.Fatalf("got gallery %v after reordering", got)
+	}
+	s.expect(s.do("PUT", gallery, token, map[string][]string{"imageIds": {ids[0], ids[1]}}), http.StatusBadRequest, "INVALID_IMAGE_ORDER")
+	s.expect(s.do("PUT", gallery, token, map[string][]string{"imageIds": {ids[0], ids[0], ids[1]}}), http.StatusBadRequest, "INVALID_IMAGE_ORDER")
+
+	response = s.do("POST", gallery+"/"+ids[1]+"/primary", token, nil)
+	s.expect(response, http.StatusOK, "")
+	if got := urls(response); fmt.Sprint(got) != fmt.Sprint([]string{second, third, first}) {
+		t.Fatalf("got gallery %v after choosing the primary image", got)
+	}
+	response = s.do("GET", "/v1/product/"+productId, token, nil)
+	s.expect(response, http.StatusOK, "")
+	if imageURL := response.data()["product"].(map[string]interface{})["imageUrl"]; imageURL != second {
+		t.Fatalf("got imageUrl %v, want the primary image %s", imageURL, second)
+	}
+	s.expect(s.do("POST", gallery+"/"+userId+"/primary", token, nil), http.StatusNotFound, "PRODUCT_IMAGE_NOT_FOUND")
+
+	// the gallery is capped and never left empty
+	for i := 3; i < 10; i++ {
+		s.expect(s.do("POST", gallery, token, map[string]string{"imageUrl": s.image(userId, fmt.Sprintf("extra%d.jpg", i))}), http.StatusCreated, "")
+	}
+	s.expect(s.do("POST", gallery, token, map[string]string{"imageUrl": s.image(userId, "one-too-many.jpg")}), http.StatusConflict, "TOO_MANY_PRODUCT_IMAGES")
+
+	for _, id := range s.do("POST", gallery+"/"+ids[1]+"/primary", token, nil).ids()[1:] {
+		s.expect(s.do("DELETE", gallery+"/"+id, token, nil), http.StatusOK, "")
+	}
+	s.expect(s.do("DELETE", gallery+"/"+ids[1], token, nil), http.StatusConflict, "LAST_PRODUCT_IMAGE")
+	s.expect(s.do("DELETE", gallery+"/"+ids[0], token, nil), http.StatusNotFound, "PRODUCT_IMAGE_NOT_FOUND")
+}
+
+// movements renders a stock history as reason:delta:stockAfter entries,
+// newest first.
+func (r testResponse) movements() []string {
+	var movements []string
+	for _, item := range r.list() {
+		movement := item.(map[string]interface{})
+		movements = append(movements, fmt.Sprintf("%v:%v:%v", movement["reason"], movement["delta"], movement["stockAfter"]))
+	}
+	return movements
+}
+
+func TestStockUpdatesAndHistory(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	otherToken, _ := s.register("seller02")
+	buyerToken, _ := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("stocked product", 5))
+	stock := "/v1/product/" + productId + "/stock"
+	update := func(token string, body map[string]interface{}) testResponse {
+		return s.do("POST", stock, token, body)
+	}
+
+	s.expect(update(otherToken, map[string]interface{}{"delta": 1}), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("POST", "/v1/product/6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f/stock", sellerToken, map[string]interface{}{"delta": 1}),
+		http.StatusNotFound, "PRODUCT_NOT_FOUND")
+	s.expect(update(sellerToken, map[string]interface{}{"delta": 1, "stock": 3}), http.StatusBadRequest, "VALIDATION_FAILED")
+	s.expect(update(sellerToken, map[string]interface{}{"delta": 0}), http.StatusBadRequest, "VALIDATION_FAILED")
+
+	for _, tt := range []struct {
+		body map[string]interface{}
+		want float64
+	}{
+		{map[string]interface{}{"delta": 3}, 8},
+		{map[string]interface{}{"delta": -2, "reason": "adjustment"}, 6},
+		{map[string]interface{}{"stock": 10}, 10},
+	} {
+		response := update(sellerToken, tt.body)
+		s.expect(response, http.StatusOK, "")
+		if got := response.data()["stock"]; got != tt.want {
+			t.Fatalf("%v: got stock %v, want %v", tt.body, got, tt.want)
+		}
+	}
+	s.expect(update(sellerToken, map[string]interface{}{"delta": -11}), http.StatusBadRequest, "INSUFFICIENT_STOCK")
+	s.buy(buyerToken, productId, bankAccountId, 1)
+
+	s.expect(s.do("GET", stock+"/history", otherToken, nil), http.StatusForbidden, "FORBIDDEN")
+	response := s.do("GET", stock+"/history", sellerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	want := []string{"sale:-1:9", "adjustment:4:10", "adjustment:-2:6", "restock:3:8", "restock:5:5"}
+	if got := response.movements(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got history %v, want %v", got, want)
+	}
+	response = s.do("GET", stock+"/history?reason=adjustment&limit=1", sellerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if got := response.movements(); response.total() != float64(2) || fmt.Sprint(got) != "[adjustment:4:10]" {
+		t.Fatalf("got adjustments %v of %v, want the latest of 2", got, response.total())
+	}
+	s.expect(s.do("GET", stock+"/history?reason=theft", sellerToken, nil), http.StatusBadRequest, "INVALID_FILTER")
+}
+
+func TestVariantStockUpdates(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	otherToken, _ := s.register("seller02")
+	buyerToken, _ := s.register("buyer01")
+
+	product := newProduct("variant product", 0)
+	product["variants"] = []map[string]interface{}{
+		{"sku": "VAR-S", "options": map[string]string{"size": "S"}, "stock": 1},
+		{"sku": "VAR-M", "options": map[string]string{"size": "M"}, "stock": 3},
+	}
+	productId := s.createProduct(sellerToken, sellerId, product)
+	response := s.do("GET", "/v1/product/"+productId, sellerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	variantId := response.data()["product"].(map[string]interface{})["variants"].([]interface{})[0].(map[string]interface{})["id"].(string)
+	variantStock := "/v1/product/" + productId + "/variants/" + variantId + "/stock"
+
+	s.expect(s.do("POST", "/v1/product/"+productId+"/stock", sellerToken, map[string]interface{}{"delta": 1}), http.StatusConflict, "PRODUCT_HAS_VARIANTS")
+	s.expect(s.do("POST", variantStock, otherToken, map[string]interface{}{"delta": 1}), http.StatusForbidden, "FORBIDDEN")
+	s.expect(s.do("POST", "/v1/product/"+productId+"/variants/"+productId+"/stock", sellerToken, map[string]interface{}{"delta": 1}),
+		http.StatusNotFound, "VARIANT_NOT_FOUND")
+
+	response = s.do("POST", variantStock, sellerToken, map[string]interface{}{"delta": 2})
+	s.expect(response, http.StatusOK, "")
+	if got := response.data()["stock"]; got != float64(3) {
+		t.Fatalf("got variant stock %v, want 3", got)
+	}
+	if got := s.stock(sellerToken, productId); got != 6 {
+		t.Fatalf("got product stock %v, want the variants total 6", got)
+	}
+	s.expect(s.do("POST", variantStock, sellerToken, map[string]interface{}{"delta": -4}), http.StatusBadRequest, "INSUFFICIENT_STOCK")
+	s.expect(s.do("POST", "/v1/product/"+productId+"/reserve", buyerToken, map[string]interface{}{"variantId": variantId, "quantity": 2}),
+		http.StatusCreated, "")
+	s.expect(s.do("POST", variantStock, sellerToken, map[string]interface{}{"stock": 1}), http.StatusConflict, "STOCK_RESERVED")
+
+	response = s.do("GET", "/v1/product/"+productId+"/stock/history?variantId="+variantId, sellerToken, nil)
+	s.expect(response, http.StatusOK, "")
+	if got := fmt.Sprint(response.movements()); got != "[restock:2:6 restock:1:1]" {
+		t.Fatalf("got variant history %s", got)
+	}
+}
+
+func TestReservedStockIsKeptForTheBuyer(t *testing.T) {
+	s := newTestServer(t)
+	sellerToken, sellerId := s.register("seller01")
+	buyerToken, _ := s.register("buyer01")
+	bankAccountId := s.addBankAccount(sellerToken)
+	productId := s.createProduct(sellerToken, sellerId, newProduct("reserved product", 3))
+	stock := "/v1/product/" + productId + "/stock"
+	reserve := func() testResponse {
+		return s.do("POST", "/v1/product/"+productId+"/reserve", buyerToken, map[string]interface{}{"quantity": 2})
+	}
+	s.expect(reserve(), http.StatusCreated, "")
+
+	// the seller cannot take away stock a buyer is paying for
+	s.expect(s.do("POST", stock, sellerToken, map[string]interface{}{"stock": 1}), http.StatusConflict, "STOCK_RESERVED")
+	s.expect(s.do("POST", stock, sellerToken, map[string]interface{}{"delta": -2}), http.StatusConflict, "STOCK_RESERVED")
+	s.expect(s.do("POST", stock, sellerToken, map[string]interface{}{"delta": -1}), http.StatusOK, "")
+
+	// the reservation holds all that is left, for this buyer only
+	s.expect(s.do("POST", "/v1/cart/items", buyerToken, map[string]interface{}{"productId": productId, "quantity": 2}), http.StatusOK, "")
+	s.expect(s.do("POST", "/v1/cart/checkout", buyerToken, map[string]interface{}{
+		"payments": []map[string]string{{"sellerId": sellerId, "bankAccountId": bankAccountId}},
+	}), http.StatusCreated, "")
+
+	// the checkout claimed the reservation, so nothing is held anymore
+	s.expect(s.do("POST", stock, sellerToken, map[string]interface{}{"delta": 2}), http.StatusOK, "")
+	if got := s.stock(sellerToken, productId); got != 2 {
+		t.Fatalf("got stock %v, want 2 with no reservation left", got)
+	}
+	s.expect(reserve(), http.StatusCreated, "")
+}
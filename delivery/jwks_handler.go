@@ -0,0 +1,14 @@
+package delivery
+
+import (
+	"net/http"
+
+	"shopifyx/auth"
+
+	"github.com/labstack/echo/v4"
+)
+
+func JWKSHandler(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(http.StatusOK, auth.GetKeySet().JWKS())
+}
@@ -0,0 +1,83 @@
+package delivery
+
+import (
+	"encoding/json"
+	"net/http"
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/util"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	OrderUpdatedSuccessfully = "order updated successfully"
+)
+
+func (h *PaymentHandler) GetOrderHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	payment, err := h.store.GetPayment(c.Param("orderId"), userId)
+	if err != nil {
+		return err
+	}
+
+	return util.PaymentResponseHandler(c, http.StatusOK, "ok", payment)
+}
+
+func (h *PaymentHandler) SubmitPaymentProofHandler(c echo.Context) error {
+	buyerId := auth.GetUserIdFromToken(c)
+
+	var proof domain.PaymentProofUpdate
+	if err := json.NewDecoder(c.Request().Body).Decode(&proof); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&proof); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if err := checkAssetOwnership(h.assets, buyerId, assetField{"paymentProofImageUrl", proof.PaymentProofImageURL}); err != nil {
+		return err
+	}
+
+	payment, err := h.store.SubmitPaymentProof(c.Param("orderId"), buyerId, proof.PaymentProofImageURL)
+	if err != nil {
+		return err
+	}
+
+	return util.PaymentResponseHandler(c, http.StatusOK, OrderUpdatedSuccessfully, payment)
+}
+
+func (h *PaymentHandler) CancelOrderHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("orderId"), domain.Cancelled)
+}
+
+func (h *PaymentHandler) ConfirmOrderHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("orderId"), domain.ConfirmedBySeller)
+}
+
+func (h *PaymentHandler) RejectOrderHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("orderId"), domain.Rejected)
+}
+
+func (h *PaymentHandler) ShipOrderHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("orderId"), domain.Shipped)
+}
+
+func (h *PaymentHandler) CompleteOrderHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("orderId"), domain.Completed)
+}
+
+func (h *PaymentHandler) updateOrderStatus(c echo.Context, paymentId string, status domain.PaymentStatusEnum) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	payment, err := h.store.UpdatePaymentStatus(paymentId, userId, status)
+	if err != nil {
+		return err
+	}
+
+	return util.PaymentResponseHandler(c, http.StatusOK, OrderUpdatedSuccessfully, payment)
+}
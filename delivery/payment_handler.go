@@ -3,58 +3,79 @@ package delivery
 import (
 	"encoding/json"
 	"net/http"
+	"shopifyx/apperror"
 	"shopifyx/auth"
-	"shopifyx/config"
 	"shopifyx/domain"
 	"shopifyx/repository"
 	"shopifyx/util"
+	"shopifyx/validation"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 const (
-	PaymentDetailsInvalid = "payment details invalid or product not purchaseable"
-	InsufficientStock     = "Insufficient stock"
-	FailedToMakePayment   = "failed to make payment"
-
-	PaymentAddedSuccessfully = "payment added successfully"
+	PaymentAddedSuccessfully  = "payment added successfully"
+	StockReservedSuccessfully = "stock reserved successfully"
 )
 
-func CreatePaymentHandler(c echo.Context) error {
+type PaymentHandler struct {
+	store  repository.PaymentStore
+	assets repository.AssetStore
+	// reservationTTL is how long reserved stock is held for a buyer
+	reservationTTL time.Duration
+}
+
+func NewPaymentHandler(store repository.PaymentStore, assets repository.AssetStore, reservationTTL time.Duration) *PaymentHandler {
+	return &PaymentHandler{store: store, assets: assets, reservationTTL: reservationTTL}
+}
+
+// ReserveStockHandler holds stock of a product for the buyer while they pay.
+// The reservation id is then sent with the payment, which must be made before
+// the reservation expires. A buyer holds at most one reservation per product.
+func (h *PaymentHandler) ReserveStockHandler(c echo.Context) error {
 	buyerId := auth.GetUserIdFromToken(c)
 
-	var payment domain.Payment
+	var reservation domain.StockReservation
 	productId := c.Param("productId")
 
-	if err := json.NewDecoder(c.Request().Body).Decode(&payment); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+	if err := json.NewDecoder(c.Request().Body).Decode(&reservation); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	tx, _ := config.GetDB().Begin()
-	defer tx.Rollback()
+	if errs := validation.Struct(&reservation); errs != nil {
+		return apperror.Validation(errs)
+	}
 
-	validBankId, productStock, sellerId, err := repository.CheckStockProductAndBankAccountValid(tx, payment.BankAccountId, productId)
-	if !validBankId || err != nil {
-		tx.Rollback()
-		return util.ErrorHandler(c, http.StatusBadRequest, PaymentDetailsInvalid)
+	reservation.ExpiresAt = time.Now().Add(h.reservationTTL)
+	if err := h.store.ReserveStock(&reservation, productId, buyerId); err != nil {
+		return err
 	}
 
-	if productStock < payment.Quantity {
-		tx.Rollback()
-		return util.ErrorHandler(c, http.StatusBadRequest, InsufficientStock)
+	return util.PaymentResponseHandler(c, http.StatusCreated, StockReservedSuccessfully, reservation)
+}
+
+func (h *PaymentHandler) CreatePaymentHandler(c echo.Context) error {
+	buyerId := auth.GetUserIdFromToken(c)
+
+	var payment domain.Payment
+	productId := c.Param("productId")
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&payment); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	if err := repository.CreatePayment(tx, &payment, productId, buyerId, sellerId); err != nil {
-		tx.Rollback()
+	if errs := validation.Struct(&payment); errs != nil {
+		return apperror.Validation(errs)
 	}
 
-	if err := repository.UpdateProductStockTx(tx, productId, productStock-payment.Quantity); err != nil {
-		tx.Rollback()
+	if err := checkAssetOwnership(h.assets, buyerId, assetField{"paymentProofImageUrl", payment.PaymentProofImageURL}); err != nil {
+		return err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToMakePayment)
+	if err := h.store.CreatePayment(&payment, productId, buyerId); err != nil {
+		return err
 	}
 
-	return util.ResponseHandler(c, http.StatusCreated, PaymentAddedSuccessfully)
+	return util.PaymentResponseHandler(c, http.StatusCreated, PaymentAddedSuccessfully, payment)
 }
@@ -3,51 +3,86 @@ package delivery
 import (
 	"encoding/json"
 	"net/http"
+	"shopifyx/apperror"
 	"shopifyx/auth"
 	"shopifyx/domain"
 	"shopifyx/repository"
 	"shopifyx/util"
+	"shopifyx/validation"
+	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
 
 const (
-	FailedToCreateProduct = "failed to create product"
-	FailedToUpdateProduct = "failed to update product"
-	FailedToDeleteProduct = "failed to delete product"
-	FailedToFetchProduct  = "failed to fetch product"
-	FailedToUpdateStock   = "failed to update stock"
-
-	ProductAddedSuccessfully   = "product added successfully"
-	ProductUpdatedSuccessfully = "product updated successfully"
-	ProductDeletedSuccessfully = "product deleted successfully"
-	StockUpdatedSuccessfully   = "stock updated successfully"
-
-	ProductNotFound = "product not found"
+	FailedToCreateProduct  = "failed to create product"
+	FailedToUpdateProduct  = "failed to update product"
+	FailedToDeleteProduct  = "failed to delete product"
+	FailedToRestoreProduct = "failed to restore product"
+	FailedToFetchProduct   = "failed to fetch product"
+	FailedToUpdateStock    = "failed to update stock"
+
+	ProductAddedSuccessfully    = "product added successfully"
+	ProductUpdatedSuccessfully  = "product updated successfully"
+	ProductDeletedSuccessfully  = "product deleted successfully"
+	ProductRestoredSuccessfully = "product restored successfully"
+	StockUpdatedSuccessfully    = "stock updated successfully"
+
+	ProductNotFound      = "product not found"
+	RestoreWindowExpired = "product was deleted too long ago to be restored"
 )
 
-func CreateProductHandler(c echo.Context) error {
+type ProductHandler struct {
+	store  repository.ProductStore
+	assets repository.AssetStore
+	// restoreWindow is how long a deleted product can still be restored
+	restoreWindow time.Duration
+}
+
+func NewProductHandler(store repository.ProductStore, assets repository.AssetStore, restoreWindow time.Duration) *ProductHandler {
+	return &ProductHandler{store: store, assets: assets, restoreWindow: restoreWindow}
+}
+
+func (h *ProductHandler) CreateProductHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 
 	var product domain.Product
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&product); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&product); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if errs := checkVariantSKUs(product.Variants); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	gallery, errs := galleryAssetFields(product.Images)
+	if errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if err := checkAssetOwnership(h.assets, userId, append([]assetField{{"imageUrl", product.ImageURL}}, gallery...)...); err != nil {
+		return err
 	}
 
-	err := repository.CreateProduct(&product, userId)
+	err := h.store.CreateProduct(&product, userId)
 
 	if err != nil {
 		if repository.IsConstrainViolations(err) {
-			return util.ErrorHandler(c, http.StatusBadRequest, RequredFieldsMissing)
+			return apperror.New(http.StatusBadRequest, apperror.CodeValidationFailed, RequredFieldsMissing)
 		}
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToCreateProduct)
+		return apperror.Internal(FailedToCreateProduct, err)
 	}
 
 	return util.ResponseHandler(c, http.StatusCreated, ProductAddedSuccessfully)
 }
 
-func UpdateProductHandler(c echo.Context) error {
+func (h *ProductHandler) UpdateProductHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 
 	productID := c.Param("productId")
@@ -55,102 +90,156 @@ func UpdateProductHandler(c echo.Context) error {
 	var updatedProduct domain.Product
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&updatedProduct); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	result, err := repository.UpdateProduct(&updatedProduct, productID, userId)
+	if errs := validation.Struct(&updatedProduct); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	// products created before uploads were tracked may keep their image
+	current, _, err := h.store.GetProductById(productID, false)
+	if err != nil || current.ImageURL != updatedProduct.ImageURL {
+		if err := checkAssetOwnership(h.assets, userId, assetField{"imageUrl", updatedProduct.ImageURL}); err != nil {
+			return err
+		}
+	}
+
+	result, err := h.store.UpdateProduct(&updatedProduct, productID, userId)
 
 	switch result {
 	case 1:
 		return util.ResponseHandler(c, http.StatusOK, ProductUpdatedSuccessfully)
 	case 2:
-		return util.ErrorHandler(c, http.StatusNotFound, ProductNotFound)
+		return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
 	case 3:
-		return util.ErrorHandler(c, http.StatusForbidden, DontHavePermission)
+		return apperror.New(http.StatusForbidden, apperror.CodeForbidden, DontHavePermission)
 	}
 
 	if err != nil {
 		if repository.IsConstrainViolations(err) {
-			return util.ErrorHandler(c, http.StatusBadRequest, RequredFieldsMissing)
+			return apperror.New(http.StatusBadRequest, apperror.CodeValidationFailed, RequredFieldsMissing)
 		}
 
 		if repository.IdNotFound(err) {
-			return util.ErrorHandler(c, http.StatusNotFound, ProductNotFound)
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
 		}
 
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToUpdateProduct)
+		return apperror.Internal(FailedToUpdateProduct, err)
 	}
 	return nil
 }
 
-func DeleteProductHandler(c echo.Context) error {
+func (h *ProductHandler) DeleteProductHandler(c echo.Context) error {
 	userId := auth.GetUserIdFromToken(c)
 
 	productID := c.Param("productId")
 
-	result, err := repository.DeleteProductById(productID, userId)
+	result, err := h.store.DeleteProductById(productID, userId)
 
 	switch result {
 	case 1:
 		return util.ResponseHandler(c, http.StatusOK, ProductDeletedSuccessfully)
 	case 2:
-		return util.ErrorHandler(c, http.StatusNotFound, ProductNotFound)
+		return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
 	case 3:
-		return util.ErrorHandler(c, http.StatusForbidden, DontHavePermission)
+		return apperror.New(http.StatusForbidden, apperror.CodeForbidden, DontHavePermission)
 	}
 
 	if err != nil {
 		if repository.IdNotFound(err) {
-			return util.ErrorHandler(c, http.StatusNotFound, ProductNotFound)
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
 		}
 
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToDeleteProduct)
+		return apperror.Internal(FailedToDeleteProduct, err)
 	}
 	return nil
 }
 
-func GetProductHandler(c echo.Context) error {
+func (h *ProductHandler) RestoreProductHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
 	productID := c.Param("productId")
 
-	product, seller, err := repository.GetProductById(productID)
+	result, err := h.store.RestoreProduct(productID, userId, time.Now().Add(-h.restoreWindow))
+
+	switch result {
+	case 1:
+		return util.ResponseHandler(c, http.StatusOK, ProductRestoredSuccessfully)
+	case 2:
+		return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
+	case 3:
+		return apperror.New(http.StatusForbidden, apperror.CodeForbidden, DontHavePermission)
+	case 4:
+		return apperror.New(http.StatusConflict, apperror.CodeRestoreWindowExpired, RestoreWindowExpired)
+	}
 
 	if err != nil {
 		if repository.IdNotFound(err) {
-			return util.ErrorHandler(c, http.StatusNotFound, ProductNotFound)
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
 		}
 
-		return util.ErrorHandler(c, http.StatusInternalServerError, err.Error())
+		return apperror.Internal(FailedToRestoreProduct, err)
 	}
-	return util.GetProductResponseHandler(c, http.StatusOK, product, seller)
+	return nil
 }
 
-func UpdateProductStockHandler(c echo.Context) error {
-	userId := auth.GetUserIdFromToken(c)
+func (h *ProductHandler) GetProductHandler(c echo.Context) error {
+	productID := c.Param("productId")
+	// archived products stay reachable for links from past payments
+	includeArchived, _ := strconv.ParseBool(c.QueryParam("includeArchived"))
+
+	product, seller, err := h.store.GetProductById(productID, includeArchived)
 
-	productId := c.Param("productId")
-	userIdFromProductId, err := repository.GetUserIdFromProductId(productId)
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToFetchProduct)
+		if err == repository.ErrProductNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
+		}
+
+		return apperror.Internal(FailedToFetchProduct, err)
 	}
+	return util.GetProductResponseHandler(c, http.StatusOK, product, seller)
+}
 
-	if userIdFromProductId != userId {
-		return util.ErrorHandler(c, http.StatusForbidden, DontHavePermission)
+func (h *ProductHandler) UpdateProductStockHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	productId := c.Param("productId")
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
 	}
 
 	var stockUpdate domain.StockUpdate
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&stockUpdate); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	err = repository.UpdateProductStock(productId, stockUpdate.Stock)
+	if errs := validation.Struct(&stockUpdate); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if errs := checkStockUpdate(&stockUpdate); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	stock, err := h.store.UpdateProductStock(productId, userId, &stockUpdate)
 
 	if err != nil {
-		if repository.IdNotFound(err) {
-			return util.ErrorHandler(c, http.StatusNotFound, ProductNotFound)
+		if err == repository.ErrProductNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
+		}
+		if err == repository.ErrProductHasVariants {
+			return apperror.New(http.StatusConflict, apperror.CodeProductHasVariants, ProductHasVariants)
+		}
+		if err == repository.ErrStockBelowZero {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInsufficientStock, StockBelowZero)
+		}
+		if err == repository.ErrStockBelowReserved {
+			return apperror.New(http.StatusConflict, apperror.CodeStockReserved, StockBelowReserved)
 		}
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToUpdateStock)
+		return apperror.Internal(FailedToUpdateStock, err)
 	}
 
-	return util.ResponseHandler(c, http.StatusOK, StockUpdatedSuccessfully)
+	return util.StockResponseHandler(c, http.StatusOK, StockUpdatedSuccessfully, stock)
 }
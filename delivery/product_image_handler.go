@@ -0,0 +1,163 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/repository"
+	"shopifyx/util"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	FailedToUpdateGallery = "failed to update product images"
+	ProductImageNotFound  = "product image not found"
+	LastProductImage      = "a product needs at least one image"
+	TooManyProductImages  = "a product can have at most 10 images"
+	InvalidImageOrder     = "imageIds must list every image of the product once"
+
+	ProductImagesUpdatedSuccessfully = "product images updated successfully"
+)
+
+// galleryAssetFields checks the extra gallery urls of a new product and
+// names them images[0], images[1]... for the asset ownership check.
+func galleryAssetFields(images []string) ([]assetField, validation.Errors) {
+	var errs validation.Errors
+	fields := make([]assetField, 0, len(images))
+	for i, url := range images {
+		field := fmt.Sprintf("images[%d]", i)
+		if validation.Struct(&domain.ProductImageCreate{ImageURL: url}) != nil {
+			errs = append(errs, validation.FieldError{Field: field, Rule: "url", Message: field + " must be a valid http or https url"})
+			continue
+		}
+		fields = append(fields, assetField{field, url})
+	}
+	return fields, errs
+}
+
+// checkProductOwner lets only the seller of the product change it.
+func (h *ProductHandler) checkProductOwner(productId, userId string) error {
+	ownerId, err := h.store.GetUserIdFromProductId(productId)
+	if err != nil {
+		if repository.DontHavePermission(err) || repository.IdNotFound(err) {
+			return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
+		}
+		return apperror.Internal(FailedToFetchProduct, err)
+	}
+	if ownerId != userId {
+		return apperror.New(http.StatusForbidden, apperror.CodeForbidden, DontHavePermission)
+	}
+	return nil
+}
+
+func productImageError(err error) error {
+	switch {
+	case err == repository.ErrProductImageNotFound:
+		return apperror.New(http.StatusNotFound, apperror.CodeProductImageNotFound, ProductImageNotFound)
+	case err == repository.ErrLastProductImage:
+		return apperror.New(http.StatusConflict, apperror.CodeLastProductImage, LastProductImage)
+	case err == repository.ErrTooManyProductImages:
+		return apperror.New(http.StatusConflict, apperror.CodeTooManyProductImages, TooManyProductImages)
+	case err == repository.ErrInvalidImageOrder:
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidImageOrder, InvalidImageOrder)
+	case err == repository.ErrProductNotFound:
+		return apperror.New(http.StatusNotFound, apperror.CodeProductNotFound, ProductNotFound)
+	case repository.IdNotFound(err):
+		return apperror.New(http.StatusNotFound, apperror.CodeProductImageNotFound, ProductImageNotFound)
+	case repository.IsConstrainViolations(err):
+		return apperror.New(http.StatusBadRequest, apperror.CodeValidationFailed, RequredFieldsMissing)
+	}
+	return apperror.Internal(FailedToUpdateGallery, err)
+}
+
+func (h *ProductHandler) AddProductImageHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+	productId := c.Param("productId")
+
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
+	}
+
+	var image domain.ProductImageCreate
+	if err := json.NewDecoder(c.Request().Body).Decode(&image); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&image); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if err := checkAssetOwnership(h.assets, userId, assetField{"imageUrl", image.ImageURL}); err != nil {
+		return err
+	}
+
+	images, err := h.store.AddProductImage(productId, image.ImageURL)
+	if err != nil {
+		return productImageError(err)
+	}
+
+	return util.ProductImagesResponseHandler(c, http.StatusCreated, ProductImagesUpdatedSuccessfully, images)
+}
+
+func (h *ProductHandler) RemoveProductImageHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+	productId := c.Param("productId")
+
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
+	}
+
+	images, err := h.store.RemoveProductImage(productId, c.Param("imageId"))
+	if err != nil {
+		return productImageError(err)
+	}
+
+	return util.ProductImagesResponseHandler(c, http.StatusOK, ProductImagesUpdatedSuccessfully, images)
+}
+
+func (h *ProductHandler) ReorderProductImagesHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+	productId := c.Param("productId")
+
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
+	}
+
+	var order domain.ProductImageOrder
+	if err := json.NewDecoder(c.Request().Body).Decode(&order); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&order); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	images, err := h.store.ReorderProductImages(productId, order.ImageIds)
+	if err != nil {
+		return productImageError(err)
+	}
+
+	return util.ProductImagesResponseHandler(c, http.StatusOK, ProductImagesUpdatedSuccessfully, images)
+}
+
+func (h *ProductHandler) SetPrimaryProductImageHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+	productId := c.Param("productId")
+
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
+	}
+
+	images, err := h.store.SetPrimaryProductImage(productId, c.Param("imageId"))
+	if err != nil {
+		return productImageError(err)
+	}
+
+	return util.ProductImagesResponseHandler(c, http.StatusOK, ProductImagesUpdatedSuccessfully, images)
+}
@@ -3,25 +3,60 @@ package delivery
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
+	"shopifyx/apperror"
 	"shopifyx/auth"
 	"shopifyx/domain"
-	"shopifyx/repository"
 	"shopifyx/util"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
 )
 
-func SearchProductHandler(c echo.Context) error {
+const (
+	InvalidSearchSort = "sortBy must be one of price, date or relevance"
+	InvalidTagMatch   = "tagMatch must be any or all"
+	InvalidCursor     = "cursor is invalid, does not match sortBy and orderBy or is combined with offset"
+	InvalidFacet      = "facets must be any of condition, price, tags or stock"
+	InvalidArchived   = "archived products can only be listed with userOnly"
+	FailedToFetchTags = "failed to fetch tags"
+)
+
+const (
+	defaultPopularTags = 20
+	maxPopularTags     = 100
+)
+
+// listQueryParam accepts both ?name=a&name=b and ?name=a,b, dropping blanks
+// and duplicates.
+func listQueryParam(c echo.Context, name string) []string {
+	var values []string
+	seen := make(map[string]bool)
+	for _, param := range c.QueryParams()[name] {
+		for _, value := range strings.Split(param, ",") {
+			value = strings.TrimSpace(value)
+			if value == "" || seen[value] {
+				continue
+			}
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (h *ProductHandler) SearchProductHandler(c echo.Context) error {
 	user := c.Get("user").(*jwt.Token)
 	claims := user.Claims.(*auth.JwtCustomClaims)
 	userId := claims.Id
 
 	userOnly, _ := strconv.ParseBool(c.QueryParam("userOnly"))
+	archived, _ := strconv.ParseBool(c.QueryParam("archived"))
 	limit, _ := strconv.Atoi(c.QueryParam("limit"))
 	offset, _ := strconv.Atoi(c.QueryParam("offset"))
-	tags := []string{c.QueryParam("tags")}
+	tags := listQueryParam(c, "tags")
+	tagMatch := util.TagMatchEnum(c.QueryParam("tagMatch"))
 	condition := domain.ConditionEnum(c.QueryParam("condition"))
 	showEmptyStock, _ := strconv.ParseBool(c.QueryParam("showEmptyStock"))
 	maxPrice, _ := strconv.Atoi(c.QueryParam("maxPrice"))
@@ -35,12 +70,33 @@ func SearchProductHandler(c echo.Context) error {
 	if sortBy == "" {
 		sortBy = util.Price
 	}
+	if tagMatch == "" {
+		tagMatch = util.TagMatchAny
+	}
+	if !tagMatch.IsValid() {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidTagMatch)
+	}
+	if !sortBy.IsValid() {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidSearchSort)
+	}
+	if archived && !userOnly {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidArchived)
+	}
+	var facets []util.FacetEnum
+	for _, value := range listQueryParam(c, "facets") {
+		facet := util.FacetEnum(value)
+		if !facet.IsValid() {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidFacet)
+		}
+		facets = append(facets, facet)
+	}
 
 	searchPagination := &util.SearchPagination{
 		UserOnly:       userOnly,
 		Limit:          limit,
 		Offset:         offset,
 		Tags:           tags,
+		TagMatch:       tagMatch,
 		Condition:      condition,
 		ShowEmptyStock: showEmptyStock,
 		MaxPrice:       maxPrice,
@@ -48,12 +104,50 @@ func SearchProductHandler(c echo.Context) error {
 		SortBy:         sortBy,
 		OrdedBy:        orderBy,
 		Search:         c.QueryParam("search"),
+		Facets:         facets,
+		Archived:       archived,
+	}
+
+	// Cursor mode ignores offset and skips the total unless asked for it,
+	// offset mode keeps counting for older clients
+	if encoded := c.QueryParam("cursor"); encoded != "" {
+		cursor, err := util.DecodeSearchCursor(encoded)
+		if err != nil || offset != 0 || cursor.SortBy != sortBy || cursor.Order != searchPagination.Order() {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidCursor)
+		}
+		searchPagination.Cursor = cursor
+	}
+	withTotal, err := strconv.ParseBool(c.QueryParam("withTotal"))
+	if err != nil {
+		withTotal = searchPagination.Cursor == nil
 	}
+	searchPagination.WithTotal = withTotal
 
-	products, total, err := repository.SearchProduct(searchPagination, userId)
+	products, page, err := h.store.SearchProduct(searchPagination, userId)
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToFetchProduct)
+		return apperror.Internal(FailedToFetchProduct, err)
+	}
+
+	return util.SerachProductPaginationResponseHandler(c, http.StatusOK, products, limit, offset, page)
+}
+
+// PopularTagsHandler lists the most used tags with how many products carry them.
+func (h *ProductHandler) PopularTagsHandler(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 {
+		limit = defaultPopularTags
+	}
+	if limit > maxPopularTags {
+		limit = maxPopularTags
+	}
+
+	tags, err := h.store.GetPopularTags(limit)
+	if err != nil {
+		return apperror.Internal(FailedToFetchTags, err)
+	}
+	if tags == nil {
+		tags = []domain.TagCount{}
 	}
 
-	return util.SerachProductPaginationResponseHandler(c, http.StatusOK, products, limit, offset, total)
+	return util.TagsResponseHandler(c, http.StatusOK, tags)
 }
@@ -0,0 +1,103 @@
+package delivery
+
+import (
+	"net/http"
+	"strconv"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/util"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+const (
+	StockBelowZero          = "stock cannot go below zero"
+	StockBelowReserved      = "stock cannot go below the quantity reserved by buyers"
+	InvalidStockFilter      = "invalid variantId, reason or date range filter"
+	FailedToFetchStockMoves = "failed to fetch stock history"
+)
+
+const defaultStockHistoryPageSize = 20
+
+// checkStockUpdate makes sure exactly one of stock and delta is sent, and
+// that a delta moves the stock at all.
+func checkStockUpdate(update *domain.StockUpdate) validation.Errors {
+	switch {
+	case update.Stock == nil && update.Delta == nil:
+		return validation.Errors{{Field: "stock", Rule: "required", Message: "stock or delta is required"}}
+	case update.Stock != nil && update.Delta != nil:
+		return validation.Errors{{Field: "delta", Rule: "excluded_with", Message: "delta cannot be sent together with stock"}}
+	case update.Delta != nil && *update.Delta == 0:
+		return validation.Errors{{Field: "delta", Rule: "ne", Message: "delta must not be 0"}}
+	}
+	return nil
+}
+
+// StockHistoryHandler lists the stock ledger of a product to its seller.
+func (h *ProductHandler) StockHistoryHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+	productId := c.Param("productId")
+
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
+	}
+
+	stockPagination, ok := stockHistoryPaginationFromQuery(c)
+	if !ok {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidStockFilter)
+	}
+
+	movements, total, err := h.store.GetStockHistory(productId, stockPagination)
+	if err != nil {
+		return apperror.Internal(FailedToFetchStockMoves, err)
+	}
+	if movements == nil {
+		movements = []domain.StockMovement{}
+	}
+
+	return util.StockHistoryResponseHandler(c, http.StatusOK, movements, stockPagination.Limit, stockPagination.Offset, total)
+}
+
+func stockHistoryPaginationFromQuery(c echo.Context) (*util.StockHistoryPagination, bool) {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+	reason := domain.StockReasonEnum(c.QueryParam("reason"))
+
+	if limit <= 0 {
+		limit = defaultStockHistoryPageSize
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if reason != "" && !reason.IsValid() {
+		return nil, false
+	}
+	variantId := c.QueryParam("variantId")
+	if variantId != "" {
+		if _, err := uuid.ParseHex(variantId); err != nil {
+			return nil, false
+		}
+	}
+
+	from, err := parseDateFilter(c.QueryParam("from"), false)
+	if err != nil {
+		return nil, false
+	}
+	to, err := parseDateFilter(c.QueryParam("to"), true)
+	if err != nil {
+		return nil, false
+	}
+
+	return &util.StockHistoryPagination{
+		VariantId: variantId,
+		Reason:    reason,
+		From:      from,
+		To:        to,
+		Limit:     limit,
+		Offset:    offset,
+	}, true
+}
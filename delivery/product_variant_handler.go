@@ -0,0 +1,76 @@
+package delivery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/repository"
+	"shopifyx/util"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	VariantNotFound    = "variant not found"
+	VariantRequired    = "product has variants, choose one with variantId"
+	ProductHasVariants = "product stock is managed by its variants, update a variant instead"
+)
+
+// checkVariantSKUs reports SKUs repeated within one product, which the
+// database would otherwise reject as a conflict.
+func checkVariantSKUs(variants []domain.ProductVariant) validation.Errors {
+	var errs validation.Errors
+	seen := make(map[string]bool, len(variants))
+	for i, variant := range variants {
+		if seen[variant.SKU] {
+			field := fmt.Sprintf("variants[%d].sku", i)
+			errs = append(errs, validation.FieldError{Field: field, Rule: "unique", Message: field + " is used by another variant"})
+		}
+		seen[variant.SKU] = true
+	}
+	return errs
+}
+
+func (h *ProductHandler) UpdateVariantStockHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	productId := c.Param("productId")
+	if err := h.checkProductOwner(productId, userId); err != nil {
+		return err
+	}
+
+	var stockUpdate domain.StockUpdate
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&stockUpdate); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&stockUpdate); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if errs := checkStockUpdate(&stockUpdate); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	stock, err := h.store.UpdateVariantStock(productId, c.Param("variantId"), userId, &stockUpdate)
+	if err != nil {
+		if err == repository.ErrVariantNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeVariantNotFound, VariantNotFound)
+		}
+		if err == repository.ErrStockBelowZero {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInsufficientStock, StockBelowZero)
+		}
+		if err == repository.ErrStockBelowReserved {
+			return apperror.New(http.StatusConflict, apperror.CodeStockReserved, StockBelowReserved)
+		}
+		return apperror.Internal(FailedToUpdateStock, err)
+	}
+
+	return util.StockResponseHandler(c, http.StatusOK, StockUpdatedSuccessfully, stock)
+}
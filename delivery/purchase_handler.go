@@ -0,0 +1,29 @@
+package delivery
+
+import (
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/util"
+
+	"github.com/labstack/echo/v4"
+)
+
+const FailedToFetchPurchases = "failed to fetch purchases"
+
+func (h *PaymentHandler) GetPurchasesHandler(c echo.Context) error {
+	buyerId := auth.GetUserIdFromToken(c)
+
+	paymentPagination, err := paymentPaginationFromQuery(c)
+	if err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidPaymentFilter)
+	}
+
+	purchases, total, err := h.store.GetPurchases(buyerId, paymentPagination)
+	if err != nil {
+		return apperror.Internal(FailedToFetchPurchases, err)
+	}
+
+	return util.PaymentPaginationResponseHandler(c, http.StatusOK, purchases, paymentPagination.Limit, paymentPagination.Offset, total)
+}
@@ -0,0 +1,101 @@
+package delivery
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/util"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	InvalidPaymentFilter  = "invalid status or date range filter"
+	FailedToFetchPayments = "failed to fetch payments"
+)
+
+var errInvalidStatus = errors.New("invalid payment status")
+
+const (
+	dateFilterLayout       = "2006-01-02"
+	defaultPaymentPageSize = 10
+)
+
+func (h *PaymentHandler) GetSellerPaymentsHandler(c echo.Context) error {
+	sellerId := auth.GetUserIdFromToken(c)
+
+	paymentPagination, err := paymentPaginationFromQuery(c)
+	if err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFilter, InvalidPaymentFilter)
+	}
+
+	payments, total, err := h.store.GetSellerPayments(sellerId, paymentPagination)
+	if err != nil {
+		return apperror.Internal(FailedToFetchPayments, err)
+	}
+
+	return util.PaymentPaginationResponseHandler(c, http.StatusOK, payments, paymentPagination.Limit, paymentPagination.Offset, total)
+}
+
+func (h *PaymentHandler) AcceptPaymentHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("paymentId"), domain.ConfirmedBySeller)
+}
+
+func (h *PaymentHandler) RejectPaymentHandler(c echo.Context) error {
+	return h.updateOrderStatus(c, c.Param("paymentId"), domain.Rejected)
+}
+
+func paymentPaginationFromQuery(c echo.Context) (*util.PaymentPagination, error) {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	offset, _ := strconv.Atoi(c.QueryParam("offset"))
+	status := domain.PaymentStatusEnum(c.QueryParam("status"))
+
+	if limit == 0 {
+		limit = defaultPaymentPageSize
+	}
+	if status != "" && !status.IsValid() {
+		return nil, errInvalidStatus
+	}
+
+	from, err := parseDateFilter(c.QueryParam("from"), false)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseDateFilter(c.QueryParam("to"), true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &util.PaymentPagination{
+		ProductId: c.QueryParam("productId"),
+		Status:    status,
+		From:      from,
+		To:        to,
+		Limit:     limit,
+		Offset:    offset,
+	}, nil
+}
+
+// parseDateFilter accepts either RFC3339 timestamps or plain dates. A plain
+// date used as the upper bound includes the whole day.
+func parseDateFilter(value string, upperBound bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateFilterLayout, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if upperBound {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
@@ -1,16 +1,24 @@
 package delivery
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"io"
 	"net/http"
-	"os"
+	"path"
 	"path/filepath"
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/imaging"
+	"shopifyx/repository"
+	"shopifyx/storage"
 	"shopifyx/util"
+	"shopifyx/validation"
+	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/labstack/echo/v4"
 	uuid "github.com/nu7hatch/gouuid"
 )
@@ -20,57 +28,270 @@ const (
 	FileFormatNotSupported             = "file format is not supported, only *.jpg or *.jpeg allowed"
 	FileSizeExceedsMaximumAllowedSize  = "file size exceeds maximum allowed size of 2MB"
 	FileSizeLessThanMinimumAllowedSize = "file size is less than minimum allowed size of 10KB"
+	FileContentNotJPEG                 = "file content is not a valid jpeg image"
+	ImageDimensionsTooLarge            = "image dimensions are too large"
 
-	FailedToUploadImage = "failed to upload image"
+	UploadNotFound         = "uploaded file not found"
+	UploadNotOwned         = "upload key does not belong to you"
+	UploadContentMismatch  = "uploaded file must be a jpeg between 10KB and 2MB"
+	UploadRequestMismatch  = "content type and length must match the presigned upload"
+	InvalidUploadSignature = "upload signature is invalid or expired"
+
+	FailedToUploadImage  = "failed to upload image"
+	FailedToPresignImage = "failed to create upload url"
+	FailedToConfirmImage = "failed to confirm upload"
+
+	ImageUploadURLCreated      = "upload url created"
+	ImageUploadedSuccessfully  = "image uploaded successfully"
+	ImageConfirmedSuccessfully = "image confirmed successfully"
+
+	minImageSize = 10 << 10
+	maxImageSize = 2 << 20
 )
 
-func UploadImageHandler(c echo.Context) error {
-	var awsAccesKeyId = os.Getenv("S3_ID")
-	var awsSecretAccessKey = os.Getenv("S3_SECRET_KEY")
-	var awsBucketName = os.Getenv("S3_BUCKET_NAME")
-	var awsRegion = "ap-southeast-1"
+type ImageHandler struct {
+	store         storage.ImageStore
+	assets        repository.AssetStore
+	presignExpiry time.Duration
+}
+
+func NewImageHandler(store storage.ImageStore, assets repository.AssetStore, presignExpiry time.Duration) *ImageHandler {
+	return &ImageHandler{store: store, assets: assets, presignExpiry: presignExpiry}
+}
 
+func (h *ImageHandler) UploadImageHandler(c echo.Context) error {
 	file, err := c.FormFile("file")
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, FileRequired)
+		return apperror.New(http.StatusBadRequest, apperror.CodeFileRequired, FileRequired)
 	}
 
 	ext := filepath.Ext(file.Filename)
 	if ext != ".jpg" && ext != ".jpeg" {
-		return util.ErrorHandler(c, http.StatusBadRequest, FileFormatNotSupported)
+		return apperror.New(http.StatusBadRequest, apperror.CodeUnsupportedFileFormat, FileFormatNotSupported)
+	}
+
+	if file.Size > maxImageSize {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFileSize, FileSizeExceedsMaximumAllowedSize)
+	}
+	if file.Size < minImageSize {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFileSize, FileSizeLessThanMinimumAllowedSize)
+	}
+
+	fileContent, err := file.Open()
+	if err != nil {
+		return apperror.Internal(FailedToUploadImage, err)
+	}
+	defer fileContent.Close()
+
+	data, err := io.ReadAll(fileContent)
+	if err != nil {
+		return apperror.Internal(FailedToUploadImage, err)
 	}
 
-	if file.Size > 2<<20 {
-		return util.ErrorHandler(c, http.StatusBadRequest, FileSizeExceedsMaximumAllowedSize)
+	// the extension says nothing about the content, decode it to be sure
+	images, err := imaging.ProcessJPEG(data)
+	if err != nil {
+		return processingError(err, FailedToUploadImage)
 	}
-	if file.Size < 10<<10 {
-		return util.ErrorHandler(c, http.StatusBadRequest, FileSizeLessThanMinimumAllowedSize)
+
+	renditions, _, err := h.saveRenditions(c.Request().Context(), auth.GetUserIdFromToken(c), images)
+	if err != nil {
+		return apperror.Internal(FailedToUploadImage, err)
 	}
 
+	return util.UploadImageResponseHandler(c, http.StatusOK, renditions)
+}
+
+// saveRenditions stores processed images under a new key of the user and
+// registers them as assets, returning the renditions and the original asset.
+func (h *ImageHandler) saveRenditions(ctx context.Context, userId string, images []imaging.Rendition) (map[string]domain.ImageRendition, domain.Asset, error) {
 	uuidValue, _ := uuid.NewV4()
-	filename := uuidValue.String() + filepath.Ext(file.Filename)
+	renditions := make(map[string]domain.ImageRendition, len(images))
+	var original domain.Asset
+	for _, image := range images {
+		key := uploadKeyPrefix(userId) + uuidValue.String() + ".jpg"
+		if image.Name != "original" {
+			key = uploadKeyPrefix(userId) + uuidValue.String() + "_" + image.Name + ".jpg"
+		}
 
-	fileContent, _ := file.Open()
-	defer fileContent.Close()
+		location, err := h.store.Save(ctx, key, bytes.NewReader(image.Data), "image/jpeg")
+		if err != nil {
+			return nil, domain.Asset{}, err
+		}
+		renditions[image.Name] = domain.ImageRendition{URL: location, Width: image.Width, Height: image.Height}
+
+		// the original comes first, renditions are registered under it
+		asset := domain.Asset{
+			UserId:      userId,
+			ParentId:    original.Id,
+			Rendition:   image.Name,
+			Key:         key,
+			URL:         location,
+			Size:        int64(len(image.Data)),
+			ContentType: "image/jpeg",
+		}
+		if err := h.assets.CreateAsset(&asset); err != nil {
+			return nil, domain.Asset{}, err
+		}
+		if image.Name == "original" {
+			original = asset
+		}
+	}
+	return renditions, original, nil
+}
 
-	cfg, _ := config.LoadDefaultConfig(context.TODO(),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(awsAccesKeyId, awsSecretAccessKey, "")),
-		config.WithRegion(awsRegion),
-	)
+// PresignUploadHandler lets the client upload one image straight to storage.
+// The upload lands under a quarantine prefix embedding the caller's id, so
+// ConfirmUploadHandler can check ownership without remembering issued URLs.
+func (h *ImageHandler) PresignUploadHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
 
-	client := s3.NewFromConfig(cfg)
+	var request domain.PresignUploadRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
 
-	uploader := manager.NewUploader(client)
+	if errs := validation.Struct(&request); errs != nil {
+		return apperror.Validation(errs)
+	}
 
-	uploadResult, err := uploader.Upload(context.TODO(), &s3.PutObjectInput{
-		Bucket: &awsBucketName,
-		Key:    &filename,
-		Body:   fileContent,
+	uuidValue, _ := uuid.NewV4()
+	key := incomingKeyPrefix(userId) + uuidValue.String() + ".jpg"
+	expiresAt := time.Now().Add(h.presignExpiry)
+
+	upload, err := h.store.PresignPut(c.Request().Context(), key, request.ContentType, request.Size, h.presignExpiry)
+	if err != nil {
+		return apperror.Internal(FailedToPresignImage, err)
+	}
+
+	return util.AssetResponseHandler(c, http.StatusCreated, ImageUploadURLCreated, domain.PresignUploadResponse{
+		Key:       key,
+		UploadURL: upload.URL,
+		Method:    upload.Method,
+		Headers:   upload.Headers,
+		ExpiresAt: expiresAt,
 	})
+}
 
+// ConfirmUploadHandler checks that a presigned upload really landed in
+// storage and runs it through the same pipeline as UploadImageHandler. Only
+// the processed images are registered, the raw upload is deleted.
+func (h *ImageHandler) ConfirmUploadHandler(c echo.Context) error {
+	userId := auth.GetUserIdFromToken(c)
+
+	var request domain.ConfirmUploadRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+
+	if errs := validation.Struct(&request); errs != nil {
+		return apperror.Validation(errs)
+	}
+
+	if !ownsUploadKey(userId, request.Key) {
+		return apperror.New(http.StatusForbidden, apperror.CodeForbidden, UploadNotOwned)
+	}
+
+	ctx := c.Request().Context()
+	info, err := h.store.Stat(ctx, request.Key)
+	if err != nil {
+		if err == storage.ErrObjectNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeUploadNotFound, UploadNotFound)
+		}
+		return apperror.Internal(FailedToConfirmImage, err)
+	}
+
+	if info.ContentType != "image/jpeg" || info.Size < minImageSize || info.Size > maxImageSize {
+		return apperror.New(http.StatusBadRequest, apperror.CodeUnsupportedFileFormat, UploadContentMismatch)
+	}
+
+	object, err := h.store.Open(ctx, request.Key)
+	if err != nil {
+		if err == storage.ErrObjectNotFound {
+			return apperror.New(http.StatusNotFound, apperror.CodeUploadNotFound, UploadNotFound)
+		}
+		return apperror.Internal(FailedToConfirmImage, err)
+	}
+	data, err := io.ReadAll(io.LimitReader(object, maxImageSize+1))
+	object.Close()
+	if err != nil {
+		return apperror.Internal(FailedToConfirmImage, err)
+	}
+	if len(data) > maxImageSize {
+		return apperror.New(http.StatusBadRequest, apperror.CodeUnsupportedFileFormat, UploadContentMismatch)
+	}
+
+	images, err := imaging.ProcessJPEG(data)
+	if err != nil {
+		return processingError(err, FailedToConfirmImage)
+	}
+
+	_, original, err := h.saveRenditions(ctx, userId, images)
+	if err != nil {
+		return apperror.Internal(FailedToConfirmImage, err)
+	}
+
+	// the raw upload still carries its metadata, keep only the processed copy
+	if err := h.store.Delete(ctx, request.Key); err != nil {
+		c.Logger().Error(err)
+	}
+
+	return util.AssetResponseHandler(c, http.StatusCreated, ImageConfirmedSuccessfully, original)
+}
+
+// DirectUploadHandler receives presigned PUTs when images are stored on the
+// local disk, standing in for S3 in development and CI.
+func (h *ImageHandler) DirectUploadHandler(c echo.Context) error {
+	localStore, ok := h.store.(*storage.LocalImageStore)
+	if !ok {
+		return apperror.New(http.StatusNotFound, apperror.CodeNotFound, http.StatusText(http.StatusNotFound))
+	}
+
+	key := c.Param("*")
+	contentType, size, err := localStore.VerifyPresigned(key, c.QueryParams())
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToUploadImage)
+		return apperror.New(http.StatusForbidden, apperror.CodeInvalidUploadSignature, InvalidUploadSignature)
 	}
 
-	return util.UploadImageResponseHandler(c, http.StatusOK, uploadResult.Location)
+	if c.Request().Header.Get(echo.HeaderContentType) != contentType || c.Request().ContentLength != size {
+		return apperror.New(http.StatusBadRequest, apperror.CodeBadRequest, UploadRequestMismatch)
+	}
+
+	if _, err := localStore.Save(c.Request().Context(), key, io.LimitReader(c.Request().Body, size), contentType); err != nil {
+		return apperror.Internal(FailedToUploadImage, err)
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, ImageUploadedSuccessfully)
+}
+
+func uploadKeyPrefix(userId string) string {
+	return storage.UploadsPrefix + userId + "/"
+}
+
+// incomingKeyPrefix is where presigned uploads wait, unprocessed, until they
+// are confirmed. Nothing under it is ever registered as an asset.
+func incomingKeyPrefix(userId string) string {
+	return storage.IncomingPrefix + userId + "/"
+}
+
+// ownsUploadKey reports whether key is a clean key under the caller's
+// incoming prefix. A key like "incoming/<me>/../<victim>/x.jpg" has the right
+// prefix but resolves into another user's folder, so only cleaned keys are
+// accepted.
+func ownsUploadKey(userId, key string) bool {
+	if path.Clean(key) != key || strings.Contains(key, "..") {
+		return false
+	}
+	return strings.HasPrefix(key, incomingKeyPrefix(userId))
+}
+
+// processingError maps an imaging.ProcessJPEG failure to the API error.
+func processingError(err error, message string) error {
+	switch err {
+	case imaging.ErrNotJPEG:
+		return apperror.New(http.StatusBadRequest, apperror.CodeUnsupportedFileFormat, FileContentNotJPEG)
+	case imaging.ErrImageTooLarge:
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidFileSize, ImageDimensionsTooLarge)
+	}
+	return apperror.Internal(message, err)
 }
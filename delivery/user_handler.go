@@ -4,78 +4,178 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"shopifyx/apperror"
 	"shopifyx/auth"
 	"shopifyx/domain"
 	"shopifyx/repository"
 	"shopifyx/util"
+	"shopifyx/validation"
 
 	"github.com/labstack/echo/v4"
 )
 
 const (
-	InvalidUsernameOrPasswordLength = "username or password must be 5 to 15 characters long"
-	UsernameAreleadyExists          = "username already exists"
-	FailedToGenerateToken           = "failed to generate token"
+	UsernameAreleadyExists = "username already exists"
+	FailedToGenerateToken  = "failed to generate token"
+	InvalidRefreshToken    = "refresh token is invalid or expired"
+	FailedToLogout         = "failed to logout"
+	FailedToLogin          = "failed to login"
+	FailedToRegister       = "failed to register user"
 
 	UserRegisteredSuccessfully = "User registered successfully"
 	UserLoggedSuccessfully     = "User logged successfully"
+	TokenRefreshedSuccessfully = "Token refreshed successfully"
+	UserLoggedOutSuccessfully  = "User logged out successfully"
 	UserNotFound               = "user not found"
 	UserPasswordFalse          = "wrong password"
+	UserIsBanned               = "user is banned"
 )
 
-func RegisterUserHandler(c echo.Context) error {
+type UserHandler struct {
+	store  repository.UserStore
+	tokens repository.TokenStore
+}
+
+func NewUserHandler(store repository.UserStore, tokens repository.TokenStore) *UserHandler {
+	return &UserHandler{store: store, tokens: tokens}
+}
+
+func (h *UserHandler) RegisterUserHandler(c echo.Context) error {
 	var user domain.User
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&user); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	if (len(user.Username) < 5 || len(user.Username) > 15) || (len(user.Password) < 5 || len(user.Password) > 15) {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidUsernameOrPasswordLength)
+	if errs := validation.Struct(&user); errs != nil {
+		return apperror.Validation(errs)
 	}
 
-	user, err := repository.RegisterUser(user.Username, user.Name, user.Password)
+	user, err := h.store.RegisterUser(user.Username, user.Name, user.Password)
 	if err != nil {
 		if repository.IsDuplicateKeyError(err) {
-			return util.ErrorHandler(c, http.StatusConflict, UsernameAreleadyExists)
+			return apperror.New(http.StatusConflict, apperror.CodeUsernameTaken, UsernameAreleadyExists)
+		}
+		if repository.IsConstrainViolations(err) {
+			return apperror.New(http.StatusBadRequest, apperror.CodeValidationFailed, RequredFieldsMissing)
 		}
+		return apperror.Internal(FailedToRegister, err)
 	}
 
-	token, err := auth.GenerateAccessToken(&user)
+	token, refreshToken, err := h.issueTokens(&user)
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToGenerateToken)
+		return apperror.Internal(FailedToGenerateToken, err)
 	}
 
-	return util.UserSuccesResponseHandler(c, http.StatusCreated, UserRegisteredSuccessfully, user.Username, user.Name, token)
+	return util.UserSuccesResponseHandler(c, http.StatusCreated, UserRegisteredSuccessfully, user.Username, user.Name, token, refreshToken)
 }
 
-func LoginUserHandler(c echo.Context) error {
+func (h *UserHandler) LoginUserHandler(c echo.Context) error {
 	var user domain.User
 
 	if err := json.NewDecoder(c.Request().Body).Decode(&user); err != nil {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidRequestBody)
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
 	}
 
-	if (len(user.Username) < 5 || len(user.Username) > 15) || (len(user.Password) < 5 || len(user.Password) > 15) {
-		return util.ErrorHandler(c, http.StatusBadRequest, InvalidUsernameOrPasswordLength)
+	if errs := validation.StructFields(&user, "username", "password"); errs != nil {
+		return apperror.Validation(errs)
 	}
 
-	user, err := repository.LoginUser(user.Username, user.Password)
+	user, err := h.store.LoginUser(user.Username, user.Password)
 
 	if err != nil {
 		if err == repository.ErrUsernameNotFound {
-			return util.ErrorHandler(c, http.StatusNotFound, UserNotFound)
+			return apperror.New(http.StatusNotFound, apperror.CodeUserNotFound, UserNotFound)
 		}
-		if err == repository.ErrUsernameNotFound {
-			return util.ErrorHandler(c, http.StatusBadRequest, UserPasswordFalse)
+		if err == repository.ErrPasswordWrong {
+			return apperror.New(http.StatusUnauthorized, apperror.CodeInvalidCredentials, UserPasswordFalse)
+		}
+		if err == repository.ErrUserBanned {
+			return apperror.New(http.StatusForbidden, apperror.CodeUserBanned, UserIsBanned)
+		}
+		return apperror.Internal(FailedToLogin, err)
+	}
+
+	token, refreshToken, err := h.issueTokens(&user)
+	if err != nil {
+		return apperror.Internal(FailedToGenerateToken, err)
+	}
+
+	return util.UserSuccesResponseHandler(c, http.StatusOK, UserLoggedSuccessfully, user.Username, user.Name, token, refreshToken)
+}
+
+func (h *UserHandler) RefreshTokenHandler(c echo.Context) error {
+	var request domain.RefreshTokenRequest
+
+	if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+		return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+	}
+	if request.RefreshToken == "" {
+		return apperror.New(http.StatusUnauthorized, apperror.CodeInvalidRefreshToken, InvalidRefreshToken)
+	}
+
+	refreshToken, refreshTokenHash, expiresAt, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return apperror.Internal(FailedToGenerateToken, err)
+	}
+
+	user, err := h.tokens.RotateRefreshToken(auth.HashToken(request.RefreshToken), refreshTokenHash, expiresAt)
+	if err != nil {
+		if err == repository.ErrRefreshTokenInvalid {
+			return apperror.New(http.StatusUnauthorized, apperror.CodeInvalidRefreshToken, InvalidRefreshToken)
+		}
+		if err == repository.ErrUserBanned {
+			return apperror.New(http.StatusForbidden, apperror.CodeUserBanned, UserIsBanned)
 		}
-		return util.ErrorHandler(c, http.StatusInternalServerError, err.Error())
+		return apperror.Internal(FailedToGenerateToken, err)
 	}
 
 	token, err := auth.GenerateAccessToken(&user)
 	if err != nil {
-		return util.ErrorHandler(c, http.StatusInternalServerError, FailedToGenerateToken)
+		return apperror.Internal(FailedToGenerateToken, err)
+	}
+
+	return util.UserSuccesResponseHandler(c, http.StatusOK, TokenRefreshedSuccessfully, user.Username, user.Name, token, refreshToken)
+}
+
+func (h *UserHandler) LogoutUserHandler(c echo.Context) error {
+	claims := auth.GetClaimsFromToken(c)
+
+	var request domain.RefreshTokenRequest
+	if c.Request().ContentLength != 0 {
+		if err := json.NewDecoder(c.Request().Body).Decode(&request); err != nil {
+			return apperror.New(http.StatusBadRequest, apperror.CodeInvalidBody, InvalidRequestBody)
+		}
+	}
+
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := h.tokens.RevokeAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+			return apperror.Internal(FailedToLogout, err)
+		}
 	}
 
-	return util.UserSuccesResponseHandler(c, http.StatusOK, UserLoggedSuccessfully, user.Username, user.Name, token)
+	if request.RefreshToken != "" {
+		if err := h.tokens.RevokeRefreshToken(claims.Id, auth.HashToken(request.RefreshToken)); err != nil {
+			return apperror.Internal(FailedToLogout, err)
+		}
+	}
+
+	return util.ResponseHandler(c, http.StatusOK, UserLoggedOutSuccessfully)
+}
+
+func (h *UserHandler) issueTokens(user *domain.User) (string, string, error) {
+	token, err := auth.GenerateAccessToken(user)
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshToken, refreshTokenHash, expiresAt, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := h.tokens.CreateRefreshToken(user.Id, refreshTokenHash, expiresAt); err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
 }
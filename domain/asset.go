@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"net/http"
+	"time"
+)
+
+type Asset struct {
+	Id          string    `json:"id"`
+	UserId      string    `json:"userId"`
+	ParentId    string    `json:"parentId,omitempty"`
+	Rendition   string    `json:"rendition"`
+	Key         string    `json:"key"`
+	URL         string    `json:"url"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type PresignUploadRequest struct {
+	ContentType string `json:"contentType" validate:"required,oneof=image/jpeg"`
+	Size        int64  `json:"size" validate:"min=10240,max=2097152"`
+}
+
+type PresignUploadResponse struct {
+	Key       string      `json:"key"`
+	UploadURL string      `json:"uploadUrl"`
+	Method    string      `json:"method"`
+	Headers   http.Header `json:"headers"`
+	ExpiresAt time.Time   `json:"expiresAt"`
+}
+
+type ConfirmUploadRequest struct {
+	Key string `json:"key" validate:"required"`
+}
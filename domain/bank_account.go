@@ -2,9 +2,9 @@ package domain
 
 type BankAccount struct {
 	Id                string `json:"id"`
-	BankName          string `json:"bankName"`
-	BankAccountName   string `json:"bankAccountName"`
-	BankAccountNumber string `json:"bankAccountNumber"`
+	BankName          string `json:"bankName" validate:"required,min=5,max=15"`
+	BankAccountName   string `json:"bankAccountName" validate:"required,min=5,max=15"`
+	BankAccountNumber string `json:"bankAccountNumber" validate:"required,min=5,max=15"`
 	UserId            string `json:"userId"`
 }
 
@@ -0,0 +1,44 @@
+package domain
+
+type CartItem struct {
+	ProductId string `json:"productId" validate:"required,uuid"`
+	Quantity  int    `json:"quantity" validate:"min=1"`
+}
+
+type CartItemResponse struct {
+	ProductId      string `json:"productId"`
+	Name           string `json:"name"`
+	Price          int    `json:"price"`
+	ImageURL       string `json:"imageUrl"`
+	Stock          int    `json:"stock"`
+	IsPurchaseable bool   `json:"isPurchaseable"`
+	SellerId       string `json:"sellerId"`
+	SellerName     string `json:"sellerName"`
+	Quantity       int    `json:"quantity"`
+}
+
+type SellerPayment struct {
+	SellerId             string `json:"sellerId" validate:"required,uuid"`
+	BankAccountId        string `json:"bankAccountId" validate:"required,uuid"`
+	PaymentProofImageURL string `json:"paymentProofImageUrl" validate:"url"`
+}
+
+type Checkout struct {
+	Payments []SellerPayment `json:"payments" validate:"required,min=1"`
+}
+
+type CheckoutOrderResponse struct {
+	Id            string            `json:"id"`
+	SellerId      string            `json:"sellerId"`
+	BankAccountId string            `json:"bankAccountId"`
+	TotalPrice    int               `json:"totalPrice"`
+	Payments      []PaymentResponse `json:"payments"`
+}
+
+func CartTotalPrice(items []CartItemResponse) int {
+	total := 0
+	for _, item := range items {
+		total += item.Price * item.Quantity
+	}
+	return total
+}
@@ -0,0 +1,17 @@
+package domain
+
+import "time"
+
+type IdempotencyRecord struct {
+	UserId string
+	Key    string
+	Method string
+	Path   string
+	// RequestHash is the sha256 of the request body the key was first used with
+	RequestHash  string
+	StatusCode   int
+	ContentType  string
+	ResponseBody []byte
+	Completed    bool
+	CreatedAt    time.Time
+}
@@ -0,0 +1,7 @@
+package domain
+
+type ImageRendition struct {
+	URL    string `json:"url"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
@@ -1,8 +1,108 @@
 package domain
 
+import "time"
+
+type PaymentStatusEnum string
+
+const (
+	Pending           PaymentStatusEnum = "pending"
+	ProofSubmitted    PaymentStatusEnum = "proof_submitted"
+	ConfirmedBySeller PaymentStatusEnum = "confirmed_by_seller"
+	Shipped           PaymentStatusEnum = "shipped"
+	Completed         PaymentStatusEnum = "completed"
+	Cancelled         PaymentStatusEnum = "cancelled"
+	Rejected          PaymentStatusEnum = "rejected"
+)
+
+type PaymentActorEnum string
+
+const (
+	Buyer  PaymentActorEnum = "buyer"
+	Seller PaymentActorEnum = "seller"
+)
+
+// paymentTransitions lists, for every status, the statuses it may move to
+// and which side of the order is allowed to make that move.
+var paymentTransitions = map[PaymentStatusEnum]map[PaymentStatusEnum]PaymentActorEnum{
+	Pending: {
+		ProofSubmitted: Buyer,
+		Cancelled:      Buyer,
+	},
+	ProofSubmitted: {
+		ConfirmedBySeller: Seller,
+		Rejected:          Seller,
+		Cancelled:         Buyer,
+	},
+	ConfirmedBySeller: {
+		Shipped: Seller,
+	},
+	Shipped: {
+		Completed: Buyer,
+	},
+}
+
+// PaymentTransitionActor reports who may move a payment from one status to
+// another, and false when the transition is not allowed at all.
+func PaymentTransitionActor(from, to PaymentStatusEnum) (PaymentActorEnum, bool) {
+	actor, ok := paymentTransitions[from][to]
+	return actor, ok
+}
+
+func (s PaymentStatusEnum) IsValid() bool {
+	switch s {
+	case Pending, ProofSubmitted, ConfirmedBySeller, Shipped, Completed, Cancelled, Rejected:
+		return true
+	}
+	return false
+}
+
+// ReleasesStock reports whether reaching the status gives the purchased
+// quantity back to the product.
+func (s PaymentStatusEnum) ReleasesStock() bool {
+	return s == Cancelled || s == Rejected
+}
+
 type Payment struct {
-	Id                   string `json:"id"`
-	BankAccountId        string `json:"bankAccountId"`
-	PaymentProofImageURL string `json:"paymentProofImageUrl"`
-	Quantity             int    `json:"quantity"`
+	Id                   string            `json:"id"`
+	BankAccountId        string            `json:"bankAccountId" validate:"required,uuid"`
+	VariantId            string            `json:"variantId,omitempty" validate:"uuid"`
+	ReservationId        string            `json:"reservationId,omitempty" validate:"uuid"`
+	PaymentProofImageURL string            `json:"paymentProofImageUrl" validate:"url"`
+	Quantity             int               `json:"quantity" validate:"min=1"`
+	Status               PaymentStatusEnum `json:"status"`
+}
+
+type PaymentResponse struct {
+	Id                   string            `json:"id"`
+	ProductId            string            `json:"productId"`
+	BuyerId              string            `json:"buyerId"`
+	SellerId             string            `json:"sellerId"`
+	BankAccountId        string            `json:"bankAccountId"`
+	VariantId            string            `json:"variantId,omitempty"`
+	PaymentProofImageURL string            `json:"paymentProofImageUrl"`
+	Quantity             int               `json:"quantity"`
+	Status               PaymentStatusEnum `json:"status"`
+	CreatedAt            time.Time         `json:"createdAt"`
+	UpdatedAt            time.Time         `json:"updatedAt"`
+}
+
+type PaymentProofUpdate struct {
+	PaymentProofImageURL string `json:"paymentProofImageUrl" validate:"required,url"`
+}
+
+type SellerPaymentResponse struct {
+	PaymentResponse
+	ProductName      string       `json:"productName"`
+	ProductImageURL  string       `json:"productImageUrl"`
+	ProductSoldTotal int          `json:"productSoldTotal"`
+	BuyerName        string       `json:"buyerName"`
+	BankAccount      BankAccounts `json:"bankAccount"`
+}
+
+type PurchaseResponse struct {
+	PaymentResponse
+	ProductName     string       `json:"productName"`
+	ProductImageURL string       `json:"productImageUrl"`
+	SellerName      string       `json:"sellerName"`
+	BankAccount     BankAccounts `json:"bankAccount"`
 }
@@ -1,5 +1,7 @@
 package domain
 
+import "time"
+
 type ConditionEnum string
 
 const (
@@ -8,28 +10,75 @@ const (
 )
 
 type Product struct {
-	Name           string        `json:"name"`
-	Price          int           `json:"price"`
-	ImageURL       string        `json:"imageUrl"`
-	Stock          int           `json:"stock"`
-	Condition      ConditionEnum `json:"condition"`
-	Tags           []string      `json:"tags"`
+	Name           string        `json:"name" validate:"required,min=5,max=60"`
+	Price          int           `json:"price" validate:"min=0"`
+	ImageURL       string        `json:"imageUrl" validate:"required,url"`
+	Stock          int           `json:"stock" validate:"min=0"`
+	Condition      ConditionEnum `json:"condition" validate:"required,oneof=new second"`
+	Tags           []string      `json:"tags" validate:"required"`
 	IsPurchaseable bool          `json:"isPurchaseable"`
 	PurchaseCount  int           `json:"purchaseCount"`
+	// Variants replace the product stock with their own, which sums up to it
+	Variants []ProductVariant `json:"variants" validate:"max=100"`
+	// Images are added to the gallery after imageUrl, the primary image
+	Images []string `json:"images" validate:"max=9"`
+}
+
+// MaxProductImages caps a gallery, the primary image included.
+const MaxProductImages = 10
+
+type ProductImage struct {
+	Id       string `json:"id"`
+	URL      string `json:"url"`
+	Position int    `json:"position"`
+	Primary  bool   `json:"primary"`
+}
+
+type ProductImageCreate struct {
+	ImageURL string `json:"imageUrl" validate:"required,url"`
+}
+
+type ProductImageOrder struct {
+	ImageIds []string `json:"imageIds" validate:"required,min=1"`
+}
+
+// ProductVariant is one purchasable option of a product, e.g. size M in red.
+type ProductVariant struct {
+	SKU     string            `json:"sku" validate:"required,min=1,max=64"`
+	Options map[string]string `json:"options" validate:"required,min=1"`
+	// Price overrides the product price when set
+	Price *int `json:"price" validate:"min=0"`
+	Stock int  `json:"stock" validate:"min=0"`
+}
+
+type ProductVariantResponse struct {
+	Id      string            `json:"id"`
+	SKU     string            `json:"sku"`
+	Options map[string]string `json:"options"`
+	Price   int               `json:"price"`
+	Stock   int               `json:"stock"`
 }
 
 type ProductResponse struct {
-	Id             string        `json:"id"`
-	Name           string        `json:"name"`
-	Price          int           `json:"price"`
-	ImageURL       string        `json:"imageUrl"`
-	Stock          int           `json:"stock"`
-	Condition      ConditionEnum `json:"condition"`
-	Tags           []string      `json:"tags"`
-	IsPurchaseable bool          `json:"isPurchaseable"`
-	PurchaseCount  int           `json:"purchaseCount"`
+	Id             string                   `json:"id"`
+	Name           string                   `json:"name"`
+	Price          int                      `json:"price"`
+	ImageURL       string                   `json:"imageUrl"`
+	Stock          int                      `json:"stock"`
+	Condition      ConditionEnum            `json:"condition"`
+	Tags           []string                 `json:"tags"`
+	IsPurchaseable bool                     `json:"isPurchaseable"`
+	PurchaseCount  int                      `json:"purchaseCount"`
+	Variants       []ProductVariantResponse `json:"variants,omitempty"`
+	Images         []ProductImage           `json:"images"`
+	// DeletedAt is set on archived products, which are only shown on request
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// Highlight is the HTML escaped name and tags, with the search terms
+	// found in them wrapped in <mark>
+	Highlight string `json:"highlight,omitempty"`
 }
 
-type StockUpdate struct {
-	Stock int `json:"stock"`
+type TagCount struct {
+	Tag   string `json:"tag"`
+	Count int    `json:"count"`
 }
@@ -0,0 +1,74 @@
+package domain
+
+import "time"
+
+type StockReasonEnum string
+
+const (
+	Restock    StockReasonEnum = "restock"
+	Sale       StockReasonEnum = "sale"
+	Cancel     StockReasonEnum = "cancel"
+	Adjustment StockReasonEnum = "adjustment"
+)
+
+func (r StockReasonEnum) IsValid() bool {
+	switch r {
+	case Restock, Sale, Cancel, Adjustment:
+		return true
+	}
+	return false
+}
+
+// StockUpdate sets the stock to Stock, or moves it by Delta when that is sent
+// instead. Sellers can only record restocks and adjustments, sales and
+// cancellations come from payments.
+type StockUpdate struct {
+	Stock  *int            `json:"stock" validate:"min=0"`
+	Delta  *int            `json:"delta"`
+	Reason StockReasonEnum `json:"reason" validate:"oneof=restock adjustment"`
+}
+
+// Movement is the delta and reason to record for the update against the
+// current stock. Without a reason a positive delta is a restock and anything
+// else an adjustment.
+func (u *StockUpdate) Movement(current int) (int, StockReasonEnum) {
+	delta := 0
+	if u.Delta != nil {
+		delta = *u.Delta
+	} else if u.Stock != nil {
+		delta = *u.Stock - current
+	}
+
+	reason := u.Reason
+	if reason == "" {
+		reason = Adjustment
+		if u.Delta != nil && delta > 0 {
+			reason = Restock
+		}
+	}
+	return delta, reason
+}
+
+// StockReservation holds quantity of a product, or of one of its variants,
+// for a buyer until ExpiresAt so it cannot sell out while they pay.
+type StockReservation struct {
+	Id        string    `json:"id"`
+	ProductId string    `json:"productId"`
+	VariantId string    `json:"variantId,omitempty" validate:"uuid"`
+	Quantity  int       `json:"quantity" validate:"min=1"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// StockMovement is one entry of the stock ledger of a product.
+type StockMovement struct {
+	Id        string          `json:"id"`
+	ProductId string          `json:"productId"`
+	VariantId string          `json:"variantId,omitempty"`
+	Delta     int             `json:"delta"`
+	Reason    StockReasonEnum `json:"reason"`
+	ActorId   string          `json:"actorId"`
+	PaymentId string          `json:"paymentId,omitempty"`
+	// StockAfter is the stock of the whole product once the movement applied
+	StockAfter int       `json:"stockAfter"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
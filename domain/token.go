@@ -0,0 +1,5 @@
+package domain
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
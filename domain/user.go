@@ -2,11 +2,48 @@ package domain
 
 import _ "database/sql"
 
+type RoleEnum string
+
+const (
+	RoleBuyer  RoleEnum = "buyer"
+	RoleSeller RoleEnum = "seller"
+	RoleAdmin  RoleEnum = "admin"
+)
+
+// DefaultRoles are given to every registered user, matching the old behaviour
+// where any user could both buy and sell.
+var DefaultRoles = []RoleEnum{RoleBuyer, RoleSeller}
+
+func (r RoleEnum) IsValid() bool {
+	return r == RoleBuyer || r == RoleSeller || r == RoleAdmin
+}
+
+func RolesFromStrings(values []string) []RoleEnum {
+	roles := make([]RoleEnum, 0, len(values))
+	for _, value := range values {
+		roles = append(roles, RoleEnum(value))
+	}
+	return roles
+}
+
+func RolesToStrings(roles []RoleEnum) []string {
+	values := make([]string, 0, len(roles))
+	for _, role := range roles {
+		values = append(values, string(role))
+	}
+	return values
+}
+
 type User struct {
-	Id       string `json:"id"`
-	Username string `json:"username"`
-	Name     string `json:"name"`
-	Password string `json:"password"`
+	Id       string     `json:"id"`
+	Username string     `json:"username" validate:"required,min=5,max=15"`
+	Name     string     `json:"name" validate:"required,min=5,max=50"`
+	Password string     `json:"password" validate:"required,min=5,max=15"`
+	Roles    []RoleEnum `json:"roles"`
+}
+
+type UserRolesUpdate struct {
+	Roles []RoleEnum `json:"roles" validate:"required,min=1"`
 }
 
 type SellerResponse struct {
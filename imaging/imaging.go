@@ -0,0 +1,149 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"net/http"
+)
+
+const (
+	// MaxPixels guards against decompression bombs: a tiny file declaring a
+	// huge canvas would otherwise allocate gigabytes while decoding.
+	MaxPixels = 40_000_000
+
+	jpegQuality = 85
+)
+
+var (
+	ErrNotJPEG       = errors.New("image is not a jpeg")
+	ErrImageTooLarge = errors.New("image dimensions are too large")
+)
+
+// Rendition is one encoded size of an uploaded image.
+type Rendition struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+type renditionSpec struct {
+	name    string
+	maxSide int
+}
+
+// renditionSpecs are produced for every upload, a maxSide of 0 keeps the
+// original dimensions.
+var renditionSpecs = []renditionSpec{
+	{name: "original", maxSide: 0},
+	{name: "medium", maxSide: 800},
+	{name: "thumbnail", maxSide: 200},
+}
+
+// ProcessJPEG checks that data really is a JPEG, decodes it and re-encodes
+// every rendition. Re-encoding from pixels drops all metadata, EXIF and GPS
+// included, so the EXIF orientation is applied to the pixels first.
+func ProcessJPEG(data []byte) ([]Rendition, error) {
+	if http.DetectContentType(data) != "image/jpeg" {
+		return nil, ErrNotJPEG
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != "jpeg" {
+		return nil, ErrNotJPEG
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	decoded, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrNotJPEG
+	}
+	src := orient(toRGBA(decoded), exifOrientation(data))
+
+	renditions := make([]Rendition, 0, len(renditionSpecs))
+	for _, spec := range renditionSpecs {
+		img := src
+		if spec.maxSide > 0 {
+			img = fit(src, spec.maxSide)
+		}
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+
+		renditions = append(renditions, Rendition{
+			Name:   spec.name,
+			Width:  img.Bounds().Dx(),
+			Height: img.Bounds().Dy(),
+			Data:   buf.Bytes(),
+		})
+	}
+	return renditions, nil
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	bounds := img.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), img, bounds.Min, draw.Src)
+	return rgba
+}
+
+// fit scales src down so its longest side is at most maxSide, keeping the
+// aspect ratio. Images that already fit are returned unchanged.
+func fit(src *image.RGBA, maxSide int) *image.RGBA {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	if width <= maxSide && height <= maxSide {
+		return src
+	}
+
+	dstWidth, dstHeight := maxSide, maxSide
+	if width > height {
+		dstHeight = max(1, height*maxSide/width)
+	} else {
+		dstWidth = max(1, width*maxSide/height)
+	}
+	return downscale(src, dstWidth, dstHeight)
+}
+
+// downscale averages every source pixel covered by a destination pixel
+// (box filter), which is cheap and avoids the aliasing of nearest neighbour.
+func downscale(src *image.RGBA, dstWidth, dstHeight int) *image.RGBA {
+	srcWidth, srcHeight := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+
+	for y := 0; y < dstHeight; y++ {
+		y0 := y * srcHeight / dstHeight
+		y1 := max(y0+1, (y+1)*srcHeight/dstHeight)
+
+		for x := 0; x < dstWidth; x++ {
+			x0 := x * srcWidth / dstWidth
+			x1 := max(x0+1, (x+1)*srcWidth/dstWidth)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				offset := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += int(src.Pix[offset])
+					g += int(src.Pix[offset+1])
+					b += int(src.Pix[offset+2])
+					a += int(src.Pix[offset+3])
+					offset += 4
+					n++
+				}
+			}
+
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
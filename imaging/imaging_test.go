@@ -0,0 +1,90 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// exifJPEG encodes a landscape image, red on the left and blue on the right,
+// carrying an EXIF segment with the given orientation.
+func exifJPEG(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 32 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// big endian TIFF header followed by an IFD0 holding only Orientation
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	tiff = binary.BigEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.BigEndian.AppendUint16(tiff, 3)
+	tiff = binary.BigEndian.AppendUint32(tiff, 1)
+	tiff = binary.BigEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	data := encoded.Bytes()
+	return append(append(append([]byte{}, data[:2]...), segment...), data[2:]...)
+}
+
+func isRed(c color.Color) bool {
+	r, _, b, _ := c.RGBA()
+	return r > 0xc000 && b < 0x4000
+}
+
+func TestProcessJPEGAppliesOrientation(t *testing.T) {
+	tests := []struct {
+		name          string
+		orientation   uint16
+		width, height int
+		// a point that must still be red once upright
+		redX, redY int
+	}{
+		{"upright", 1, 64, 32, 8, 16},
+		{"rotated 180", 3, 64, 32, 56, 16},
+		{"needs a clockwise turn", 6, 32, 64, 16, 8},
+		{"needs a counter clockwise turn", 8, 32, 64, 16, 56},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renditions, err := ProcessJPEG(exifJPEG(t, tt.orientation))
+			if err != nil {
+				t.Fatal(err)
+			}
+			original := renditions[0]
+			if original.Width != tt.width || original.Height != tt.height {
+				t.Fatalf("got %dx%d, want %dx%d", original.Width, original.Height, tt.width, tt.height)
+			}
+
+			decoded, err := jpeg.Decode(bytes.NewReader(original.Data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isRed(decoded.At(tt.redX, tt.redY)) {
+				t.Fatalf("got %v at (%d, %d), want red", decoded.At(tt.redX, tt.redY), tt.redX, tt.redY)
+			}
+			if exifOrientation(original.Data) != 1 {
+				t.Fatal("rendition still carries an orientation")
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"shopifyx/repository"
+	"shopifyx/storage"
+)
+
+const assetGCBatchSize = 100
+
+// CollectOrphanedAssets deletes uploads that nothing references once they are
+// older than gracePeriod. Objects are removed from storage before their rows,
+// so a failed delete is simply retried on the next run.
+func CollectOrphanedAssets(ctx context.Context, images storage.ImageStore, assets repository.AssetStore, gracePeriod time.Duration) error {
+	orphans, err := assets.GetOrphanedAssets(time.Now().Add(-gracePeriod), assetGCBatchSize)
+	if err != nil {
+		return err
+	}
+
+	failed := make(map[string]bool)
+	for _, asset := range orphans {
+		if err := images.Delete(ctx, asset.Key); err != nil {
+			id := asset.Id
+			if asset.ParentId != "" {
+				id = asset.ParentId
+			}
+			failed[id] = true
+		}
+	}
+
+	for _, asset := range orphans {
+		if asset.ParentId != "" || failed[asset.Id] {
+			continue
+		}
+		if err := assets.DeleteAsset(asset.Id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
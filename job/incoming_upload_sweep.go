@@ -0,0 +1,30 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"shopifyx/storage"
+)
+
+// incomingUploadMargin keeps an upload a while after its presigned URL
+// expired, so a confirm sent right at the deadline still finds it.
+const incomingUploadMargin = time.Hour
+
+// SweepIncomingUploads deletes raw presigned uploads that were never
+// confirmed. They are not assets, so CollectOrphanedAssets never sees them.
+// A failed delete is retried on the next run.
+func SweepIncomingUploads(ctx context.Context, images storage.ImageStore, presignExpiry time.Duration) error {
+	keys, err := images.List(ctx, storage.IncomingPrefix, time.Now().Add(-presignExpiry-incomingUploadMargin))
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, key := range keys {
+		if err := images.Delete(ctx, key); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
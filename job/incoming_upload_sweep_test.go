@@ -0,0 +1,51 @@
+package job_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"shopifyx/job"
+	"shopifyx/storage"
+)
+
+func TestSweepIncomingUploads(t *testing.T) {
+	dir := t.TempDir()
+	images, err := storage.NewLocalImageStore(dir, "http://localhost:8000", "/images", "job-test-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	old := time.Now().Add(-3 * time.Hour)
+	for key, modified := range map[string]time.Time{
+		"incoming/user-1/abandoned.jpg": old,
+		"incoming/user-1/pending.jpg":   time.Now(),
+		"uploads/user-1/kept.jpg":       old,
+	} {
+		if _, err := images.Save(ctx, key, strings.NewReader("jpeg"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := job.SweepIncomingUploads(ctx, images, 15*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, kept := range map[string]bool{
+		"incoming/user-1/abandoned.jpg": false,
+		"incoming/user-1/pending.jpg":   true,
+		"uploads/user-1/kept.jpg":       true,
+	} {
+		_, err := images.Stat(ctx, key)
+		if exists := err == nil; exists != kept {
+			t.Errorf("%s: got exists %v, want %v (%v)", key, exists, kept, err)
+		}
+	}
+}
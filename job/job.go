@@ -0,0 +1,27 @@
+package job
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Every runs task once per interval until ctx is cancelled. Errors are logged
+// and do not stop the schedule.
+func Every(ctx context.Context, name string, interval time.Duration, task func() error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := task(); err != nil {
+					log.Printf("job %s: %v", name, err)
+				}
+			}
+		}
+	}()
+}
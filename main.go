@@ -4,8 +4,14 @@ import (
 	"shopifyx/auth"
 	"shopifyx/config"
 	"shopifyx/delivery"
+	"shopifyx/domain"
+	"shopifyx/job"
+	"shopifyx/repository"
+	"shopifyx/storage"
 
+	"context"
 	"log"
+	"time"
 
 	prometheus "shopifyx/middleware"
 
@@ -27,59 +33,177 @@ func main() {
 	config.InitDB()
 	defer config.CloseDB()
 
+	// Inisialisasi key untuk tanda tangan JWT
+	auth.InitKeys()
+	job.Every(context.Background(), "jwt-key-reload", 5*time.Minute, auth.GetKeySet().Reload)
+
+	// Inisialisasi store dan handler
+	db := config.GetDB()
+	tokenStore := repository.NewTokenRepository(db)
+	userStore := repository.NewUserRepository(db)
+	productStore := repository.NewProductRepository(db)
+	userHandler := delivery.NewUserHandler(userStore, tokenStore)
+	assetStore := repository.NewAssetRepository(db)
+	productHandler := delivery.NewProductHandler(productStore, assetStore, config.ProductRestoreWindow())
+	adminHandler := delivery.NewAdminHandler(userStore, productStore)
+	bankAccountHandler := delivery.NewBankAccountHandler(repository.NewBankAccountRepository(db))
+	paymentStore := repository.NewPaymentRepository(db)
+	paymentHandler := delivery.NewPaymentHandler(paymentStore, assetStore, config.StockReservationTTL())
+	cartHandler := delivery.NewCartHandler(repository.NewCartRepository(db), assetStore)
+	idempotencyStore := repository.NewIdempotencyRepository(db)
+
+	// Inisialisasi penyimpanan gambar (s3, s3-compatible atau local)
+	imageStorage := config.ImageStorage()
+	imageStore, err := storage.NewImageStore(context.Background(), imageStorage)
+	if err != nil {
+		log.Fatal(err)
+	}
+	imageHandler := delivery.NewImageHandler(imageStore, assetStore, imageStorage.PresignExpiry)
+	job.Every(context.Background(), "asset-gc", time.Hour, func() error {
+		return job.CollectOrphanedAssets(context.Background(), imageStore, assetStore, imageStorage.AssetGCGracePeriod)
+	})
+	// Upload presigned yang tidak pernah dikonfirmasi dihapus setelah URL-nya kedaluwarsa
+	job.Every(context.Background(), "incoming-upload-sweep", time.Hour, func() error {
+		return job.SweepIncomingUploads(context.Background(), imageStore, imageStorage.PresignExpiry)
+	})
+	var publicPrefixes []string
+	localImageStore, isLocalImageStore := imageStore.(*storage.LocalImageStore)
+	if isLocalImageStore {
+		// upload lokal diautentikasi dengan signature, bukan JWT
+		publicPrefixes = append(publicPrefixes, localImageStore.URLPath(), storage.DirectUploadPath)
+	}
+
+	// Idempotency-Key untuk retry dari client mobile
+	idempotencyRetention := config.IdempotencyRetention()
+	idempotency := prometheus.Idempotency(idempotencyStore, idempotencyRetention, config.IdempotencyClaimTimeout())
+	job.Every(context.Background(), "idempotency-cleanup", time.Hour, func() error {
+		_, err := idempotencyStore.DeleteExpiredIdempotencyKeys(time.Now().Add(-idempotencyRetention))
+		return err
+	})
+
+	job.Every(context.Background(), "token-cleanup", time.Hour, func() error {
+		return tokenStore.DeleteExpiredTokens(time.Now())
+	})
+
+	// Reservasi stok yang kedaluwarsa dilepas secara berkala
+	job.Every(context.Background(), "reservation-sweeper", time.Minute, func() error {
+		_, err := paymentStore.ReleaseExpiredReservations(time.Now())
+		return err
+	})
+
 	// Inisialisasi Echo framework
 	e := echo.New()
+	e.HTTPErrorHandler = prometheus.HTTPErrorHandler
 
 	// Custom logger
 	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
 
 	// Middleware
+	e.Use(middleware.RequestID())
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	e.Use(echojwt.WithConfig(auth.ConfigJWT()))
+	e.Use(echojwt.WithConfig(auth.ConfigJWT(tokenStore, publicPrefixes...)))
+
+	// Role per route
+	buyer := prometheus.RequireRole(domain.RoleBuyer)
+	seller := prometheus.RequireRole(domain.RoleSeller)
+	admin := prometheus.RequireRole(domain.RoleAdmin)
 
 	//auth
-	//e.POST("/v1/user/register", delivery.RegisterUserHandler)
-	prometheus.NewRoute(e, "/v1/user/register", "POST", delivery.RegisterUserHandler)
+	prometheus.NewRoute(e, "/.well-known/jwks.json", "GET", delivery.JWKSHandler)
+
+	//e.POST("/v1/user/register", userHandler.RegisterUserHandler)
+	prometheus.NewRoute(e, "/v1/user/register", "POST", userHandler.RegisterUserHandler)
 
-	//e.POST("/v1/user/login", delivery.LoginUserHandler)
-	prometheus.NewRoute(e, "/v1/user/login", "POST", delivery.LoginUserHandler)
+	//e.POST("/v1/user/login", userHandler.LoginUserHandler)
+	prometheus.NewRoute(e, "/v1/user/login", "POST", userHandler.LoginUserHandler)
+
+	prometheus.NewRoute(e, "/v1/user/refresh", "POST", userHandler.RefreshTokenHandler)
+	prometheus.NewRoute(e, "/v1/user/logout", "POST", userHandler.LogoutUserHandler)
 
 	//product
-	//e.POST("/v1/product", delivery.CreateProductHandler)
-	prometheus.NewRoute(e, "/v1/product", "POST", delivery.CreateProductHandler)
-	//e.PATCH("/v1/product/:productId", delivery.UpdateProductHandler)
-	prometheus.NewRoute(e, "/v1/product/:productId", "PATCH", delivery.UpdateProductHandler)
-	//e.DELETE("/v1/product/:productId", delivery.DeleteProductHandler)
-	prometheus.NewRoute(e, "/v1/product/:productId", "DELETE", delivery.DeleteProductHandler)
+	//e.POST("/v1/product", productHandler.CreateProductHandler)
+	prometheus.NewRoute(e, "/v1/product", "POST", productHandler.CreateProductHandler, seller, idempotency)
+	//e.PATCH("/v1/product/:productId", productHandler.UpdateProductHandler)
+	prometheus.NewRoute(e, "/v1/product/:productId", "PATCH", productHandler.UpdateProductHandler, seller)
+	//e.DELETE("/v1/product/:productId", productHandler.DeleteProductHandler)
+	prometheus.NewRoute(e, "/v1/product/:productId", "DELETE", productHandler.DeleteProductHandler, seller)
+	prometheus.NewRoute(e, "/v1/product/:productId/restore", "POST", productHandler.RestoreProductHandler, seller)
 
 	//stock managemenet
-	//e.POST("/v1/product/:productId/stock", delivery.UpdateProductStockHandler)
-	prometheus.NewRoute(e, "/v1/product/:productId/stock", "POST", delivery.UpdateProductStockHandler)
+	//e.POST("/v1/product/:productId/stock", productHandler.UpdateProductStockHandler)
+	prometheus.NewRoute(e, "/v1/product/:productId/stock", "POST", productHandler.UpdateProductStockHandler, seller)
+	prometheus.NewRoute(e, "/v1/product/:productId/variants/:variantId/stock", "POST", productHandler.UpdateVariantStockHandler, seller)
+	prometheus.NewRoute(e, "/v1/product/:productId/stock/history", "GET", productHandler.StockHistoryHandler, seller)
+
+	//product images
+	prometheus.NewRoute(e, "/v1/product/:productId/images", "POST", productHandler.AddProductImageHandler, seller)
+	prometheus.NewRoute(e, "/v1/product/:productId/images", "PUT", productHandler.ReorderProductImagesHandler, seller)
+	prometheus.NewRoute(e, "/v1/product/:productId/images/:imageId", "DELETE", productHandler.RemoveProductImageHandler, seller)
+	prometheus.NewRoute(e, "/v1/product/:productId/images/:imageId/primary", "POST", productHandler.SetPrimaryProductImageHandler, seller)
 
 	//bank account
-	//e.POST("/v1/bank/account", delivery.AddBankAccountHandler)
-	prometheus.NewRoute(e, "/v1/bank/account", "POST", delivery.AddBankAccountHandler)
-	//e.GET("/v1/bank/account", delivery.GetBankAccountsHandler)
-	prometheus.NewRoute(e, "/v1/bank/account", "GET", delivery.GetBankAccountsHandler)
-	//e.PATCH("/v1/bank/account/:bankAccountId", delivery.UpdateBankAccountHandler)
-	prometheus.NewRoute(e, "/v1/bank/account/:bankAccountId", "PATCH", delivery.UpdateBankAccountHandler)
+	//e.POST("/v1/bank/account", bankAccountHandler.AddBankAccountHandler)
+	prometheus.NewRoute(e, "/v1/bank/account", "POST", bankAccountHandler.AddBankAccountHandler, seller)
+	//e.GET("/v1/bank/account", bankAccountHandler.GetBankAccountsHandler)
+	prometheus.NewRoute(e, "/v1/bank/account", "GET", bankAccountHandler.GetBankAccountsHandler, seller)
+	//e.PATCH("/v1/bank/account/:bankAccountId", bankAccountHandler.UpdateBankAccountHandler)
+	prometheus.NewRoute(e, "/v1/bank/account/:bankAccountId", "PATCH", bankAccountHandler.UpdateBankAccountHandler, seller)
 
 	//payment
-	//e.POST("/v1/product/:productId/buy", delivery.CreatePaymentHandler)
-	prometheus.NewRoute(e, "/v1/product/:productId/buy", "POST", delivery.CreatePaymentHandler)
+	//e.POST("/v1/product/:productId/buy", paymentHandler.CreatePaymentHandler)
+	prometheus.NewRoute(e, "/v1/product/:productId/reserve", "POST", paymentHandler.ReserveStockHandler, buyer, idempotency)
+	prometheus.NewRoute(e, "/v1/product/:productId/buy", "POST", paymentHandler.CreatePaymentHandler, buyer, idempotency)
+
+	//cart
+	prometheus.NewRoute(e, "/v1/cart", "GET", cartHandler.GetCartHandler, buyer)
+	prometheus.NewRoute(e, "/v1/cart/items", "POST", cartHandler.AddCartItemHandler, buyer)
+	prometheus.NewRoute(e, "/v1/cart/items/:productId", "PATCH", cartHandler.UpdateCartItemHandler, buyer)
+	prometheus.NewRoute(e, "/v1/cart/items/:productId", "DELETE", cartHandler.RemoveCartItemHandler, buyer)
+	prometheus.NewRoute(e, "/v1/cart/checkout", "POST", cartHandler.CheckoutHandler, buyer, idempotency)
+
+	//order
+	prometheus.NewRoute(e, "/v1/order/:orderId", "GET", paymentHandler.GetOrderHandler)
+	prometheus.NewRoute(e, "/v1/order/:orderId/proof", "POST", paymentHandler.SubmitPaymentProofHandler)
+	prometheus.NewRoute(e, "/v1/order/:orderId/cancel", "POST", paymentHandler.CancelOrderHandler)
+	prometheus.NewRoute(e, "/v1/order/:orderId/confirm", "POST", paymentHandler.ConfirmOrderHandler)
+	prometheus.NewRoute(e, "/v1/order/:orderId/reject", "POST", paymentHandler.RejectOrderHandler)
+	prometheus.NewRoute(e, "/v1/order/:orderId/ship", "POST", paymentHandler.ShipOrderHandler)
+	prometheus.NewRoute(e, "/v1/order/:orderId/complete", "POST", paymentHandler.CompleteOrderHandler)
+
+	//purchase history
+	prometheus.NewRoute(e, "/v1/user/purchases", "GET", paymentHandler.GetPurchasesHandler, buyer)
+
+	//seller payment verification
+	prometheus.NewRoute(e, "/v1/seller/payments", "GET", paymentHandler.GetSellerPaymentsHandler, seller)
+	prometheus.NewRoute(e, "/v1/seller/payments/:paymentId/accept", "POST", paymentHandler.AcceptPaymentHandler, seller)
+	prometheus.NewRoute(e, "/v1/seller/payments/:paymentId/reject", "POST", paymentHandler.RejectPaymentHandler, seller)
+
+	//admin
+	prometheus.NewRoute(e, "/v1/admin/users/:userId/ban", "POST", adminHandler.BanUserHandler, admin)
+	prometheus.NewRoute(e, "/v1/admin/users/:userId/unban", "POST", adminHandler.UnbanUserHandler, admin)
+	prometheus.NewRoute(e, "/v1/admin/users/:userId/roles", "PUT", adminHandler.UpdateUserRolesHandler, admin)
+	prometheus.NewRoute(e, "/v1/admin/product/:productId/unlist", "POST", adminHandler.UnlistProductHandler, admin)
 
 	//seach
-	//e.GET("/v1/product", delivery.SearchProductHandler)
-	prometheus.NewRoute(e, "/v1/product", "GET", delivery.SearchProductHandler)
+	//e.GET("/v1/product", productHandler.SearchProductHandler)
+	prometheus.NewRoute(e, "/v1/product", "GET", productHandler.SearchProductHandler)
+	prometheus.NewRoute(e, "/v1/product/tags", "GET", productHandler.PopularTagsHandler)
 
 	//get product
-	//e.GET("/v1/product/:productId", delivery.GetProductHandler)
-	prometheus.NewRoute(e, "/v1/product/:productId", "GET", delivery.GetProductHandler)
+	//e.GET("/v1/product/:productId", productHandler.GetProductHandler)
+	prometheus.NewRoute(e, "/v1/product/:productId", "GET", productHandler.GetProductHandler)
 
 	//image upload
 	//e.POST("/v1/image", delivery.UploadImageHandler)
-	prometheus.NewRoute(e, "/v1/image", "POST", delivery.UploadImageHandler)
+	prometheus.NewRoute(e, "/v1/image", "POST", imageHandler.UploadImageHandler)
+	prometheus.NewRoute(e, "/v1/image/presign", "POST", imageHandler.PresignUploadHandler)
+	prometheus.NewRoute(e, "/v1/image/confirm", "POST", imageHandler.ConfirmUploadHandler)
+	if isLocalImageStore {
+		// hanya gambar yang sudah diproses, upload mentah di incoming/ tidak dilayani
+		e.Static(localImageStore.PublicDir())
+		prometheus.NewRoute(e, storage.DirectUploadPath+"/*", "PUT", imageHandler.DirectUploadHandler)
+	}
 
 	e.Logger.Fatal(e.Start(":8000"))
 }
@@ -0,0 +1,26 @@
+package middleware
+
+import (
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/domain"
+
+	"github.com/labstack/echo/v4"
+)
+
+const MissingRequiredRole = "you don't have permission to perform this action"
+
+// RequireRole only lets the request through when the access token grants at
+// least one of the given roles. It must run after the JWT middleware.
+func RequireRole(roles ...domain.RoleEnum) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !auth.GetClaimsFromToken(c).HasRole(roles...) {
+				return apperror.New(http.StatusForbidden, apperror.CodeForbidden, MissingRequiredRole)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"shopifyx/apperror"
+	"shopifyx/repository"
+	"shopifyx/validation"
+
+	"github.com/labstack/echo/v4"
+)
+
+type errorResponse struct {
+	Error     string        `json:"error"`
+	Code      apperror.Code `json:"code"`
+	Details   interface{}   `json:"details,omitempty"`
+	RequestId string        `json:"requestId,omitempty"`
+}
+
+// HTTPErrorHandler renders every error returned by handlers and middlewares
+// as {"error", "code", "details", "requestId"}. Causes of internal errors are
+// logged and never exposed to the client.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	appErr := ToAppError(err)
+	if appErr.Status >= http.StatusInternalServerError {
+		c.Logger().Error(err)
+	}
+
+	requestId := c.Response().Header().Get(echo.HeaderXRequestID)
+	if requestId == "" {
+		requestId = c.Request().Header.Get(echo.HeaderXRequestID)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(appErr.Status)
+	} else {
+		err = c.JSON(appErr.Status, errorResponse{
+			Error:     appErr.Message,
+			Code:      appErr.Code,
+			Details:   appErr.Details,
+			RequestId: requestId,
+		})
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// ToAppError maps any error to the client facing error it should produce.
+// Repository errors that escape a handler are mapped from their sentinel or
+// postgres error code, anything unknown becomes a 500.
+func ToAppError(err error) *apperror.AppError {
+	var appErr *apperror.AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		message := http.StatusText(httpErr.Code)
+		if httpErr.Message != nil {
+			message = fmt.Sprint(httpErr.Message)
+		}
+		return &apperror.AppError{Status: httpErr.Code, Code: apperror.CodeForStatus(httpErr.Code), Message: message, Err: httpErr.Internal}
+	}
+
+	var validationErrs validation.Errors
+	if errors.As(err, &validationErrs) {
+		return apperror.Validation(validationErrs)
+	}
+
+	// a failed checkout names the cart product it failed on
+	var itemErr *repository.CartItemError
+	if errors.As(err, &itemErr) {
+		if appErr := repositoryError(itemErr.Err); appErr.Status < http.StatusInternalServerError {
+			return appErr.WithDetails(map[string]string{"productId": itemErr.ProductId})
+		}
+	}
+	return repositoryError(err)
+}
+
+// repositoryError maps the sentinels and postgres error codes of the
+// repository package.
+func repositoryError(err error) *apperror.AppError {
+	switch {
+	case errors.Is(err, repository.ErrProductNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeProductNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrBankAccountNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeBankAccountNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrInvalidQuantity):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeInvalidQuantity, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrInsufficientStock):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeInsufficientStock, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrStockBelowZero):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeInsufficientStock, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrStockBelowReserved):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeStockReserved, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrVariantRequired):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeVariantRequired, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrVariantNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeVariantNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrProductHasVariants):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeProductHasVariants, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrProductImageNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeProductImageNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrLastProductImage):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeLastProductImage, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrTooManyProductImages):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeTooManyProductImages, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrInvalidImageOrder):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeInvalidImageOrder, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrPaymentDetailsInvalid):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodePaymentDetailsInvalid, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrReservationNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeReservationNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrReservationExpired):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeReservationExpired, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrReservationMismatch):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeReservationMismatch, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrReservationExists):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeReservationExists, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrPaymentNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeOrderNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrPaymentForbidden):
+		return &apperror.AppError{Status: http.StatusForbidden, Code: apperror.CodeForbidden, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrInvalidStatusTransition):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeInvalidStatusTransition, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrCartEmpty):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeCartEmpty, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrCartItemNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeCartItemNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrCartVariantsUnsupported):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeCartVariantsUnsupported, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrSellerBankAccountMissing):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeSellerBankAccountMissing, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrUserNotFound):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeUserNotFound, Message: err.Error(), Err: err}
+	case errors.Is(err, repository.ErrUserBanned):
+		return &apperror.AppError{Status: http.StatusForbidden, Code: apperror.CodeUserBanned, Message: err.Error(), Err: err}
+	case repository.IsDuplicateKeyError(err):
+		return &apperror.AppError{Status: http.StatusConflict, Code: apperror.CodeConflict, Message: "resource already exists", Err: err}
+	case repository.IsConstrainViolations(err):
+		return &apperror.AppError{Status: http.StatusBadRequest, Code: apperror.CodeValidationFailed, Message: "required fields are missing or invalid", Err: err}
+	case repository.IdNotFound(err), repository.IsForeignKeyViolation(err):
+		return &apperror.AppError{Status: http.StatusNotFound, Code: apperror.CodeNotFound, Message: "resource not found", Err: err}
+	}
+
+	return apperror.Internal(http.StatusText(http.StatusInternalServerError), err)
+}
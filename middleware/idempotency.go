@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"shopifyx/apperror"
+	"shopifyx/auth"
+	"shopifyx/repository"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotencyReplayedHeader = "Idempotent-Replayed"
+
+	IdempotencyKeyTooLong    = "idempotency key must be at most 255 characters"
+	IdempotencyKeyInProgress = "a request with this idempotency key is still being processed"
+	IdempotencyKeyReused     = "idempotency key was already used for a different request"
+	FailedToCheckIdempotency = "failed to check idempotency key"
+	FailedToReadRequest      = "failed to read request body"
+)
+
+type responseRecorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// Idempotency stores the first response sent for an Idempotency-Key header
+// per user and replays it for retries within the retention window. A retry
+// must repeat the method, path and body of the first request. Requests
+// without the header are passed through untouched.
+//
+// A key is claimed while its request runs. The claim is released when the
+// request fails or panics, and a claim older than claimTimeout is taken over
+// by the next retry, so a crash never blocks the key for the whole retention.
+func Idempotency(store repository.IdempotencyStore, retention, claimTimeout time.Duration) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key := c.Request().Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				return next(c)
+			}
+			if len(key) > 255 {
+				return apperror.New(http.StatusBadRequest, apperror.CodeInvalidIdempotencyKey, IdempotencyKeyTooLong)
+			}
+
+			body, err := io.ReadAll(c.Request().Body)
+			if err != nil {
+				return apperror.Internal(FailedToReadRequest, err)
+			}
+			c.Request().Body = io.NopCloser(bytes.NewReader(body))
+			sum := sha256.Sum256(body)
+			requestHash := hex.EncodeToString(sum[:])
+
+			userId := auth.GetUserIdFromToken(c)
+			method := c.Request().Method
+			path := c.Request().URL.Path
+
+			now := time.Now()
+			record, claimed, err := store.ClaimIdempotencyKey(userId, key, method, path, requestHash, now.Add(-retention), now.Add(-claimTimeout))
+			if err != nil {
+				return apperror.Internal(FailedToCheckIdempotency, err)
+			}
+
+			if !claimed {
+				if record.Method != method || record.Path != path || record.RequestHash != requestHash {
+					return apperror.New(http.StatusUnprocessableEntity, apperror.CodeIdempotencyKeyReused, IdempotencyKeyReused)
+				}
+				if !record.Completed {
+					return apperror.New(http.StatusConflict, apperror.CodeIdempotencyKeyInProgress, IdempotencyKeyInProgress)
+				}
+				c.Response().Header().Set(IdempotencyReplayedHeader, "true")
+				return c.Blob(record.StatusCode, record.ContentType, record.ResponseBody)
+			}
+
+			release := func() {
+				if err := store.ReleaseIdempotencyKey(userId, key); err != nil {
+					c.Logger().Error(err)
+				}
+			}
+
+			writer := c.Response().Writer
+			recorder := &responseRecorder{ResponseWriter: writer}
+			c.Response().Writer = recorder
+			defer func() {
+				c.Response().Writer = writer
+				// a panicking handler leaves nothing to replay, let the retry run
+				if r := recover(); r != nil {
+					release()
+					panic(r)
+				}
+			}()
+
+			err = next(c)
+			if err != nil {
+				// render the error now so a rejected request is replayed too
+				c.Error(err)
+			}
+
+			// failed attempts are not remembered so the client can retry them
+			status := c.Response().Status
+			if status >= http.StatusInternalServerError {
+				release()
+				return nil
+			}
+
+			contentType := c.Response().Header().Get(echo.HeaderContentType)
+			if err := store.SaveIdempotencyResponse(userId, key, status, contentType, recorder.body.Bytes()); err != nil {
+				c.Logger().Error(err)
+				release()
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"shopifyx/auth"
+	"shopifyx/middleware"
+	"shopifyx/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+// serve runs one POST carrying an Idempotency-Key through the middleware
+// for a fixed user.
+func serve(t *testing.T, store repository.IdempotencyStore, claimTimeout time.Duration, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := echo.New()
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
+	e.POST("/v1/things", handler, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user", &jwt.Token{Claims: &auth.JwtCustomClaims{Id: "user-1"}})
+			return next(c)
+		}
+	}, middleware.Idempotency(store, time.Hour, claimTimeout))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/things", nil)
+	req.Header.Set(middleware.IdempotencyKeyHeader, "key-1")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func created(c echo.Context) error {
+	return c.String(http.StatusCreated, "created")
+}
+
+func TestIdempotencyReleasesClaimOnPanic(t *testing.T) {
+	store := repository.NewMemoryStore()
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic was swallowed")
+			}
+		}()
+		serve(t, store, time.Hour, func(c echo.Context) error {
+			panic("boom")
+		})
+	}()
+
+	if rec := serve(t, store, time.Hour, created); rec.Code != http.StatusCreated {
+		t.Fatalf("retry after a panic got %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+func TestIdempotencyTakesOverStaleClaims(t *testing.T) {
+	store := repository.NewMemoryStore()
+
+	// a request that never finished, as if the process died while serving it
+	if _, _, err := store.ClaimIdempotencyKey("user-1", "key-1", http.MethodPost, "/v1/things", emptyBodyHash, time.Now().Add(-time.Hour), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := serve(t, store, time.Hour, created); rec.Code != http.StatusConflict {
+		t.Fatalf("fresh claim got %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if rec := serve(t, store, -time.Second, created); rec.Code != http.StatusCreated {
+		t.Fatalf("stale claim got %d, want %d", rec.Code, http.StatusCreated)
+	}
+}
+
+// emptyBodyHash is the sha256 of an empty request body.
+const emptyBodyHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
@@ -2,7 +2,6 @@ package middleware
 
 import (
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -18,8 +17,8 @@ var (
 	}, []string{"path", "method", "status"})
 )
 
-func NewRoute(c *echo.Echo, path string, method string, handler echo.HandlerFunc) {
-	c.Add(method, path, wrapHandlerWithMetrics(path, method, handler))
+func NewRoute(c *echo.Echo, path string, method string, handler echo.HandlerFunc, middlewares ...echo.MiddlewareFunc) {
+	c.Add(method, path, wrapHandlerWithMetrics(path, method, handler), middlewares...)
 }
 
 func wrapHandlerWithMetrics(path string, method string, handler echo.HandlerFunc) echo.HandlerFunc {
@@ -31,14 +30,14 @@ func wrapHandlerWithMetrics(path string, method string, handler echo.HandlerFunc
 
 		// Regardless of whether an error occurred, record the metrics
 		duration := time.Since(startTime).Seconds()
-		statusCode := fmt.Sprintf("%d", c.Response().Status)
+		status := c.Response().Status
 
-		if err != nil {
-			if c.Response().Status == http.StatusOK || c.Response().Status == http.StatusCreated { // Default status code
-				c.Response().Status = http.StatusInternalServerError // Assume internal server error if not set
-			}
-			c.String(http.StatusInternalServerError, err.Error()) // Ensure the response reflects the error
+		// The error response is written later by HTTPErrorHandler, record the
+		// status it is going to send
+		if err != nil && !c.Response().Committed {
+			status = ToAppError(err).Status
 		}
+		statusCode := fmt.Sprintf("%d", status)
 
 		RequestHistogram.WithLabelValues(path, method, statusCode).Observe(duration)
 		return err
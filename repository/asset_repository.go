@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"shopifyx/domain"
+)
+
+const assetColumns = `id, user_id, COALESCE(parent_id::TEXT, ''), rendition, key, url, size, content_type, created_at`
+
+type AssetRepository struct {
+	db *sql.DB
+}
+
+func NewAssetRepository(db *sql.DB) *AssetRepository {
+	return &AssetRepository{db: db}
+}
+
+// CreateAsset registers an uploaded object. Registering the same key again
+// returns the existing asset, so confirming an upload twice is harmless.
+func (r *AssetRepository) CreateAsset(asset *domain.Asset) error {
+	if asset.Rendition == "" {
+		asset.Rendition = "original"
+	}
+
+	query := `
+	INSERT INTO assets (user_id, parent_id, rendition, key, url, size, content_type)
+	VALUES ($1, NULLIF($2, '')::UUID, $3, $4, $5, $6, $7)
+	ON CONFLICT (key) DO UPDATE SET key = EXCLUDED.key
+	RETURNING ` + assetColumns
+
+	return scanAsset(r.db.QueryRow(query,
+		asset.UserId,
+		asset.ParentId,
+		asset.Rendition,
+		asset.Key,
+		asset.URL,
+		asset.Size,
+		asset.ContentType,
+	), asset)
+}
+
+func (r *AssetRepository) GetAssetByURL(url string) (domain.Asset, error) {
+	var asset domain.Asset
+	err := scanAsset(r.db.QueryRow(`SELECT `+assetColumns+` FROM assets WHERE url = $1 LIMIT 1`, url), &asset)
+	if err == sql.ErrNoRows {
+		return asset, ErrAssetNotFound
+	}
+	return asset, err
+}
+
+// GetOrphanedAssets returns up to limit originals created before
+// createdBefore that no product or payment references, through the original
+// or any of its renditions, together with their renditions.
+func (r *AssetRepository) GetOrphanedAssets(createdBefore time.Time, limit int) ([]domain.Asset, error) {
+	query := `
+	WITH orphans AS (
+		SELECT a.id
+		FROM assets a
+		WHERE a.parent_id IS NULL
+		AND a.created_at < $1
+		AND NOT EXISTS (
+			SELECT 1 FROM assets g
+			WHERE (g.id = a.id OR g.parent_id = a.id)
+			AND (
+				EXISTS (SELECT 1 FROM products p WHERE p.image_url = g.url)
+				OR EXISTS (SELECT 1 FROM product_images pi WHERE pi.url = g.url)
+				OR EXISTS (SELECT 1 FROM payments py WHERE py.payment_proof_image_url = g.url)
+			)
+		)
+		ORDER BY a.created_at
+		LIMIT $2
+	)
+	SELECT ` + assetColumns + `
+	FROM assets
+	WHERE id IN (SELECT id FROM orphans) OR parent_id IN (SELECT id FROM orphans)`
+
+	rows, err := r.db.Query(query, createdBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assets []domain.Asset
+	for rows.Next() {
+		var asset domain.Asset
+		if err := scanAsset(rows, &asset); err != nil {
+			return nil, err
+		}
+		assets = append(assets, asset)
+	}
+	return assets, rows.Err()
+}
+
+// DeleteAsset removes an asset and, through the foreign key, its renditions.
+func (r *AssetRepository) DeleteAsset(assetId string) error {
+	_, err := r.db.Exec(`DELETE FROM assets WHERE id = $1`, assetId)
+	return err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAsset(row rowScanner, asset *domain.Asset) error {
+	return row.Scan(
+		&asset.Id,
+		&asset.UserId,
+		&asset.ParentId,
+		&asset.Rendition,
+		&asset.Key,
+		&asset.URL,
+		&asset.Size,
+		&asset.ContentType,
+		&asset.CreatedAt,
+	)
+}
@@ -1,15 +1,22 @@
 package repository
 
 import (
-	"shopifyx/config"
-	"shopifyx/domain"
+	"database/sql"
 
-	"github.com/lib/pq"
+	"shopifyx/domain"
 )
 
-func AddBankAccount(bankAccount *domain.BankAccount, userId string) error {
+type BankAccountRepository struct {
+	db *sql.DB
+}
+
+func NewBankAccountRepository(db *sql.DB) *BankAccountRepository {
+	return &BankAccountRepository{db: db}
+}
+
+func (r *BankAccountRepository) AddBankAccount(bankAccount *domain.BankAccount, userId string) error {
 	query := `INSERT INTO bank_accounts (bank_name, bank_account_name, bank_account_number, user_id) VALUES($1, $2, $3, $4)`
-	_, err := config.GetDB().Exec(
+	_, err := r.db.Exec(
 		query,
 		bankAccount.BankName,
 		bankAccount.BankAccountName,
@@ -22,9 +29,9 @@ func AddBankAccount(bankAccount *domain.BankAccount, userId string) error {
 	return err
 }
 
-func GetBankAccounts(userId string) ([]domain.BankAccount, error) {
+func (r *BankAccountRepository) GetBankAccounts(userId string) ([]domain.BankAccount, error) {
 	query := `SELECT id, bank_name, bank_account_name, bank_account_number FROM bank_accounts WHERE user_id = $1`
-	rows, err := config.GetDB().Query(
+	rows, err := r.db.Query(
 		query,
 		userId,
 	)
@@ -49,7 +56,7 @@ func GetBankAccounts(userId string) ([]domain.BankAccount, error) {
 	return bankAccounts, nil
 }
 
-func UpdateBankAccount(bankAccount *domain.BankAccount, bankAccountId, userId string) (int, error) {
+func (r *BankAccountRepository) UpdateBankAccount(bankAccount *domain.BankAccount, bankAccountId, userId string) (int, error) {
 	query := `
 	WITH updated AS (
 		UPDATE bank_accounts
@@ -65,7 +72,7 @@ func UpdateBankAccount(bankAccount *domain.BankAccount, bankAccountId, userId st
 		END AS result_code;`
 
 	var resultCode int
-	err := config.GetDB().QueryRow(
+	err := r.db.QueryRow(
 		query,
 		bankAccount.BankName,
 		bankAccount.BankAccountName,
@@ -80,12 +87,15 @@ func UpdateBankAccount(bankAccount *domain.BankAccount, bankAccountId, userId st
 	return resultCode, err
 }
 
-func DeleteBankAccount(bankAccountId, userId string) error {
+func (r *BankAccountRepository) DeleteBankAccount(bankAccountId, userId string) error {
 	query := `DELETE FROM bank_accounts WHERE id = $1 AND user_id = $2`
-	result, err := config.GetDB().Exec(
+	result, err := r.db.Exec(
 		query,
 		bankAccountId, userId,
 	)
+	if IdNotFound(err) {
+		return ErrBankAccountNotFound
+	}
 	if err != nil {
 		return err
 	}
@@ -96,8 +106,7 @@ func DeleteBankAccount(bankAccountId, userId string) error {
 		return err
 	}
 	if rowsAffected == 0 {
-		err := &pq.Error{Code: "22P02"}
-		return err
+		return ErrBankAccountNotFound
 	}
 	return nil
 }
@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+
+	"shopifyx/domain"
+
+	"github.com/lib/pq"
+)
+
+type CartRepository struct {
+	db *sql.DB
+}
+
+func NewCartRepository(db *sql.DB) *CartRepository {
+	return &CartRepository{db: db}
+}
+
+// AddCartItem adds quantity of a product to the cart. The cart holds no
+// variants, so products with variants are left out and bought directly.
+func (r *CartRepository) AddCartItem(userId, productId string, quantity int) error {
+	query := `
+	INSERT INTO cart_items (user_id, product_id, quantity)
+	SELECT $1, p.id, $3 FROM products p
+	WHERE p.id = $2 AND p.deleted_at IS NULL
+	AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+	ON CONFLICT (user_id, product_id) DO UPDATE
+	SET quantity = cart_items.quantity + EXCLUDED.quantity, updated_at = NOW()`
+
+	result, err := r.db.Exec(query, userId, productId, quantity)
+	if IdNotFound(err) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		var hasVariants bool
+		err := r.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1)`, productId).Scan(&hasVariants)
+		if err != nil {
+			return err
+		}
+		if hasVariants {
+			return ErrCartVariantsUnsupported
+		}
+		// archived products are kept in the table but cannot be added
+		return ErrProductNotFound
+	}
+	return nil
+}
+
+func (r *CartRepository) UpdateCartItem(userId, productId string, quantity int) error {
+	result, err := r.db.Exec(
+		`UPDATE cart_items SET quantity = $1, updated_at = NOW() WHERE user_id = $2 AND product_id = $3`,
+		quantity, userId, productId,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
+
+func (r *CartRepository) RemoveCartItem(userId, productId string) error {
+	result, err := r.db.Exec(
+		`DELETE FROM cart_items WHERE user_id = $1 AND product_id = $2`,
+		userId, productId,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrCartItemNotFound
+	}
+	return nil
+}
+
+func (r *CartRepository) GetCart(userId string) ([]domain.CartItemResponse, error) {
+	query := `
+	SELECT ci.product_id, p.name, p.price, p.image_url, p.stock, p.is_purchaseable AND p.deleted_at IS NULL, p.user_id, u.name, ci.quantity
+	FROM cart_items ci
+	JOIN products p ON p.id = ci.product_id
+	JOIN users u ON u.id = p.user_id
+	WHERE ci.user_id = $1
+	ORDER BY ci.created_at, ci.product_id`
+
+	rows, err := r.db.Query(query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []domain.CartItemResponse
+	for rows.Next() {
+		var item domain.CartItemResponse
+		err := rows.Scan(
+			&item.ProductId,
+			&item.Name,
+			&item.Price,
+			&item.ImageURL,
+			&item.Stock,
+			&item.IsPurchaseable,
+			&item.SellerId,
+			&item.SellerName,
+			&item.Quantity,
+		)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Checkout turns the whole cart into one checkout order per seller. Every
+// stock is decremented inside a single transaction, so either all items are
+// bought or none are. A reservation the buyer holds on a cart product is
+// claimed by the checkout, so the stock it held counts for the buyer.
+func (r *CartRepository) Checkout(userId string, checkout *domain.Checkout) ([]domain.CheckoutOrderResponse, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the cart rows are locked so a concurrent update cannot slip in between
+	rows, err := tx.Query(`
+	SELECT ci.product_id, ci.quantity, p.user_id, p.price
+	FROM cart_items ci
+	JOIN products p ON p.id = ci.product_id
+	WHERE ci.user_id = $1
+	ORDER BY ci.product_id
+	FOR UPDATE OF ci`, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	type cartLine struct {
+		productId string
+		quantity  int
+		sellerId  string
+		price     int
+	}
+	var lines []cartLine
+	for rows.Next() {
+		var line cartLine
+		if err := rows.Scan(&line.productId, &line.quantity, &line.sellerId, &line.price); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, ErrCartEmpty
+	}
+
+	// products are locked up front in id order, so two carts sharing
+	// products take the locks in the same order and cannot deadlock
+	productIds := make([]string, 0, len(lines))
+	for _, line := range lines {
+		productIds = append(productIds, line.productId)
+	}
+	if _, err := tx.Exec(`SELECT 1 FROM products WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(productIds)); err != nil {
+		return nil, err
+	}
+
+	sellerPayments := make(map[string]domain.SellerPayment)
+	for _, payment := range checkout.Payments {
+		sellerPayments[payment.SellerId] = payment
+	}
+
+	orders := make(map[string]*domain.CheckoutOrderResponse)
+	for _, line := range lines {
+		sellerPayment, ok := sellerPayments[line.sellerId]
+		if !ok {
+			return nil, &CartItemError{ProductId: line.productId, Err: ErrSellerBankAccountMissing}
+		}
+
+		// the buyer's own reservation stops holding the stock out once it is
+		// gone, a failed checkout rolls the claim back with everything else
+		_, err := tx.Exec(`DELETE FROM stock_reservations WHERE product_id = $1 AND buyer_id = $2`, line.productId, userId)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = DecrementProductStockTx(tx, sellerPayment.BankAccountId, line.productId, "", line.quantity)
+		if err == sql.ErrNoRows {
+			isPurchaseable, _, _, checkErr := CheckStockProductAndBankAccountValid(tx, sellerPayment.BankAccountId, line.productId)
+			if checkErr != nil || !isPurchaseable {
+				return nil, &CartItemError{ProductId: line.productId, Err: ErrPaymentDetailsInvalid}
+			}
+			// AddCartItem keeps products with variants out, so this is a lack of stock
+			return nil, &CartItemError{ProductId: line.productId, Err: CheckVariantPurchaseTx(tx, line.productId, "", line.quantity)}
+		}
+		if err != nil {
+			if IdNotFound(err) {
+				return nil, &CartItemError{ProductId: line.productId, Err: ErrPaymentDetailsInvalid}
+			}
+			return nil, err
+		}
+
+		order, ok := orders[line.sellerId]
+		if !ok {
+			order = &domain.CheckoutOrderResponse{SellerId: line.sellerId, BankAccountId: sellerPayment.BankAccountId}
+			err := tx.QueryRow(
+				`INSERT INTO checkout_orders (buyer_id, seller_id, bank_account_id) VALUES ($1, $2, $3) RETURNING id`,
+				userId, line.sellerId, sellerPayment.BankAccountId,
+			).Scan(&order.Id)
+			if err != nil {
+				return nil, err
+			}
+			orders[line.sellerId] = order
+		}
+
+		payment := domain.PaymentResponse{
+			ProductId:            line.productId,
+			BuyerId:              userId,
+			SellerId:             line.sellerId,
+			BankAccountId:        sellerPayment.BankAccountId,
+			PaymentProofImageURL: sellerPayment.PaymentProofImageURL,
+			Quantity:             line.quantity,
+			Status:               domain.Pending,
+		}
+		if payment.PaymentProofImageURL != "" {
+			payment.Status = domain.ProofSubmitted
+		}
+
+		err = tx.QueryRow(`
+		INSERT INTO payments
+		(bank_account_id, payment_proof_image_url, buyer_id, product_id, quantity, status, checkout_order_id)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7)
+		RETURNING id, created_at, updated_at`,
+			payment.BankAccountId,
+			payment.PaymentProofImageURL,
+			userId,
+			line.productId,
+			line.quantity,
+			payment.Status,
+			order.Id,
+		).Scan(&payment.Id, &payment.CreatedAt, &payment.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = InsertStockMovementTx(tx, &domain.StockMovement{
+			ProductId: line.productId,
+			Delta:     -line.quantity,
+			Reason:    domain.Sale,
+			ActorId:   userId,
+			PaymentId: payment.Id,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		order.TotalPrice += line.price * line.quantity
+		order.Payments = append(order.Payments, payment)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM cart_items WHERE user_id = $1`, userId); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return sortedCheckoutOrders(orders), nil
+}
+
+func sortedCheckoutOrders(orders map[string]*domain.CheckoutOrderResponse) []domain.CheckoutOrderResponse {
+	var response []domain.CheckoutOrderResponse
+	for _, order := range orders {
+		response = append(response, *order)
+	}
+	sort.Slice(response, func(i, j int) bool { return response[i].SellerId < response[j].SellerId })
+	return response
+}
@@ -10,13 +10,69 @@ import (
 var (
 	ErrUsernameNotFound = errors.New("username not found")
 	ErrPasswordWrong    = errors.New("wrong password")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserBanned       = errors.New("user is banned")
+	ErrAssetNotFound    = errors.New("asset not found")
+
+	ErrProductNotFound     = errors.New("product not found")
+	ErrBankAccountNotFound = errors.New("bank account not found")
+
+	// ErrConstraintViolation, ErrDuplicateKey and ErrForeignKeyViolation are
+	// returned by stores that enforce the schema themselves, like the
+	// MemoryStore, where postgres would fail with the matching error code.
+	ErrConstraintViolation = errors.New("value violates a check constraint")
+	ErrDuplicateKey        = errors.New("value violates a unique constraint")
+	ErrForeignKeyViolation = errors.New("referenced row does not exist")
+
+	ErrInvalidQuantity       = errors.New("quantity must be at least 1")
+	ErrPaymentDetailsInvalid = errors.New("payment details invalid or product not purchaseable")
+	ErrInsufficientStock     = errors.New("insufficient stock")
+	ErrStockBelowZero        = errors.New("stock cannot go below zero")
+	ErrStockBelowReserved    = errors.New("stock cannot go below the quantity reserved by buyers")
+	ErrVariantRequired       = errors.New("product has variants, a variant must be chosen")
+	ErrVariantNotFound       = errors.New("variant not found")
+	ErrProductHasVariants    = errors.New("product stock is managed by its variants")
+	ErrReservationNotFound   = errors.New("reservation not found")
+	ErrReservationExpired    = errors.New("reservation has expired")
+	ErrReservationMismatch   = errors.New("payment does not match the reservation")
+	ErrReservationExists     = errors.New("buyer already holds a reservation on this product")
+
+	ErrProductImageNotFound = errors.New("product image not found")
+	ErrLastProductImage     = errors.New("a product needs at least one image")
+	ErrTooManyProductImages = errors.New("product gallery is full")
+	ErrInvalidImageOrder    = errors.New("image order must list every image of the product once")
+
+	ErrPaymentNotFound         = errors.New("payment not found")
+	ErrPaymentForbidden        = errors.New("payment belongs to another user")
+	ErrInvalidStatusTransition = errors.New("invalid payment status transition")
+
+	ErrRefreshTokenInvalid = errors.New("refresh token is invalid or expired")
+
+	ErrCartEmpty                = errors.New("cart is empty")
+	ErrCartItemNotFound         = errors.New("product is not in the cart")
+	ErrCartVariantsUnsupported  = errors.New("products with variants cannot be added to the cart, buy them directly")
+	ErrSellerBankAccountMissing = errors.New("choose a bank account for every seller in the cart")
 )
 
+// CartItemError tells which cart product made a checkout fail.
+type CartItemError struct {
+	ProductId string
+	Err       error
+}
+
+func (e *CartItemError) Error() string {
+	return e.Err.Error() + ": " + e.ProductId
+}
+
+func (e *CartItemError) Unwrap() error {
+	return e.Err
+}
+
 func IsConstrainViolations(err error) bool {
 	if pqErr, ok := err.(*pq.Error); ok {
 		return pqErr.Code == "23514"
 	}
-	return false
+	return errors.Is(err, ErrConstraintViolation)
 }
 
 func IdNotFound(err error) bool {
@@ -26,6 +82,13 @@ func IdNotFound(err error) bool {
 	return false
 }
 
+func IsForeignKeyViolation(err error) bool {
+	if pqErr, ok := err.(*pq.Error); ok {
+		return pqErr.Code == "23503"
+	}
+	return errors.Is(err, ErrForeignKeyViolation)
+}
+
 func DontHavePermission(err error) bool {
 	return err == sql.ErrNoRows
 }
@@ -34,7 +97,7 @@ func IsDuplicateKeyError(err error) bool {
 	if pqErr, ok := err.(*pq.Error); ok {
 		return pqErr.Code == "23505"
 	}
-	return false
+	return errors.Is(err, ErrDuplicateKey)
 }
 
 func InvalidUsernameAndPasswod(err error) bool {
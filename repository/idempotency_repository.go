@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"shopifyx/domain"
+)
+
+type IdempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) *IdempotencyRepository {
+	return &IdempotencyRepository{db: db}
+}
+
+// ClaimIdempotencyKey reserves key for the user. When the key is already held
+// by a record newer than expiredBefore, that record is returned with claimed
+// set to false. A claim still in progress since before staleBefore was left
+// behind by a request that never finished and is taken over.
+func (r *IdempotencyRepository) ClaimIdempotencyKey(userId, key, method, path, requestHash string, expiredBefore, staleBefore time.Time) (domain.IdempotencyRecord, bool, error) {
+	query := `
+	INSERT INTO idempotency_keys (user_id, key, method, path, request_hash)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (user_id, key) DO UPDATE
+	SET method = EXCLUDED.method, path = EXCLUDED.path, request_hash = EXCLUDED.request_hash,
+		status_code = NULL, content_type = NULL, response_body = NULL, created_at = NOW()
+	WHERE idempotency_keys.created_at < $6
+	OR (idempotency_keys.status_code IS NULL AND idempotency_keys.created_at < $7)
+	RETURNING created_at`
+
+	record := domain.IdempotencyRecord{UserId: userId, Key: key, Method: method, Path: path, RequestHash: requestHash}
+	err := r.db.QueryRow(query, userId, key, method, path, requestHash, expiredBefore, staleBefore).Scan(&record.CreatedAt)
+	if err == nil {
+		return record, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return record, false, err
+	}
+
+	var statusCode sql.NullInt64
+	var contentType sql.NullString
+	err = r.db.QueryRow(`
+	SELECT method, path, request_hash, status_code, content_type, response_body, created_at
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`,
+		userId, key,
+	).Scan(&record.Method, &record.Path, &record.RequestHash, &statusCode, &contentType, &record.ResponseBody, &record.CreatedAt)
+	if err != nil {
+		return record, false, err
+	}
+
+	record.StatusCode = int(statusCode.Int64)
+	record.ContentType = contentType.String
+	record.Completed = statusCode.Valid
+	return record, false, nil
+}
+
+func (r *IdempotencyRepository) SaveIdempotencyResponse(userId, key string, statusCode int, contentType string, body []byte) error {
+	_, err := r.db.Exec(
+		`UPDATE idempotency_keys SET status_code = $1, content_type = $2, response_body = $3 WHERE user_id = $4 AND key = $5`,
+		statusCode, contentType, body, userId, key,
+	)
+	return err
+}
+
+func (r *IdempotencyRepository) ReleaseIdempotencyKey(userId, key string) error {
+	_, err := r.db.Exec(
+		`DELETE FROM idempotency_keys WHERE user_id = $1 AND key = $2 AND status_code IS NULL`,
+		userId, key,
+	)
+	return err
+}
+
+func (r *IdempotencyRepository) DeleteExpiredIdempotencyKeys(expiredBefore time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM idempotency_keys WHERE created_at < $1`, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,266 @@
+package repository
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"shopifyx/domain"
+	"shopifyx/util"
+)
+
+// wordSimilarityThreshold is pg_trgm's default for the <% operator.
+const wordSimilarityThreshold = 0.6
+
+// memorySearchMatch is what the in-memory store computes for a product that
+// matched a search term, mirroring the Postgres full-text query.
+type memorySearchMatch struct {
+	relevance float64
+	highlight string
+}
+
+// memorySearchKey holds every column a search can be sorted by.
+type memorySearchKey struct {
+	price     int
+	createdAt time.Time
+	relevance float64
+	id        string
+}
+
+// compareSearchKeys orders two products by the sort column, then by id, the
+// way ORDER BY <column>, p.id does.
+func compareSearchKeys(sortBy util.SortEnum, a, b memorySearchKey) int {
+	switch sortBy {
+	case util.Date:
+		if c := a.createdAt.Compare(b.createdAt); c != 0 {
+			return c
+		}
+	case util.Relevance:
+		if a.relevance != b.relevance {
+			if a.relevance < b.relevance {
+				return -1
+			}
+			return 1
+		}
+	default:
+		if a.price != b.price {
+			if a.price < b.price {
+				return -1
+			}
+			return 1
+		}
+	}
+	return strings.Compare(a.id, b.id)
+}
+
+func cursorSearchKey(cursor *util.SearchCursor) (memorySearchKey, error) {
+	key := memorySearchKey{id: cursor.Id}
+	var err error
+	switch cursor.SortBy {
+	case util.Date:
+		key.createdAt, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case util.Relevance:
+		key.relevance, err = strconv.ParseFloat(cursor.Value, 64)
+	default:
+		key.price, err = strconv.Atoi(cursor.Value)
+	}
+	return key, err
+}
+
+// matchPriceAndStock checks the price range and available stock against the
+// variants of a product that has them, any one variant matching is enough.
+func matchPriceAndStock(product domain.ProductResponse, searchPagination *util.SearchPagination) bool {
+	matches := func(price, stock int) bool {
+		return (searchPagination.ShowEmptyStock || stock > 0) &&
+			(searchPagination.MaxPrice == 0 || price <= searchPagination.MaxPrice) &&
+			(searchPagination.MinPrice == 0 || price >= searchPagination.MinPrice)
+	}
+
+	if len(product.Variants) == 0 {
+		return matches(product.Price, product.Stock)
+	}
+	for _, variant := range product.Variants {
+		if matches(variant.Price, variant.Stock) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTags mirrors the && (any) and @> (all) array operators.
+func matchTags(productTags, tags []string, mode util.TagMatchEnum) bool {
+	has := make(map[string]bool, len(productTags))
+	for _, tag := range productTags {
+		has[tag] = true
+	}
+	for _, tag := range tags {
+		if has[tag] && mode != util.TagMatchAll {
+			return true
+		}
+		if !has[tag] && mode == util.TagMatchAll {
+			return false
+		}
+	}
+	return mode == util.TagMatchAll
+}
+
+func searchWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// matchSearch reports whether every term of search is a word of the name or
+// tags, or whether search is close enough to a word of the name to be a typo.
+func matchSearch(search, name string, tags []string) (memorySearchMatch, bool) {
+	document := strings.TrimSpace(name + " " + strings.Join(tags, " "))
+	terms := searchWords(search)
+
+	words := make(map[string]bool)
+	for _, word := range searchWords(document) {
+		words[word] = true
+	}
+	fullText := len(terms) > 0
+	for _, term := range terms {
+		if !words[term] {
+			fullText = false
+			break
+		}
+	}
+
+	similarity := wordSimilarity(search, name)
+	if !fullText && similarity < wordSimilarityThreshold {
+		return memorySearchMatch{}, false
+	}
+
+	match := memorySearchMatch{relevance: similarity, highlight: document}
+	if fullText {
+		match.relevance += float64(len(terms))
+		match.highlight = highlightTerms(document, terms)
+	}
+	return match, true
+}
+
+// highlightTerms escapes document and wraps every word equal to one of terms
+// in <mark>, like ts_headline with markHighlight does.
+func highlightTerms(document string, terms []string) string {
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	var b strings.Builder
+	var word []rune
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if wanted[strings.ToLower(string(word))] {
+			b.WriteString(highlightStart + string(word) + highlightStop)
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range document {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word = append(word, r)
+			continue
+		}
+		flush()
+		b.WriteRune(r)
+	}
+	flush()
+	return markHighlight(b.String())
+}
+
+// trigrams splits s into pg_trgm style trigrams, each word padded with two
+// leading spaces and one trailing space.
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, word := range searchWords(s) {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+func trigramSimilarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	common := 0
+	for t := range a {
+		if b[t] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(a)+len(b)-common)
+}
+
+// wordSimilarity approximates pg_trgm's word_similarity by taking the best
+// similarity between search and any run of consecutive words of name.
+func wordSimilarity(search, name string) float64 {
+	query := trigrams(search)
+	words := searchWords(name)
+
+	best := 0.0
+	for i := range words {
+		for j := i + 1; j <= len(words); j++ {
+			if similarity := trigramSimilarity(query, trigrams(strings.Join(words[i:j], " "))); similarity > best {
+				best = similarity
+			}
+		}
+	}
+	return best
+}
+
+// memorySearchFacets mirrors searchFacets over the products that matched the
+// filters, before paging.
+func memorySearchFacets(searchPagination *util.SearchPagination, matched []domain.ProductResponse) *util.SearchFacets {
+	facets := &util.SearchFacets{}
+
+	if searchPagination.HasFacet(util.FacetCondition) {
+		counts := make(map[domain.ConditionEnum]int)
+		for _, product := range matched {
+			counts[product.Condition]++
+		}
+		facets.Condition = util.ConditionFacet(counts)
+	}
+
+	if searchPagination.HasFacet(util.FacetPrice) {
+		counts := make(map[int]int)
+		for _, product := range matched {
+			counts[sort.SearchInts(util.PriceFacetBounds, product.Price+1)]++
+		}
+		facets.Price = util.PriceFacet(counts)
+	}
+
+	if searchPagination.HasFacet(util.FacetTags) {
+		counts := make(map[string]int)
+		for _, product := range matched {
+			for _, tag := range product.Tags {
+				counts[tag]++
+			}
+		}
+		facets.Tags = sortedTagCounts(counts, util.MaxTagFacets)
+	}
+
+	if searchPagination.HasFacet(util.FacetStock) {
+		var available, unavailable int
+		for _, product := range matched {
+			if product.Stock > 0 {
+				available++
+			} else {
+				unavailable++
+			}
+		}
+		facets.Stock = util.StockFacet(available, unavailable)
+	}
+
+	return facets
+}
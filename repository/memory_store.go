@@ -0,0 +1,1679 @@
+package repository
+
+import (
+	"database/sql"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"shopifyx/auth"
+	"shopifyx/domain"
+	"shopifyx/util"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// MemoryStore is an in-memory implementation of every store interface. It
+// mirrors the constraints of the Postgres schema, failing with the errors
+// IsConstrainViolations and friends recognise, so handlers behave the same way
+// against it, which makes it suitable for tests.
+type MemoryStore struct {
+	mu           sync.RWMutex
+	users        map[string]*memoryUser
+	products     map[string]*memoryProduct
+	bankAccounts map[string]*domain.BankAccount
+	payments     map[string]*memoryPayment
+	idempotency  map[[2]string]*domain.IdempotencyRecord
+	carts        map[string][]*domain.CartItem
+	refresh      map[string]*memoryRefreshToken
+	revoked      map[string]time.Time
+	assets       map[string]*domain.Asset
+	// stockMovements is the stock ledger, oldest first
+	stockMovements []*domain.StockMovement
+	reservations   map[string]*memoryReservation
+}
+
+type memoryUser struct {
+	domain.User
+	hashedPassword string
+	banned         bool
+}
+
+type memoryProduct struct {
+	domain.ProductResponse
+	userId    string
+	createdAt time.Time
+	variants  []*memoryVariant
+	// images is the gallery in order, the first one is the primary image
+	images []*memoryImage
+	// deletedAt is set while the product is archived
+	deletedAt *time.Time
+}
+
+// purchaseable mirrors the is_purchaseable column of seller_bank_account.
+func (p *memoryProduct) purchaseable() bool {
+	return p.IsPurchaseable && p.deletedAt == nil
+}
+
+type memoryImage struct {
+	id  string
+	url string
+}
+
+type memoryVariant struct {
+	id      string
+	sku     string
+	options map[string]string
+	price   *int
+	stock   int
+}
+
+// variantPrice falls back to the product price like COALESCE(v.price, p.price).
+func (p *memoryProduct) variantPrice(variant *memoryVariant) int {
+	if variant.price != nil {
+		return *variant.price
+	}
+	return p.Price
+}
+
+func (p *memoryProduct) variant(variantId string) *memoryVariant {
+	for _, variant := range p.variants {
+		if variant.id == variantId {
+			return variant
+		}
+	}
+	return nil
+}
+
+func (p *memoryProduct) syncVariantsStock() {
+	p.Stock = 0
+	for _, variant := range p.variants {
+		p.Stock += variant.stock
+	}
+}
+
+func (p *memoryProduct) imagePosition(imageId string) int {
+	for position, image := range p.images {
+		if image.id == imageId {
+			return position
+		}
+	}
+	return -1
+}
+
+func (p *memoryProduct) gallery() []domain.ProductImage {
+	images := make([]domain.ProductImage, 0, len(p.images))
+	for position, image := range p.images {
+		images = append(images, domain.ProductImage{Id: image.id, URL: image.url, Position: position, Primary: position == 0})
+	}
+	return images
+}
+
+// setImages replaces the gallery and mirrors its first image into ImageURL.
+func (p *memoryProduct) setImages(images []*memoryImage) []domain.ProductImage {
+	p.images = images
+	p.ImageURL = images[0].url
+	return p.gallery()
+}
+
+// response copies the product out of the store with its variants and images.
+func (p *memoryProduct) response() domain.ProductResponse {
+	response := p.ProductResponse
+	response.Images = p.gallery()
+	response.DeletedAt = p.deletedAt
+	response.Variants = nil
+	for _, variant := range p.variants {
+		response.Variants = append(response.Variants, domain.ProductVariantResponse{
+			Id:      variant.id,
+			SKU:     variant.sku,
+			Options: variant.options,
+			Price:   p.variantPrice(variant),
+			Stock:   variant.stock,
+		})
+	}
+	return response
+}
+
+type memoryPayment struct {
+	domain.PaymentResponse
+}
+
+type memoryReservation struct {
+	productId string
+	variantId string
+	buyerId   string
+	quantity  int
+	expiresAt time.Time
+}
+
+type memoryRefreshToken struct {
+	userId    string
+	expiresAt time.Time
+	revoked   bool
+}
+
+var urlPattern = regexp.MustCompile(`(?i)^https?://`)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:        make(map[string]*memoryUser),
+		products:     make(map[string]*memoryProduct),
+		bankAccounts: make(map[string]*domain.BankAccount),
+		payments:     make(map[string]*memoryPayment),
+		idempotency:  make(map[[2]string]*domain.IdempotencyRecord),
+		carts:        make(map[string][]*domain.CartItem),
+		refresh:      make(map[string]*memoryRefreshToken),
+		revoked:      make(map[string]time.Time),
+		assets:       make(map[string]*domain.Asset),
+		reservations: make(map[string]*memoryReservation),
+	}
+}
+
+func newMemoryId() string {
+	id, _ := uuid.NewV4()
+	return id.String()
+}
+
+// recordStockMovement appends a stock change that was just applied to
+// product, mirroring InsertStockMovementTx.
+func (s *MemoryStore) recordStockMovement(product *memoryProduct, movement domain.StockMovement) {
+	if movement.Delta == 0 {
+		return
+	}
+	movement.Id = newMemoryId()
+	movement.ProductId = product.Id
+	movement.StockAfter = product.Stock
+	movement.CreatedAt = time.Now()
+	s.stockMovements = append(s.stockMovements, &movement)
+}
+
+// availableStock is the stock of a product and, keyed by variant id, of its
+// variants once unexpired reservations are held out, mirroring
+// reservedProductStock and reservedVariantStock.
+func (s *MemoryStore) availableStock(product *memoryProduct) (int, map[string]int) {
+	stock := product.Stock
+	variantStock := make(map[string]int, len(product.variants))
+	for _, variant := range product.variants {
+		variantStock[variant.id] = variant.stock
+	}
+
+	now := time.Now()
+	for _, reservation := range s.reservations {
+		if reservation.productId != product.Id || !reservation.expiresAt.After(now) {
+			continue
+		}
+		stock -= reservation.quantity
+		if reservation.variantId != "" {
+			variantStock[reservation.variantId] -= reservation.quantity
+		}
+	}
+	return stock, variantStock
+}
+
+// heldBy is the quantity of productId the unexpired reservations of buyerId
+// hold, which a checkout of the buyer claims.
+func (s *MemoryStore) heldBy(productId, buyerId string) int {
+	held := 0
+	now := time.Now()
+	for _, reservation := range s.reservations {
+		if reservation.productId == productId && reservation.buyerId == buyerId && reservation.expiresAt.After(now) {
+			held += reservation.quantity
+		}
+	}
+	return held
+}
+
+// availableResponse is the response of a product showing only the stock that
+// is not reserved.
+func (s *MemoryStore) availableResponse(product *memoryProduct) domain.ProductResponse {
+	response := product.response()
+	stock, variantStock := s.availableStock(product)
+	response.Stock = max(stock, 0)
+	for i := range response.Variants {
+		response.Variants[i].Stock = max(variantStock[response.Variants[i].Id], 0)
+	}
+	return response
+}
+
+func between(s string, min, max int) bool {
+	return len(s) >= min && len(s) <= max
+}
+
+// pageBounds returns the slice bounds of a LIMIT/OFFSET page over total items.
+func pageBounds(total, limit, offset int) (int, int) {
+	if offset > total {
+		offset = total
+	}
+	end := total
+	if limit > 0 && offset+limit < end {
+		end = offset + limit
+	}
+	return offset, end
+}
+
+func checkProduct(product *domain.Product) error {
+	if !between(product.Name, 5, 60) || product.Price < 0 || !urlPattern.MatchString(product.ImageURL) || product.Stock < 0 {
+		return ErrConstraintViolation
+	}
+	if product.Condition != domain.New && product.Condition != domain.Second {
+		return ErrConstraintViolation
+	}
+	return nil
+}
+
+func checkVariants(variants []domain.ProductVariant) error {
+	skus := make(map[string]bool, len(variants))
+	for _, variant := range variants {
+		if !between(variant.SKU, 1, 64) || variant.Stock < 0 || (variant.Price != nil && *variant.Price < 0) {
+			return ErrConstraintViolation
+		}
+		if skus[variant.SKU] {
+			return ErrDuplicateKey
+		}
+		skus[variant.SKU] = true
+	}
+	return nil
+}
+
+func checkBankAccount(bankAccount *domain.BankAccount) error {
+	if !between(bankAccount.BankName, 5, 15) || !between(bankAccount.BankAccountName, 5, 15) || !between(bankAccount.BankAccountNumber, 5, 15) {
+		return ErrConstraintViolation
+	}
+	return nil
+}
+
+func (s *MemoryStore) soldCount(productId string) int {
+	total := 0
+	for _, payment := range s.payments {
+		if payment.ProductId == productId && !payment.Status.ReleasesStock() {
+			total += payment.Quantity
+		}
+	}
+	return total
+}
+
+func (s *MemoryStore) sellerSoldCount(userId string) int {
+	total := 0
+	for _, product := range s.products {
+		if product.userId == userId {
+			total += s.soldCount(product.Id)
+		}
+	}
+	return total
+}
+
+func (s *MemoryStore) RegisterUser(username, name, password string) (domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			return domain.User{}, ErrDuplicateKey
+		}
+	}
+	if !between(username, 5, 15) || !between(name, 5, 50) || len(password) < 5 {
+		return domain.User{}, ErrConstraintViolation
+	}
+
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	user := &memoryUser{
+		User:           domain.User{Id: newMemoryId(), Username: username, Name: name, Roles: domain.DefaultRoles},
+		hashedPassword: hashedPassword,
+	}
+	s.users[user.Id] = user
+	return user.User, nil
+}
+
+func (s *MemoryStore) LoginUser(username, password string) (domain.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Username != username {
+			continue
+		}
+		if err := auth.VerifyPassword(user.hashedPassword, password); err != nil {
+			return domain.User{}, ErrPasswordWrong
+		}
+		if user.banned {
+			return domain.User{}, ErrUserBanned
+		}
+		return user.User, nil
+	}
+	return domain.User{}, ErrUsernameNotFound
+}
+
+func (s *MemoryStore) UpdateUserRoles(userId string, roles []domain.RoleEnum) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if len(roles) == 0 {
+		return ErrConstraintViolation
+	}
+	for _, role := range roles {
+		if !role.IsValid() {
+			return ErrConstraintViolation
+		}
+	}
+	user.Roles = append([]domain.RoleEnum(nil), roles...)
+	return nil
+}
+
+func (s *MemoryStore) SetUserBanned(userId string, banned bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userId]
+	if !ok {
+		return ErrUserNotFound
+	}
+	user.banned = banned
+	if banned {
+		for _, token := range s.refresh {
+			if token.userId == userId {
+				token.revoked = true
+			}
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateProduct(product *domain.Product, userId string) error {
+	if err := checkProduct(product); err != nil {
+		return err
+	}
+	if err := checkVariants(product.Variants); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stock := product.Stock
+	if len(product.Variants) > 0 {
+		stock = 0
+	}
+
+	id := newMemoryId()
+	stored := &memoryProduct{
+		ProductResponse: domain.ProductResponse{
+			Id:             id,
+			Name:           product.Name,
+			Price:          product.Price,
+			ImageURL:       product.ImageURL,
+			Stock:          stock,
+			Condition:      product.Condition,
+			Tags:           product.Tags,
+			IsPurchaseable: product.IsPurchaseable,
+		},
+		userId:    userId,
+		createdAt: time.Now(),
+	}
+	s.products[id] = stored
+	s.recordStockMovement(stored, domain.StockMovement{Delta: stock, Reason: domain.Restock, ActorId: userId})
+
+	for _, variant := range product.Variants {
+		added := &memoryVariant{
+			id:      newMemoryId(),
+			sku:     variant.SKU,
+			options: variant.Options,
+			price:   variant.Price,
+			stock:   variant.Stock,
+		}
+		stored.variants = append(stored.variants, added)
+		stored.Stock += added.stock
+		s.recordStockMovement(stored, domain.StockMovement{VariantId: added.id, Delta: added.stock, Reason: domain.Restock, ActorId: userId})
+	}
+	for _, url := range galleryURLs(product) {
+		stored.images = append(stored.images, &memoryImage{id: newMemoryId(), url: url})
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetProductById(productId string, withArchived bool) (domain.ProductResponse, domain.SellerResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, ok := s.products[productId]
+	if !ok || (product.deletedAt != nil && !withArchived) {
+		return domain.ProductResponse{}, domain.SellerResponse{}, ErrProductNotFound
+	}
+
+	response := s.availableResponse(product)
+	response.PurchaseCount = s.soldCount(productId)
+
+	var seller domain.SellerResponse
+	if user, ok := s.users[product.userId]; ok {
+		seller.Name = user.Name
+	}
+	seller.ProductSoldTotal = s.sellerSoldCount(product.userId)
+	for _, acc := range s.bankAccounts {
+		if acc.UserId == product.userId {
+			seller.BankAccounts = append(seller.BankAccounts, domain.BankAccounts{
+				Id:                acc.Id,
+				BankName:          acc.BankName,
+				BankAccountName:   acc.BankAccountName,
+				BankAccountNumber: acc.BankAccountNumber,
+			})
+		}
+	}
+	return response, seller, nil
+}
+
+func (s *MemoryStore) UpdateProduct(product *domain.Product, productId, userId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.activeProduct(productId)
+	if !ok {
+		return 2, nil
+	}
+	if stored.userId != userId {
+		return 3, nil
+	}
+
+	// stock is not part of a product update, validate against the stored one
+	candidate := *product
+	candidate.Stock = stored.Stock
+	if err := checkProduct(&candidate); err != nil {
+		return 0, err
+	}
+
+	stored.Name = product.Name
+	stored.Price = product.Price
+	stored.ImageURL = product.ImageURL
+	if len(stored.images) > 0 {
+		stored.images[0].url = product.ImageURL
+	}
+	stored.Condition = product.Condition
+	stored.Tags = product.Tags
+	stored.IsPurchaseable = product.IsPurchaseable
+	return 1, nil
+}
+
+func (s *MemoryStore) DeleteProductById(productId, userId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.activeProduct(productId)
+	if !ok {
+		return 2, nil
+	}
+	if stored.userId != userId {
+		return 3, nil
+	}
+	now := time.Now()
+	stored.deletedAt = &now
+	return 1, nil
+}
+
+func (s *MemoryStore) RestoreProduct(productId, userId string, deletedSince time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.products[productId]
+	if !ok || stored.deletedAt == nil {
+		return 2, nil
+	}
+	if stored.userId != userId {
+		return 3, nil
+	}
+	if stored.deletedAt.Before(deletedSince) {
+		return 4, nil
+	}
+	stored.deletedAt = nil
+	return 1, nil
+}
+
+// activeProduct looks a product up, treating archived ones as missing.
+func (s *MemoryStore) activeProduct(productId string) (*memoryProduct, bool) {
+	product, ok := s.products[productId]
+	if !ok || product.deletedAt != nil {
+		return nil, false
+	}
+	return product, true
+}
+
+func (s *MemoryStore) UnlistProduct(productId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productId]
+	if !ok {
+		return ErrProductNotFound
+	}
+	product.IsPurchaseable = false
+	return nil
+}
+
+func (s *MemoryStore) GetUserIdFromProductId(productId string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, ok := s.activeProduct(productId)
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return product.userId, nil
+}
+
+func (s *MemoryStore) UpdateProductStock(productId, actorId string, update *domain.StockUpdate) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productId]
+	if !ok {
+		return 0, ErrProductNotFound
+	}
+	if len(product.variants) > 0 {
+		return 0, ErrProductHasVariants
+	}
+	delta, reason := update.Movement(product.Stock)
+	if product.Stock+delta < 0 {
+		return 0, ErrStockBelowZero
+	}
+	if available, _ := s.availableStock(product); available+delta < 0 {
+		return 0, ErrStockBelowReserved
+	}
+	product.Stock += delta
+	s.recordStockMovement(product, domain.StockMovement{Delta: delta, Reason: reason, ActorId: actorId})
+	return product.Stock, nil
+}
+
+func (s *MemoryStore) UpdateVariantStock(productId, variantId, actorId string, update *domain.StockUpdate) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productId]
+	if !ok {
+		return 0, ErrVariantNotFound
+	}
+	variant := product.variant(variantId)
+	if variant == nil {
+		return 0, ErrVariantNotFound
+	}
+	delta, reason := update.Movement(variant.stock)
+	if variant.stock+delta < 0 {
+		return 0, ErrStockBelowZero
+	}
+	if _, variantStock := s.availableStock(product); variantStock[variantId]+delta < 0 {
+		return 0, ErrStockBelowReserved
+	}
+	variant.stock += delta
+	product.syncVariantsStock()
+	s.recordStockMovement(product, domain.StockMovement{VariantId: variantId, Delta: delta, Reason: reason, ActorId: actorId})
+	return variant.stock, nil
+}
+
+func (s *MemoryStore) GetStockHistory(productId string, stockPagination *util.StockHistoryPagination) ([]domain.StockMovement, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []domain.StockMovement
+	for i := len(s.stockMovements) - 1; i >= 0; i-- {
+		movement := s.stockMovements[i]
+		if movement.ProductId != productId {
+			continue
+		}
+		if stockPagination.VariantId != "" && movement.VariantId != stockPagination.VariantId {
+			continue
+		}
+		if stockPagination.Reason != "" && movement.Reason != stockPagination.Reason {
+			continue
+		}
+		if !stockPagination.From.IsZero() && movement.CreatedAt.Before(stockPagination.From) {
+			continue
+		}
+		if !stockPagination.To.IsZero() && !movement.CreatedAt.Before(stockPagination.To) {
+			continue
+		}
+		matched = append(matched, *movement)
+	}
+
+	start, end := pageBounds(len(matched), stockPagination.Limit, stockPagination.Offset)
+	if start == end {
+		return nil, len(matched), nil
+	}
+	return matched[start:end], len(matched), nil
+}
+
+func (s *MemoryStore) AddProductImage(productId, url string) ([]domain.ProductImage, error) {
+	if !urlPattern.MatchString(url) {
+		return nil, ErrConstraintViolation
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.activeProduct(productId)
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	if len(product.images) >= domain.MaxProductImages {
+		return nil, ErrTooManyProductImages
+	}
+	return product.setImages(append(product.images, &memoryImage{id: newMemoryId(), url: url})), nil
+}
+
+func (s *MemoryStore) RemoveProductImage(productId, imageId string) ([]domain.ProductImage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.activeProduct(productId)
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	position := product.imagePosition(imageId)
+	if position < 0 {
+		return nil, ErrProductImageNotFound
+	}
+	if len(product.images) == 1 {
+		return nil, ErrLastProductImage
+	}
+
+	images := append([]*memoryImage{}, product.images[:position]...)
+	return product.setImages(append(images, product.images[position+1:]...)), nil
+}
+
+func (s *MemoryStore) ReorderProductImages(productId string, imageIds []string) ([]domain.ProductImage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.activeProduct(productId)
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	if !isImageOrder(product.gallery(), imageIds) {
+		return nil, ErrInvalidImageOrder
+	}
+
+	images := make([]*memoryImage, 0, len(imageIds))
+	for _, imageId := range imageIds {
+		images = append(images, product.images[product.imagePosition(imageId)])
+	}
+	return product.setImages(images), nil
+}
+
+func (s *MemoryStore) SetPrimaryProductImage(productId, imageId string) ([]domain.ProductImage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.activeProduct(productId)
+	if !ok {
+		return nil, ErrProductNotFound
+	}
+	position := product.imagePosition(imageId)
+	if position < 0 {
+		return nil, ErrProductImageNotFound
+	}
+
+	images := []*memoryImage{product.images[position]}
+	images = append(images, product.images[:position]...)
+	return product.setImages(append(images, product.images[position+1:]...)), nil
+}
+
+func (s *MemoryStore) SearchProduct(searchPagination *util.SearchPagination, userId string) ([]domain.ProductResponse, util.SearchPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*memoryProduct
+	matches := make(map[string]memorySearchMatch)
+	responses := make(map[string]domain.ProductResponse)
+	for _, product := range s.products {
+		if (product.deletedAt != nil) != searchPagination.Archived {
+			continue
+		}
+		if searchPagination.UserOnly && product.userId != userId {
+			continue
+		}
+		if searchPagination.Condition != "" && product.Condition != searchPagination.Condition {
+			continue
+		}
+		response := s.availableResponse(product)
+		if !matchPriceAndStock(response, searchPagination) {
+			continue
+		}
+		if len(searchPagination.Tags) > 0 && !matchTags(product.Tags, searchPagination.Tags, searchPagination.TagMatch) {
+			continue
+		}
+		if searchPagination.Search != "" {
+			match, ok := matchSearch(searchPagination.Search, product.Name, product.Tags)
+			if !ok {
+				continue
+			}
+			matches[product.Id] = match
+		}
+		responses[product.Id] = response
+		matched = append(matched, product)
+	}
+
+	keyOf := func(product *memoryProduct) memorySearchKey {
+		return memorySearchKey{price: product.Price, createdAt: product.createdAt, relevance: matches[product.Id].relevance, id: product.Id}
+	}
+
+	direction := 1
+	if searchPagination.Order() == util.Descending {
+		direction = -1
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return compareSearchKeys(searchPagination.SortBy, keyOf(matched[i]), keyOf(matched[j]))*direction < 0
+	})
+
+	page := util.SearchPage{}
+	if searchPagination.WithTotal {
+		total := len(matched)
+		page.Total = &total
+	}
+	if len(searchPagination.Facets) > 0 {
+		matchedResponses := make([]domain.ProductResponse, len(matched))
+		for i, product := range matched {
+			matchedResponses[i] = responses[product.Id]
+		}
+		page.Facets = memorySearchFacets(searchPagination, matchedResponses)
+	}
+
+	// Keyset pagination walks away from the cursor row, backwards for a
+	// previous page, and takes one extra row to tell whether more follow.
+	window := matched
+	if cursor := searchPagination.Cursor; cursor != nil {
+		after, err := cursorSearchKey(cursor)
+		if err != nil {
+			return nil, page, err
+		}
+		window = nil
+		for _, product := range matched {
+			side := compareSearchKeys(searchPagination.SortBy, keyOf(product), after) * direction
+			if (!cursor.Backward && side > 0) || (cursor.Backward && side < 0) {
+				window = append(window, product)
+			}
+		}
+		if cursor.Backward {
+			for i, j := 0, len(window)-1; i < j; i, j = i+1, j-1 {
+				window[i], window[j] = window[j], window[i]
+			}
+		}
+	} else {
+		start, _ := pageBounds(len(window), 0, searchPagination.Offset)
+		window = window[start:]
+	}
+	if limit := searchPagination.Limit; limit > 0 && len(window) > limit+1 {
+		window = window[:limit+1]
+	}
+
+	var products []domain.ProductResponse
+	var sortValues []string
+	for _, product := range window {
+		response := responses[product.Id]
+		response.PurchaseCount = s.soldCount(product.Id)
+		response.Highlight = matches[product.Id].highlight
+		products = append(products, response)
+		sortValues = append(sortValues, searchSortValue(searchPagination.SortBy, product.Price, product.createdAt, matches[product.Id].relevance))
+	}
+
+	products, page.NextCursor, page.PrevCursor = searchPageCursors(searchPagination, products, sortValues)
+	if len(products) == 0 {
+		return nil, page, nil
+	}
+	return products, page, nil
+}
+
+func (s *MemoryStore) GetPopularTags(limit int) ([]domain.TagCount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, product := range s.products {
+		if product.deletedAt != nil {
+			continue
+		}
+		for _, tag := range product.Tags {
+			counts[tag]++
+		}
+	}
+
+	return sortedTagCounts(counts, limit), nil
+}
+
+// sortedTagCounts orders tags by how many products carry them, then by name.
+func sortedTagCounts(counts map[string]int, limit int) []domain.TagCount {
+	var tags []domain.TagCount
+	for tag, count := range counts {
+		tags = append(tags, domain.TagCount{Tag: tag, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags
+}
+
+func (s *MemoryStore) AddBankAccount(bankAccount *domain.BankAccount, userId string) error {
+	if err := checkBankAccount(bankAccount); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := *bankAccount
+	stored.Id = newMemoryId()
+	stored.UserId = userId
+	s.bankAccounts[stored.Id] = &stored
+	return nil
+}
+
+func (s *MemoryStore) GetBankAccounts(userId string) ([]domain.BankAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var bankAccounts []domain.BankAccount
+	for _, acc := range s.bankAccounts {
+		if acc.UserId == userId {
+			bankAccounts = append(bankAccounts, *acc)
+		}
+	}
+	sort.Slice(bankAccounts, func(i, j int) bool { return bankAccounts[i].Id < bankAccounts[j].Id })
+	return bankAccounts, nil
+}
+
+func (s *MemoryStore) UpdateBankAccount(bankAccount *domain.BankAccount, bankAccountId, userId string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.bankAccounts[bankAccountId]
+	if !ok {
+		return 2, nil
+	}
+	if stored.UserId != userId {
+		return 3, nil
+	}
+	if err := checkBankAccount(bankAccount); err != nil {
+		return 0, err
+	}
+
+	stored.BankName = bankAccount.BankName
+	stored.BankAccountName = bankAccount.BankAccountName
+	stored.BankAccountNumber = bankAccount.BankAccountNumber
+	return 1, nil
+}
+
+func (s *MemoryStore) DeleteBankAccount(bankAccountId, userId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.bankAccounts[bankAccountId]
+	if !ok || stored.UserId != userId {
+		return ErrBankAccountNotFound
+	}
+	delete(s.bankAccounts, bankAccountId)
+	return nil
+}
+
+func (s *MemoryStore) CreatePayment(payment *domain.Payment, productId, buyerId string) error {
+	if payment.Quantity < 1 {
+		return ErrInvalidQuantity
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reservation *memoryReservation
+	if payment.ReservationId != "" {
+		var err error
+		if reservation, err = s.claimReservation(payment, productId, buyerId); err != nil {
+			return err
+		}
+	}
+
+	product, ok := s.products[productId]
+	if !ok {
+		return ErrPaymentDetailsInvalid
+	}
+	bankAccount, ok := s.bankAccounts[payment.BankAccountId]
+	if !ok || bankAccount.UserId != product.userId || !product.purchaseable() {
+		return ErrPaymentDetailsInvalid
+	}
+
+	// the claimed reservation holds its stock for this payment only
+	stock, variantStock := s.availableStock(product)
+	if reservation != nil {
+		stock += reservation.quantity
+		variantStock[reservation.variantId] += reservation.quantity
+	}
+
+	var variant *memoryVariant
+	switch {
+	case payment.VariantId != "":
+		if variant = product.variant(payment.VariantId); variant == nil {
+			return ErrVariantNotFound
+		}
+		if variantStock[variant.id] < payment.Quantity {
+			return ErrInsufficientStock
+		}
+	case len(product.variants) > 0:
+		return ErrVariantRequired
+	case stock < payment.Quantity:
+		return ErrInsufficientStock
+	}
+
+	if payment.PaymentProofImageURL != "" && !urlPattern.MatchString(payment.PaymentProofImageURL) {
+		return ErrConstraintViolation
+	}
+
+	payment.Id = newMemoryId()
+	if payment.PaymentProofImageURL == "" {
+		payment.Status = domain.Pending
+	} else {
+		payment.Status = domain.ProofSubmitted
+	}
+
+	now := time.Now()
+	s.payments[payment.Id] = &memoryPayment{PaymentResponse: domain.PaymentResponse{
+		Id:                   payment.Id,
+		ProductId:            productId,
+		BuyerId:              buyerId,
+		SellerId:             product.userId,
+		BankAccountId:        payment.BankAccountId,
+		VariantId:            payment.VariantId,
+		PaymentProofImageURL: payment.PaymentProofImageURL,
+		Quantity:             payment.Quantity,
+		Status:               payment.Status,
+		CreatedAt:            now,
+		UpdatedAt:            now,
+	}}
+	delete(s.reservations, payment.ReservationId)
+	product.Stock -= payment.Quantity
+	if variant != nil {
+		variant.stock -= payment.Quantity
+	}
+	s.recordStockMovement(product, domain.StockMovement{
+		VariantId: payment.VariantId,
+		Delta:     -payment.Quantity,
+		Reason:    domain.Sale,
+		ActorId:   buyerId,
+		PaymentId: payment.Id,
+	})
+	return nil
+}
+
+// claimReservation mirrors ClaimReservationTx, leaving the reservation in
+// place until the payment goes through.
+func (s *MemoryStore) claimReservation(payment *domain.Payment, productId, buyerId string) (*memoryReservation, error) {
+	reservation, ok := s.reservations[payment.ReservationId]
+	if !ok || reservation.productId != productId || reservation.buyerId != buyerId {
+		return nil, ErrReservationNotFound
+	}
+	if !reservation.expiresAt.After(time.Now()) {
+		return nil, ErrReservationExpired
+	}
+
+	if payment.VariantId == "" {
+		payment.VariantId = reservation.variantId
+	}
+	if payment.VariantId != reservation.variantId || payment.Quantity != reservation.quantity {
+		return nil, ErrReservationMismatch
+	}
+	return reservation, nil
+}
+
+func (s *MemoryStore) ReserveStock(reservation *domain.StockReservation, productId, buyerId string) error {
+	if reservation.Quantity < 1 {
+		return ErrInvalidQuantity
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productId]
+	if !ok || !product.purchaseable() {
+		return ErrPaymentDetailsInvalid
+	}
+
+	now := time.Now()
+	for _, held := range s.reservations {
+		if held.productId == productId && held.buyerId == buyerId && held.expiresAt.After(now) {
+			return ErrReservationExists
+		}
+	}
+
+	stock, variantStock := s.availableStock(product)
+	switch {
+	case reservation.VariantId != "":
+		if product.variant(reservation.VariantId) == nil {
+			return ErrVariantNotFound
+		}
+		stock = variantStock[reservation.VariantId]
+	case len(product.variants) > 0:
+		return ErrVariantRequired
+	}
+	if stock < reservation.Quantity {
+		return ErrInsufficientStock
+	}
+
+	reservation.Id = newMemoryId()
+	reservation.ProductId = productId
+	s.reservations[reservation.Id] = &memoryReservation{
+		productId: productId,
+		variantId: reservation.VariantId,
+		buyerId:   buyerId,
+		quantity:  reservation.Quantity,
+		expiresAt: reservation.ExpiresAt,
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReleaseExpiredReservations(expiredBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var released int64
+	for id, reservation := range s.reservations {
+		if reservation.expiresAt.Before(expiredBefore) {
+			delete(s.reservations, id)
+			released++
+		}
+	}
+	return released, nil
+}
+
+func (s *MemoryStore) GetPayment(paymentId, userId string) (domain.PaymentResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	payment, ok := s.payments[paymentId]
+	if !ok {
+		return domain.PaymentResponse{}, ErrPaymentNotFound
+	}
+	if payment.BuyerId != userId && payment.SellerId != userId {
+		return domain.PaymentResponse{}, ErrPaymentForbidden
+	}
+	return payment.PaymentResponse, nil
+}
+
+func (s *MemoryStore) SubmitPaymentProof(paymentId, buyerId, paymentProofImageUrl string) (domain.PaymentResponse, error) {
+	if !urlPattern.MatchString(paymentProofImageUrl) {
+		return domain.PaymentResponse{}, ErrConstraintViolation
+	}
+	return s.transitionPayment(paymentId, buyerId, domain.ProofSubmitted, paymentProofImageUrl)
+}
+
+func (s *MemoryStore) UpdatePaymentStatus(paymentId, userId string, status domain.PaymentStatusEnum) (domain.PaymentResponse, error) {
+	return s.transitionPayment(paymentId, userId, status, "")
+}
+
+func (s *MemoryStore) transitionPayment(paymentId, userId string, status domain.PaymentStatusEnum, paymentProofImageUrl string) (domain.PaymentResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment, ok := s.payments[paymentId]
+	if !ok {
+		return domain.PaymentResponse{}, ErrPaymentNotFound
+	}
+	if err := checkPaymentTransition(&payment.PaymentResponse, userId, status); err != nil {
+		return domain.PaymentResponse{}, err
+	}
+
+	payment.Status = status
+	if paymentProofImageUrl != "" {
+		payment.PaymentProofImageURL = paymentProofImageUrl
+	}
+	payment.UpdatedAt = time.Now()
+
+	if status.ReleasesStock() {
+		if product, ok := s.products[payment.ProductId]; ok {
+			product.Stock += payment.Quantity
+			if variant := product.variant(payment.VariantId); variant != nil {
+				variant.stock += payment.Quantity
+			}
+			s.recordStockMovement(product, domain.StockMovement{
+				VariantId: payment.VariantId,
+				Delta:     payment.Quantity,
+				Reason:    domain.Cancel,
+				ActorId:   userId,
+				PaymentId: payment.Id,
+			})
+		}
+	}
+	return payment.PaymentResponse, nil
+}
+
+func (s *MemoryStore) sellerPaymentResponse(payment *memoryPayment) domain.SellerPaymentResponse {
+	response := domain.SellerPaymentResponse{PaymentResponse: payment.PaymentResponse}
+	if product, ok := s.products[payment.ProductId]; ok {
+		response.ProductName = product.Name
+		response.ProductImageURL = product.ImageURL
+	}
+	response.ProductSoldTotal = s.soldCount(payment.ProductId)
+	if buyer, ok := s.users[payment.BuyerId]; ok {
+		response.BuyerName = buyer.Name
+	}
+	if acc, ok := s.bankAccounts[payment.BankAccountId]; ok {
+		response.BankAccount = domain.BankAccounts{
+			Id:                acc.Id,
+			BankName:          acc.BankName,
+			BankAccountName:   acc.BankAccountName,
+			BankAccountNumber: acc.BankAccountNumber,
+		}
+	}
+	return response
+}
+
+func matchesPaymentPagination(payment *memoryPayment, paymentPagination *util.PaymentPagination) bool {
+	if paymentPagination.ProductId != "" && payment.ProductId != paymentPagination.ProductId {
+		return false
+	}
+	if paymentPagination.Status != "" && payment.Status != paymentPagination.Status {
+		return false
+	}
+	if !paymentPagination.From.IsZero() && payment.CreatedAt.Before(paymentPagination.From) {
+		return false
+	}
+	if !paymentPagination.To.IsZero() && !payment.CreatedAt.Before(paymentPagination.To) {
+		return false
+	}
+	return true
+}
+
+func sortPaymentsNewestFirst(payments []*memoryPayment) {
+	sort.Slice(payments, func(i, j int) bool {
+		if !payments[i].CreatedAt.Equal(payments[j].CreatedAt) {
+			return payments[i].CreatedAt.After(payments[j].CreatedAt)
+		}
+		return payments[i].Id < payments[j].Id
+	})
+}
+
+func (s *MemoryStore) GetSellerPayments(sellerId string, paymentPagination *util.PaymentPagination) ([]domain.SellerPaymentResponse, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*memoryPayment
+	for _, payment := range s.payments {
+		if payment.SellerId == sellerId && matchesPaymentPagination(payment, paymentPagination) {
+			matched = append(matched, payment)
+		}
+	}
+	sortPaymentsNewestFirst(matched)
+
+	start, end := pageBounds(len(matched), paymentPagination.Limit, paymentPagination.Offset)
+	var payments []domain.SellerPaymentResponse
+	for _, payment := range matched[start:end] {
+		payments = append(payments, s.sellerPaymentResponse(payment))
+	}
+	return payments, len(matched), nil
+}
+
+func (s *MemoryStore) GetPurchases(buyerId string, paymentPagination *util.PaymentPagination) ([]domain.PurchaseResponse, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var matched []*memoryPayment
+	for _, payment := range s.payments {
+		if payment.BuyerId == buyerId && matchesPaymentPagination(payment, paymentPagination) {
+			matched = append(matched, payment)
+		}
+	}
+	sortPaymentsNewestFirst(matched)
+
+	start, end := pageBounds(len(matched), paymentPagination.Limit, paymentPagination.Offset)
+	var purchases []domain.PurchaseResponse
+	for _, payment := range matched[start:end] {
+		details := s.sellerPaymentResponse(payment)
+		purchase := domain.PurchaseResponse{
+			PaymentResponse: payment.PaymentResponse,
+			ProductName:     details.ProductName,
+			ProductImageURL: details.ProductImageURL,
+			BankAccount:     details.BankAccount,
+		}
+		if seller, ok := s.users[payment.SellerId]; ok {
+			purchase.SellerName = seller.Name
+		}
+		purchases = append(purchases, purchase)
+	}
+	return purchases, len(matched), nil
+}
+
+func (s *MemoryStore) ClaimIdempotencyKey(userId, key, method, path, requestHash string, expiredBefore, staleBefore time.Time) (domain.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.idempotency[[2]string{userId, key}]; ok && !record.CreatedAt.Before(expiredBefore) {
+		if record.Completed || !record.CreatedAt.Before(staleBefore) {
+			return *record, false, nil
+		}
+	}
+
+	record := &domain.IdempotencyRecord{UserId: userId, Key: key, Method: method, Path: path, RequestHash: requestHash, CreatedAt: time.Now()}
+	s.idempotency[[2]string{userId, key}] = record
+	return *record, true, nil
+}
+
+func (s *MemoryStore) SaveIdempotencyResponse(userId, key string, statusCode int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.idempotency[[2]string{userId, key}]; ok {
+		record.StatusCode = statusCode
+		record.ContentType = contentType
+		record.ResponseBody = body
+		record.Completed = true
+	}
+	return nil
+}
+
+func (s *MemoryStore) ReleaseIdempotencyKey(userId, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if record, ok := s.idempotency[[2]string{userId, key}]; ok && !record.Completed {
+		delete(s.idempotency, [2]string{userId, key})
+	}
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpiredIdempotencyKeys(expiredBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	for id, record := range s.idempotency {
+		if record.CreatedAt.Before(expiredBefore) {
+			delete(s.idempotency, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (s *MemoryStore) cartItem(userId, productId string) *domain.CartItem {
+	for _, item := range s.carts[userId] {
+		if item.ProductId == productId {
+			return item
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) AddCartItem(userId, productId string, quantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.activeProduct(productId)
+	if !ok {
+		return ErrProductNotFound
+	}
+	if len(product.variants) > 0 {
+		return ErrCartVariantsUnsupported
+	}
+	if item := s.cartItem(userId, productId); item != nil {
+		quantity += item.Quantity
+		if quantity < 1 {
+			return ErrConstraintViolation
+		}
+		item.Quantity = quantity
+		return nil
+	}
+	if quantity < 1 {
+		return ErrConstraintViolation
+	}
+	s.carts[userId] = append(s.carts[userId], &domain.CartItem{ProductId: productId, Quantity: quantity})
+	return nil
+}
+
+func (s *MemoryStore) UpdateCartItem(userId, productId string, quantity int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item := s.cartItem(userId, productId)
+	if item == nil {
+		return ErrCartItemNotFound
+	}
+	if quantity < 1 {
+		return ErrConstraintViolation
+	}
+	item.Quantity = quantity
+	return nil
+}
+
+func (s *MemoryStore) RemoveCartItem(userId, productId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.carts[userId]
+	for i, item := range items {
+		if item.ProductId == productId {
+			s.carts[userId] = append(items[:i], items[i+1:]...)
+			return nil
+		}
+	}
+	return ErrCartItemNotFound
+}
+
+func (s *MemoryStore) GetCart(userId string) ([]domain.CartItemResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var items []domain.CartItemResponse
+	for _, item := range s.carts[userId] {
+		product, ok := s.products[item.ProductId]
+		if !ok {
+			continue
+		}
+		response := domain.CartItemResponse{
+			ProductId:      item.ProductId,
+			Name:           product.Name,
+			Price:          product.Price,
+			ImageURL:       product.ImageURL,
+			Stock:          product.Stock,
+			IsPurchaseable: product.purchaseable(),
+			SellerId:       product.userId,
+			Quantity:       item.Quantity,
+		}
+		if seller, ok := s.users[product.userId]; ok {
+			response.SellerName = seller.Name
+		}
+		items = append(items, response)
+	}
+	return items, nil
+}
+
+func (s *MemoryStore) Checkout(userId string, checkout *domain.Checkout) ([]domain.CheckoutOrderResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var items []*domain.CartItem
+	for _, item := range s.carts[userId] {
+		if _, ok := s.products[item.ProductId]; ok {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return nil, ErrCartEmpty
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ProductId < items[j].ProductId })
+
+	sellerPayments := make(map[string]domain.SellerPayment)
+	for _, payment := range checkout.Payments {
+		sellerPayments[payment.SellerId] = payment
+	}
+
+	// validate everything first so a failed checkout leaves no trace
+	for _, item := range items {
+		product := s.products[item.ProductId]
+		sellerPayment, ok := sellerPayments[product.userId]
+		if !ok {
+			return nil, &CartItemError{ProductId: item.ProductId, Err: ErrSellerBankAccountMissing}
+		}
+		bankAccount, ok := s.bankAccounts[sellerPayment.BankAccountId]
+		if !ok || bankAccount.UserId != product.userId || !product.purchaseable() {
+			return nil, &CartItemError{ProductId: item.ProductId, Err: ErrPaymentDetailsInvalid}
+		}
+		if len(product.variants) > 0 {
+			return nil, &CartItemError{ProductId: item.ProductId, Err: ErrVariantRequired}
+		}
+		if stock, _ := s.availableStock(product); stock+s.heldBy(item.ProductId, userId) < item.Quantity {
+			return nil, &CartItemError{ProductId: item.ProductId, Err: ErrInsufficientStock}
+		}
+		if sellerPayment.PaymentProofImageURL != "" && !urlPattern.MatchString(sellerPayment.PaymentProofImageURL) {
+			return nil, ErrConstraintViolation
+		}
+	}
+
+	now := time.Now()
+	orders := make(map[string]*domain.CheckoutOrderResponse)
+	for _, item := range items {
+		product := s.products[item.ProductId]
+		sellerPayment := sellerPayments[product.userId]
+
+		order, ok := orders[product.userId]
+		if !ok {
+			order = &domain.CheckoutOrderResponse{Id: newMemoryId(), SellerId: product.userId, BankAccountId: sellerPayment.BankAccountId}
+			orders[product.userId] = order
+		}
+
+		payment := domain.PaymentResponse{
+			Id:                   newMemoryId(),
+			ProductId:            item.ProductId,
+			BuyerId:              userId,
+			SellerId:             product.userId,
+			BankAccountId:        sellerPayment.BankAccountId,
+			PaymentProofImageURL: sellerPayment.PaymentProofImageURL,
+			Quantity:             item.Quantity,
+			Status:               domain.Pending,
+			CreatedAt:            now,
+			UpdatedAt:            now,
+		}
+		if payment.PaymentProofImageURL != "" {
+			payment.Status = domain.ProofSubmitted
+		}
+		s.payments[payment.Id] = &memoryPayment{PaymentResponse: payment}
+		for id, reservation := range s.reservations {
+			if reservation.productId == item.ProductId && reservation.buyerId == userId {
+				delete(s.reservations, id)
+			}
+		}
+		product.Stock -= item.Quantity
+		s.recordStockMovement(product, domain.StockMovement{Delta: -item.Quantity, Reason: domain.Sale, ActorId: userId, PaymentId: payment.Id})
+
+		order.TotalPrice += product.Price * item.Quantity
+		order.Payments = append(order.Payments, payment)
+	}
+	delete(s.carts, userId)
+
+	return sortedCheckoutOrders(orders), nil
+}
+
+func (s *MemoryStore) CreateRefreshToken(userId, tokenHash string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.refresh[tokenHash]; ok {
+		return ErrDuplicateKey
+	}
+	s.refresh[tokenHash] = &memoryRefreshToken{userId: userId, expiresAt: expiresAt}
+	return nil
+}
+
+func (s *MemoryStore) RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (domain.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.refresh[tokenHash]
+	if !ok {
+		return domain.User{}, ErrRefreshTokenInvalid
+	}
+	if token.revoked {
+		for _, other := range s.refresh {
+			if other.userId == token.userId {
+				other.revoked = true
+			}
+		}
+		return domain.User{}, ErrRefreshTokenInvalid
+	}
+	user, ok := s.users[token.userId]
+	if !ok || token.expiresAt.Before(time.Now()) {
+		return domain.User{}, ErrRefreshTokenInvalid
+	}
+	if user.banned {
+		return domain.User{}, ErrUserBanned
+	}
+
+	token.revoked = true
+	s.refresh[newTokenHash] = &memoryRefreshToken{userId: token.userId, expiresAt: expiresAt}
+	return user.User, nil
+}
+
+func (s *MemoryStore) RevokeRefreshToken(userId, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if token, ok := s.refresh[tokenHash]; ok && token.userId == userId {
+		token.revoked = true
+	}
+	return nil
+}
+
+func (s *MemoryStore) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsAccessTokenRevoked(jti, userId string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.revoked[jti]; ok && jti != "" {
+		return true, nil
+	}
+	user, ok := s.users[userId]
+	return ok && user.banned, nil
+}
+
+func (s *MemoryStore) DeleteExpiredTokens(expiredBefore time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for jti, expiresAt := range s.revoked {
+		if expiresAt.Before(expiredBefore) {
+			delete(s.revoked, jti)
+		}
+	}
+	for hash, token := range s.refresh {
+		if token.expiresAt.Before(expiredBefore) {
+			delete(s.refresh, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) CreateAsset(asset *domain.Asset) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.assets[asset.Key]; ok {
+		*asset = *existing
+		return nil
+	}
+	if _, ok := s.users[asset.UserId]; !ok {
+		return ErrForeignKeyViolation
+	}
+	if asset.ParentId != "" && s.assetById(asset.ParentId) == nil {
+		return ErrForeignKeyViolation
+	}
+	if asset.Size < 0 {
+		return ErrConstraintViolation
+	}
+	if asset.Rendition == "" {
+		asset.Rendition = "original"
+	}
+
+	asset.Id = newMemoryId()
+	asset.CreatedAt = time.Now()
+	stored := *asset
+	s.assets[asset.Key] = &stored
+	return nil
+}
+
+func (s *MemoryStore) assetById(assetId string) *domain.Asset {
+	for _, asset := range s.assets {
+		if asset.Id == assetId {
+			return asset
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetAssetByURL(url string) (domain.Asset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, asset := range s.assets {
+		if asset.URL == url {
+			return *asset, nil
+		}
+	}
+	return domain.Asset{}, ErrAssetNotFound
+}
+
+func (s *MemoryStore) isAssetReferenced(url string) bool {
+	for _, product := range s.products {
+		if product.ImageURL == url {
+			return true
+		}
+		for _, image := range product.images {
+			if image.url == url {
+				return true
+			}
+		}
+	}
+	for _, payment := range s.payments {
+		if payment.PaymentProofImageURL == url {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *MemoryStore) GetOrphanedAssets(createdBefore time.Time, limit int) ([]domain.Asset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var originals []*domain.Asset
+	for _, asset := range s.assets {
+		if asset.ParentId == "" && asset.CreatedAt.Before(createdBefore) {
+			originals = append(originals, asset)
+		}
+	}
+	sort.Slice(originals, func(i, j int) bool { return originals[i].CreatedAt.Before(originals[j].CreatedAt) })
+
+	var orphans []domain.Asset
+	count := 0
+	for _, original := range originals {
+		if count == limit {
+			break
+		}
+
+		group := []domain.Asset{*original}
+		referenced := s.isAssetReferenced(original.URL)
+		for _, asset := range s.assets {
+			if asset.ParentId == original.Id {
+				group = append(group, *asset)
+				referenced = referenced || s.isAssetReferenced(asset.URL)
+			}
+		}
+		if !referenced {
+			orphans = append(orphans, group...)
+			count++
+		}
+	}
+	return orphans, nil
+}
+
+func (s *MemoryStore) DeleteAsset(assetId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, asset := range s.assets {
+		if asset.Id == assetId || asset.ParentId == assetId {
+			delete(s.assets, key)
+		}
+	}
+	return nil
+}
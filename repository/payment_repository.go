@@ -5,21 +5,138 @@ import (
 	"shopifyx/domain"
 )
 
-func CreatePayment(tx *sql.Tx, payment *domain.Payment, productId, buyerId, sellerId string) error {
+type PaymentRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentRepository(db *sql.DB) *PaymentRepository {
+	return &PaymentRepository{db: db}
+}
+
+// CreatePayment reserves the purchased quantity and records the payment in one
+// transaction. The stock is decremented with a conditional UPDATE so that
+// concurrent buyers can never take the stock below zero. A payment made with a
+// reservation claims it first, so the stock it held counts for this buyer.
+func (r *PaymentRepository) CreatePayment(payment *domain.Payment, productId, buyerId string) error {
+	if payment.Quantity < 1 {
+		return ErrInvalidQuantity
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if payment.ReservationId != "" {
+		if err := ClaimReservationTx(tx, payment, productId, buyerId); err != nil {
+			return err
+		}
+	}
+
+	sellerId, err := DecrementProductStockTx(tx, payment.BankAccountId, productId, payment.VariantId, payment.Quantity)
+	if err == sql.ErrNoRows {
+		// nothing was updated, find out whether the details, the variant or the stock were the problem
+		isPurchaseable, _, _, checkErr := CheckStockProductAndBankAccountValid(tx, payment.BankAccountId, productId)
+		if checkErr != nil || !isPurchaseable {
+			return ErrPaymentDetailsInvalid
+		}
+		return CheckVariantPurchaseTx(tx, productId, payment.VariantId, payment.Quantity)
+	}
+	if err != nil {
+		if IdNotFound(err) {
+			return ErrPaymentDetailsInvalid
+		}
+		return err
+	}
+
+	if payment.PaymentProofImageURL == "" {
+		payment.Status = domain.Pending
+	} else {
+		payment.Status = domain.ProofSubmitted
+	}
+
+	if err := InsertPaymentTx(tx, payment, productId, buyerId, sellerId); err != nil {
+		return err
+	}
+
+	err = InsertStockMovementTx(tx, &domain.StockMovement{
+		ProductId: productId,
+		VariantId: payment.VariantId,
+		Delta:     -payment.Quantity,
+		Reason:    domain.Sale,
+		ActorId:   buyerId,
+		PaymentId: payment.Id,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DecrementProductStockTx takes quantity out of a purchaseable product whose
+// seller owns bankAccountId, and out of the chosen variant when the product
+// has variants, returning the seller id. Stock reserved by other buyers is not
+// available. It returns sql.ErrNoRows when the product cannot be bought, the
+// variant does not match or the stock is too low.
+func DecrementProductStockTx(tx *sql.Tx, bankAccountId, productId, variantId string, quantity int) (string, error) {
+	if err := lockProductTx(tx, productId); err != nil {
+		return "", err
+	}
+
+	query := `
+	UPDATE products p
+	SET stock = p.stock - $1
+	WHERE p.id = $2
+	AND p.stock - ` + reservedProductStock + ` >= $1
+	AND p.is_purchaseable
+	AND p.deleted_at IS NULL
+	AND EXISTS (
+		SELECT 1 FROM bank_accounts ba
+		WHERE ba.id = $3 AND ba.user_id = p.user_id
+	)`
+	args := []interface{}{quantity, productId, bankAccountId}
+	if variantId == "" {
+		query += ` AND NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)`
+	} else {
+		query += ` AND EXISTS (SELECT 1 FROM product_variants v WHERE v.id = $4 AND v.product_id = p.id)`
+		args = append(args, variantId)
+	}
+	query += ` RETURNING p.user_id`
+
+	var sellerId string
+	err := tx.QueryRow(query, args...).Scan(&sellerId)
+	if err != nil {
+		return "", err
+	}
+
+	if variantId != "" {
+		if err := DecrementVariantStockTx(tx, productId, variantId, quantity); err != nil {
+			return "", err
+		}
+	}
+	return sellerId, nil
+}
+
+func InsertPaymentTx(tx *sql.Tx, payment *domain.Payment, productId, buyerId, sellerId string) error {
 
 	query := `
-	INSERT INTO payments 
-	(bank_account_id, payment_proof_image_url, buyer_id, product_id, quantity) 
-	VALUES ($1, $2, $3, $4, $5)`
+	INSERT INTO payments
+	(bank_account_id, payment_proof_image_url, buyer_id, product_id, quantity, status, variant_id)
+	VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, NULLIF($7, '')::UUID)
+	RETURNING id`
 
-	_, err := tx.Exec(
+	err := tx.QueryRow(
 		query,
 		payment.BankAccountId,
 		payment.PaymentProofImageURL,
 		buyerId,
 		productId,
 		payment.Quantity,
-	)
+		payment.Status,
+		payment.VariantId,
+	).Scan(&payment.Id)
 	if err != nil {
 		return err
 	}
@@ -29,8 +146,8 @@ func CreatePayment(tx *sql.Tx, payment *domain.Payment, productId, buyerId, sell
 
 func CheckStockProductAndBankAccountValid(tx *sql.Tx, bankAccountId, productId string) (bool, int, string, error) {
 	query := `
-	SELECT is_purchaseable, stock, seller_id 
-	FROM seller_bank_account 
+	SELECT is_purchaseable, stock, seller_id
+	FROM seller_bank_account
 	WHERE bank_account_id = $1
 	AND product_id = $2;`
 
@@ -48,3 +165,133 @@ func CheckStockProductAndBankAccountValid(tx *sql.Tx, bankAccountId, productId s
 
 	return isPurchaseable, stock, sellerId, err
 }
+
+func (r *PaymentRepository) GetPayment(paymentId, userId string) (domain.PaymentResponse, error) {
+	query := `
+	SELECT py.id, py.product_id, py.buyer_id, p.user_id, py.bank_account_id, COALESCE(py.variant_id::TEXT, ''),
+		COALESCE(py.payment_proof_image_url, ''), py.quantity, py.status, py.created_at, py.updated_at
+	FROM payments py
+	JOIN products p ON p.id = py.product_id
+	WHERE py.id = $1`
+
+	payment, err := scanPayment(r.db.QueryRow(query, paymentId))
+	if err != nil {
+		if err == sql.ErrNoRows || IdNotFound(err) {
+			return payment, ErrPaymentNotFound
+		}
+		return payment, err
+	}
+
+	if payment.BuyerId != userId && payment.SellerId != userId {
+		return domain.PaymentResponse{}, ErrPaymentForbidden
+	}
+	return payment, nil
+}
+
+func (r *PaymentRepository) SubmitPaymentProof(paymentId, buyerId, paymentProofImageUrl string) (domain.PaymentResponse, error) {
+	return r.transitionPayment(paymentId, buyerId, domain.ProofSubmitted, paymentProofImageUrl)
+}
+
+func (r *PaymentRepository) UpdatePaymentStatus(paymentId, userId string, status domain.PaymentStatusEnum) (domain.PaymentResponse, error) {
+	return r.transitionPayment(paymentId, userId, status, "")
+}
+
+func (r *PaymentRepository) transitionPayment(paymentId, userId string, status domain.PaymentStatusEnum, paymentProofImageUrl string) (domain.PaymentResponse, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return domain.PaymentResponse{}, err
+	}
+	defer tx.Rollback()
+
+	payment, err := GetPaymentForUpdateTx(tx, paymentId)
+	if err != nil {
+		if err == sql.ErrNoRows || IdNotFound(err) {
+			return payment, ErrPaymentNotFound
+		}
+		return payment, err
+	}
+
+	if err := checkPaymentTransition(&payment, userId, status); err != nil {
+		return domain.PaymentResponse{}, err
+	}
+
+	query := `
+	UPDATE payments
+	SET status = $1, payment_proof_image_url = COALESCE(NULLIF($2, ''), payment_proof_image_url), updated_at = NOW()
+	WHERE id = $3
+	RETURNING COALESCE(payment_proof_image_url, ''), updated_at`
+
+	err = tx.QueryRow(query, status, paymentProofImageUrl, paymentId).Scan(&payment.PaymentProofImageURL, &payment.UpdatedAt)
+	if err != nil {
+		return domain.PaymentResponse{}, err
+	}
+	payment.Status = status
+
+	if status.ReleasesStock() {
+		if err := RestoreProductStockTx(tx, payment.ProductId, payment.VariantId, payment.Quantity); err != nil {
+			return domain.PaymentResponse{}, err
+		}
+
+		err := InsertStockMovementTx(tx, &domain.StockMovement{
+			ProductId: payment.ProductId,
+			VariantId: payment.VariantId,
+			Delta:     payment.Quantity,
+			Reason:    domain.Cancel,
+			ActorId:   userId,
+			PaymentId: payment.Id,
+		})
+		if err != nil {
+			return domain.PaymentResponse{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.PaymentResponse{}, err
+	}
+	return payment, nil
+}
+
+func checkPaymentTransition(payment *domain.PaymentResponse, userId string, status domain.PaymentStatusEnum) error {
+	if payment.BuyerId != userId && payment.SellerId != userId {
+		return ErrPaymentForbidden
+	}
+
+	actor, ok := domain.PaymentTransitionActor(payment.Status, status)
+	if !ok {
+		return ErrInvalidStatusTransition
+	}
+	if (actor == domain.Buyer && payment.BuyerId != userId) || (actor == domain.Seller && payment.SellerId != userId) {
+		return ErrPaymentForbidden
+	}
+	return nil
+}
+
+func GetPaymentForUpdateTx(tx *sql.Tx, paymentId string) (domain.PaymentResponse, error) {
+	query := `
+	SELECT py.id, py.product_id, py.buyer_id, p.user_id, py.bank_account_id, COALESCE(py.variant_id::TEXT, ''),
+		COALESCE(py.payment_proof_image_url, ''), py.quantity, py.status, py.created_at, py.updated_at
+	FROM payments py
+	JOIN products p ON p.id = py.product_id
+	WHERE py.id = $1
+	FOR UPDATE OF py`
+
+	return scanPayment(tx.QueryRow(query, paymentId))
+}
+
+func scanPayment(row *sql.Row) (domain.PaymentResponse, error) {
+	var payment domain.PaymentResponse
+	err := row.Scan(
+		&payment.Id,
+		&payment.ProductId,
+		&payment.BuyerId,
+		&payment.SellerId,
+		&payment.BankAccountId,
+		&payment.VariantId,
+		&payment.PaymentProofImageURL,
+		&payment.Quantity,
+		&payment.Status,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+	)
+	return payment, err
+}
@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"shopifyx/domain"
+	"shopifyx/util"
+)
+
+// purchaseStores are the stores a purchase touches, implemented by both the
+// MemoryStore and the Postgres repositories.
+type purchaseStores struct {
+	users        UserStore
+	products     ProductStore
+	bankAccounts BankAccountStore
+	payments     PaymentStore
+}
+
+// testPurchaseStores always runs against the MemoryStore, and against
+// Postgres too when TEST_DATABASE_URL points at a migrated database.
+func testPurchaseStores(t *testing.T) map[string]purchaseStores {
+	t.Setenv("BCRYPT_SALT", "4")
+
+	memory := NewMemoryStore()
+	stores := map[string]purchaseStores{
+		"memory": {users: memory, products: memory, bankAccounts: memory, payments: memory},
+	}
+
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		db, err := sql.Open("postgres", url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		stores["postgres"] = purchaseStores{
+			users:        NewUserRepository(db),
+			products:     NewProductRepository(db),
+			bankAccounts: NewBankAccountRepository(db),
+			payments:     NewPaymentRepository(db),
+		}
+	}
+	return stores
+}
+
+// newPurchasableProduct registers a seller with a bank account and a product
+// of the given stock, returning the product and bank account ids.
+func newPurchasableProduct(t *testing.T, stores purchaseStores, stock int) (string, string) {
+	t.Helper()
+
+	suffix := fmt.Sprint(time.Now().UnixNano() % 1e9)
+	seller, err := stores.users.RegisterUser("s"+suffix, "seller "+suffix, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = stores.bankAccounts.AddBankAccount(&domain.BankAccount{BankName: "bank test", BankAccountName: "seller test", BankAccountNumber: "1234567890"}, seller.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bankAccounts, err := stores.bankAccounts.GetBankAccounts(seller.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	product := &domain.Product{
+		Name:           "contended " + suffix,
+		Price:          1000,
+		ImageURL:       "https://images.example.com/contended.jpg",
+		Stock:          stock,
+		Condition:      domain.New,
+		Tags:           []string{"test"},
+		IsPurchaseable: true,
+	}
+	if err := stores.products.CreateProduct(product, seller.Id); err != nil {
+		t.Fatal(err)
+	}
+	products, _, err := stores.products.SearchProduct(&util.SearchPagination{UserOnly: true, Limit: 1}, seller.Id)
+	if err != nil || len(products) != 1 {
+		t.Fatalf("created product not found: %v", err)
+	}
+	return products[0].Id, bankAccounts[0].Id
+}
+
+func TestCreatePaymentRejectsInvalidQuantity(t *testing.T) {
+	for name, stores := range testPurchaseStores(t) {
+		t.Run(name, func(t *testing.T) {
+			productId, bankAccountId := newPurchasableProduct(t, stores, 1)
+			err := stores.payments.CreatePayment(&domain.Payment{BankAccountId: bankAccountId, Quantity: 0}, productId, "buyer")
+			if err != ErrInvalidQuantity {
+				t.Fatalf("got %v, want ErrInvalidQuantity", err)
+			}
+		})
+	}
+}
+
+func TestCreatePaymentDoesNotOversell(t *testing.T) {
+	const stock, buyers = 5, 300
+
+	for name, stores := range testPurchaseStores(t) {
+		t.Run(name, func(t *testing.T) {
+			productId, bankAccountId := newPurchasableProduct(t, stores, stock)
+			buyer, err := stores.users.RegisterUser(fmt.Sprint("b", time.Now().UnixNano()%1e9), "buyer test", "password")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var wg sync.WaitGroup
+			errs := make(chan error, buyers)
+			for i := 0; i < buyers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- stores.payments.CreatePayment(&domain.Payment{BankAccountId: bankAccountId, Quantity: 1}, productId, buyer.Id)
+				}()
+			}
+			wg.Wait()
+			close(errs)
+
+			var succeeded, soldOut int
+			for err := range errs {
+				switch err {
+				case nil:
+					succeeded++
+				case ErrInsufficientStock:
+					soldOut++
+				default:
+					t.Errorf("unexpected error: %v", err)
+				}
+			}
+			if succeeded != stock || soldOut != buyers-stock {
+				t.Fatalf("got %d purchases and %d sold out, want %d and %d", succeeded, soldOut, stock, buyers-stock)
+			}
+
+			product, _, err := stores.products.GetProductById(productId, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if product.Stock != 0 {
+				t.Fatalf("got stock %d after selling out, want 0", product.Stock)
+			}
+
+			// the ledger records the stock after every sale, none may go below zero
+			movements, _, err := stores.products.GetStockHistory(productId, &util.StockHistoryPagination{Reason: domain.Sale, Limit: buyers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(movements) != stock {
+				t.Fatalf("got %d sales in the stock ledger, want %d", len(movements), stock)
+			}
+			for _, movement := range movements {
+				if movement.StockAfter < 0 {
+					t.Fatalf("stock went down to %d", movement.StockAfter)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"fmt"
+	"shopifyx/domain"
+	"shopifyx/util"
+)
+
+func (r *PaymentRepository) GetSellerPayments(sellerId string, paymentPagination *util.PaymentPagination) ([]domain.SellerPaymentResponse, int, error) {
+
+	// seller_bank_account hanya berisi pasangan produk dan rekening milik seller yang sama
+	query := `
+		SELECT py.id, py.product_id, py.buyer_id, sba.seller_id, py.bank_account_id, COALESCE(py.variant_id::TEXT, ''),
+			COALESCE(py.payment_proof_image_url, ''), py.quantity, py.status, py.created_at, py.updated_at,
+			p.name, p.image_url, COALESCE(tps.total_sold, 0), u.name,
+			ba.bank_name, ba.bank_account_name, ba.bank_account_number
+		FROM payments py
+		JOIN seller_bank_account sba ON sba.product_id = py.product_id AND sba.bank_account_id = py.bank_account_id
+		JOIN products p ON p.id = py.product_id
+		JOIN users u ON u.id = py.buyer_id
+		JOIN bank_accounts ba ON ba.id = py.bank_account_id
+		LEFT JOIN total_product_sold tps ON tps.product_id = py.product_id
+		WHERE sba.seller_id = $1
+	`
+	query, args, paramIndex := appendPaymentFilters(query, []interface{}{sellerId}, paymentPagination)
+
+	totalQuery := "SELECT COUNT(*) FROM (" + query + ") AS total"
+	var total int
+	err := r.db.QueryRow(totalQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query += fmt.Sprintf(" ORDER BY py.created_at DESC, py.id LIMIT $%d OFFSET $%d", paramIndex, paramIndex+1)
+	args = append(args, paymentPagination.Limit, paymentPagination.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var payments []domain.SellerPaymentResponse
+	for rows.Next() {
+		var payment domain.SellerPaymentResponse
+		err := rows.Scan(
+			&payment.Id,
+			&payment.ProductId,
+			&payment.BuyerId,
+			&payment.SellerId,
+			&payment.BankAccountId,
+			&payment.VariantId,
+			&payment.PaymentProofImageURL,
+			&payment.Quantity,
+			&payment.Status,
+			&payment.CreatedAt,
+			&payment.UpdatedAt,
+			&payment.ProductName,
+			&payment.ProductImageURL,
+			&payment.ProductSoldTotal,
+			&payment.BuyerName,
+			&payment.BankAccount.BankName,
+			&payment.BankAccount.BankAccountName,
+			&payment.BankAccount.BankAccountNumber,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		payment.BankAccount.Id = payment.BankAccountId
+		payments = append(payments, payment)
+	}
+
+	return payments, total, nil
+}
+
+func (r *PaymentRepository) GetPurchases(buyerId string, paymentPagination *util.PaymentPagination) ([]domain.PurchaseResponse, int, error) {
+
+	query := `
+		SELECT py.id, py.product_id, py.buyer_id, p.user_id, py.bank_account_id, COALESCE(py.variant_id::TEXT, ''),
+			COALESCE(py.payment_proof_image_url, ''), py.quantity, py.status, py.created_at, py.updated_at,
+			p.name, p.image_url, u.name,
+			ba.bank_name, ba.bank_account_name, ba.bank_account_number
+		FROM payments py
+		JOIN products p ON p.id = py.product_id
+		JOIN users u ON u.id = p.user_id
+		JOIN bank_accounts ba ON ba.id = py.bank_account_id
+		WHERE py.buyer_id = $1
+	`
+	query, args, paramIndex := appendPaymentFilters(query, []interface{}{buyerId}, paymentPagination)
+
+	totalQuery := "SELECT COUNT(*) FROM (" + query + ") AS total"
+	var total int
+	err := r.db.QueryRow(totalQuery, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	query += fmt.Sprintf(" ORDER BY py.created_at DESC, py.id LIMIT $%d OFFSET $%d", paramIndex, paramIndex+1)
+	args = append(args, paymentPagination.Limit, paymentPagination.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var purchases []domain.PurchaseResponse
+	for rows.Next() {
+		var purchase domain.PurchaseResponse
+		err := rows.Scan(
+			&purchase.Id,
+			&purchase.ProductId,
+			&purchase.BuyerId,
+			&purchase.SellerId,
+			&purchase.BankAccountId,
+			&purchase.VariantId,
+			&purchase.PaymentProofImageURL,
+			&purchase.Quantity,
+			&purchase.Status,
+			&purchase.CreatedAt,
+			&purchase.UpdatedAt,
+			&purchase.ProductName,
+			&purchase.ProductImageURL,
+			&purchase.SellerName,
+			&purchase.BankAccount.BankName,
+			&purchase.BankAccount.BankAccountName,
+			&purchase.BankAccount.BankAccountNumber,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		purchase.BankAccount.Id = purchase.BankAccountId
+		purchases = append(purchases, purchase)
+	}
+
+	return purchases, total, nil
+}
+
+// appendPaymentFilters adds the optional filters of paymentPagination to a
+// payments query aliased as py, numbering parameters after the given args.
+func appendPaymentFilters(query string, args []interface{}, paymentPagination *util.PaymentPagination) (string, []interface{}, int) {
+	paramIndex := len(args) + 1
+
+	if paymentPagination.ProductId != "" {
+		query += fmt.Sprintf(" AND py.product_id = $%d", paramIndex)
+		args = append(args, paymentPagination.ProductId)
+		paramIndex++
+	}
+
+	if paymentPagination.Status != "" {
+		query += fmt.Sprintf(" AND py.status = $%d", paramIndex)
+		args = append(args, paymentPagination.Status)
+		paramIndex++
+	}
+
+	if !paymentPagination.From.IsZero() {
+		query += fmt.Sprintf(" AND py.created_at >= $%d", paramIndex)
+		args = append(args, paymentPagination.From)
+		paramIndex++
+	}
+	if !paymentPagination.To.IsZero() {
+		query += fmt.Sprintf(" AND py.created_at < $%d", paramIndex)
+		args = append(args, paymentPagination.To)
+		paramIndex++
+	}
+
+	return query, args, paramIndex
+}
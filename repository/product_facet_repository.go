@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"fmt"
+	"shopifyx/domain"
+	"shopifyx/util"
+
+	"github.com/lib/pq"
+)
+
+// searchFacets aggregates the rows of filteredQuery, the search query before
+// ordering and paging, once per requested facet.
+func (r *ProductRepository) searchFacets(searchPagination *util.SearchPagination, filteredQuery string, args []interface{}) (*util.SearchFacets, error) {
+	facets := &util.SearchFacets{}
+	from := "(" + filteredQuery + ") AS f"
+
+	if searchPagination.HasFacet(util.FacetCondition) {
+		counts := make(map[domain.ConditionEnum]int)
+		err := r.scanFacetRows("SELECT f.condition, COUNT(*) FROM "+from+" GROUP BY f.condition", args, func(scan func(...interface{}) error) error {
+			var condition domain.ConditionEnum
+			var count int
+			if err := scan(&condition, &count); err != nil {
+				return err
+			}
+			counts[condition] = count
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		facets.Condition = util.ConditionFacet(counts)
+	}
+
+	if searchPagination.HasFacet(util.FacetPrice) {
+		// width_bucket mengembalikan 0 untuk harga di bawah batas pertama
+		query := fmt.Sprintf("SELECT width_bucket(f.price, $%d::INT[]), COUNT(*) FROM %s GROUP BY 1", len(args)+1, from)
+		counts := make(map[int]int)
+		err := r.scanFacetRows(query, append(args[:len(args):len(args)], pq.Array(util.PriceFacetBounds)), func(scan func(...interface{}) error) error {
+			var bucket, count int
+			if err := scan(&bucket, &count); err != nil {
+				return err
+			}
+			counts[bucket] = count
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		facets.Price = util.PriceFacet(counts)
+	}
+
+	if searchPagination.HasFacet(util.FacetTags) {
+		query := fmt.Sprintf("SELECT tag, COUNT(*) AS total FROM %s, unnest(f.tags) AS tag GROUP BY tag ORDER BY total DESC, tag LIMIT %d", from, util.MaxTagFacets)
+		facets.Tags = []domain.TagCount{}
+		err := r.scanFacetRows(query, args, func(scan func(...interface{}) error) error {
+			var tag domain.TagCount
+			if err := scan(&tag.Tag, &tag.Count); err != nil {
+				return err
+			}
+			facets.Tags = append(facets.Tags, tag)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if searchPagination.HasFacet(util.FacetStock) {
+		var available, unavailable int
+		query := "SELECT COUNT(*) FILTER (WHERE f.stock > 0), COUNT(*) FILTER (WHERE f.stock <= 0) FROM " + from
+		if err := r.db.QueryRow(query, args...).Scan(&available, &unavailable); err != nil {
+			return nil, err
+		}
+		facets.Stock = util.StockFacet(available, unavailable)
+	}
+
+	return facets, nil
+}
+
+func (r *ProductRepository) scanFacetRows(query string, args []interface{}, scanRow func(scan func(...interface{}) error) error) error {
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scanRow(rows.Scan); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
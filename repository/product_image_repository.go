@@ -0,0 +1,269 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"shopifyx/domain"
+
+	"github.com/lib/pq"
+)
+
+// productImagesColumn aggregates the gallery of p into a JSON array so a
+// product and its images come back in one query.
+const productImagesColumn = `(
+	SELECT COALESCE(json_agg(json_build_object('id', pi.id, 'url', pi.url, 'position', pi.position) ORDER BY pi.position), '[]')
+	FROM product_images pi
+	WHERE pi.product_id = p.id
+)`
+
+func decodeProductImages(data []byte) ([]domain.ProductImage, error) {
+	var images []domain.ProductImage
+	if err := json.Unmarshal(data, &images); err != nil {
+		return nil, err
+	}
+	for i := range images {
+		images[i].Primary = images[i].Position == 0
+	}
+	return images, nil
+}
+
+// InsertProductImagesTx stores the gallery of a new product, primary first.
+func InsertProductImagesTx(tx *sql.Tx, productId string, urls []string) error {
+	for position, url := range urls {
+		_, err := tx.Exec(
+			`INSERT INTO product_images (product_id, url, position) VALUES ($1, $2, $3)`,
+			productId, url, position,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// galleryURLs puts the primary image first and drops repeated urls.
+func galleryURLs(product *domain.Product) []string {
+	urls := []string{product.ImageURL}
+	seen := map[string]bool{product.ImageURL: true}
+	for _, url := range product.Images {
+		if !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// getProductImages loads the galleries of the given products, keyed by
+// product id.
+func (r *ProductRepository) getProductImages(productIds ...string) (map[string][]domain.ProductImage, error) {
+	rows, err := r.db.Query(`
+	SELECT product_id, id, url, position
+	FROM product_images
+	WHERE product_id = ANY($1)
+	ORDER BY product_id, position`, pq.Array(productIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := make(map[string][]domain.ProductImage)
+	for rows.Next() {
+		var productId string
+		var image domain.ProductImage
+		if err := rows.Scan(&productId, &image.Id, &image.URL, &image.Position); err != nil {
+			return nil, err
+		}
+		image.Primary = image.Position == 0
+		images[productId] = append(images[productId], image)
+	}
+	return images, rows.Err()
+}
+
+// lockProductImagesTx locks the product so concurrent gallery changes queue
+// up, and returns its images in order.
+func lockProductImagesTx(tx *sql.Tx, productId string) ([]domain.ProductImage, error) {
+	var id string
+	err := tx.QueryRow(`SELECT id FROM products WHERE id = $1 AND deleted_at IS NULL FOR UPDATE`, productId).Scan(&id)
+	if err == sql.ErrNoRows || IdNotFound(err) {
+		return nil, ErrProductNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT id, url, position FROM product_images WHERE product_id = $1 ORDER BY position`, productId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var images []domain.ProductImage
+	for rows.Next() {
+		var image domain.ProductImage
+		if err := rows.Scan(&image.Id, &image.URL, &image.Position); err != nil {
+			return nil, err
+		}
+		image.Primary = image.Position == 0
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+func imagePosition(images []domain.ProductImage, imageId string) int {
+	for _, image := range images {
+		if image.Id == imageId {
+			return image.Position
+		}
+	}
+	return -1
+}
+
+// finishGalleryChangeTx mirrors the primary image into products.image_url,
+// commits and returns the new gallery.
+func finishGalleryChangeTx(tx *sql.Tx, productId string) ([]domain.ProductImage, error) {
+	_, err := tx.Exec(`
+	UPDATE products
+	SET image_url = (SELECT url FROM product_images WHERE product_id = $1 AND position = 0)
+	WHERE id = $1`, productId)
+	if err != nil {
+		return nil, err
+	}
+
+	images, err := lockProductImagesTx(tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	return images, tx.Commit()
+}
+
+func (r *ProductRepository) AddProductImage(productId, url string) ([]domain.ProductImage, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	images, err := lockProductImagesTx(tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	if len(images) >= domain.MaxProductImages {
+		return nil, ErrTooManyProductImages
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO product_images (product_id, url, position) VALUES ($1, $2, $3)`,
+		productId, url, len(images),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return finishGalleryChangeTx(tx, productId)
+}
+
+// RemoveProductImage closes the gap the image leaves, so removing the primary
+// image promotes the next one.
+func (r *ProductRepository) RemoveProductImage(productId, imageId string) ([]domain.ProductImage, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	images, err := lockProductImagesTx(tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	position := imagePosition(images, imageId)
+	if position < 0 {
+		return nil, ErrProductImageNotFound
+	}
+	if len(images) == 1 {
+		return nil, ErrLastProductImage
+	}
+
+	if _, err := tx.Exec(`DELETE FROM product_images WHERE id = $1`, imageId); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(
+		`UPDATE product_images SET position = position - 1 WHERE product_id = $1 AND position > $2`,
+		productId, position,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return finishGalleryChangeTx(tx, productId)
+}
+
+// ReorderProductImages takes every image id of the product in the new order,
+// the first one becoming the primary image.
+func (r *ProductRepository) ReorderProductImages(productId string, imageIds []string) ([]domain.ProductImage, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	images, err := lockProductImagesTx(tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	if !isImageOrder(images, imageIds) {
+		return nil, ErrInvalidImageOrder
+	}
+
+	_, err = tx.Exec(`
+	UPDATE product_images pi
+	SET position = o.ord - 1
+	FROM unnest($2::UUID[]) WITH ORDINALITY AS o(id, ord)
+	WHERE pi.id = o.id AND pi.product_id = $1`, productId, pq.Array(imageIds))
+	if err != nil {
+		return nil, err
+	}
+	return finishGalleryChangeTx(tx, productId)
+}
+
+// SetPrimaryProductImage moves an image to the front, keeping the order of
+// the others.
+func (r *ProductRepository) SetPrimaryProductImage(productId, imageId string) ([]domain.ProductImage, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	images, err := lockProductImagesTx(tx, productId)
+	if err != nil {
+		return nil, err
+	}
+	position := imagePosition(images, imageId)
+	if position < 0 {
+		return nil, ErrProductImageNotFound
+	}
+
+	_, err = tx.Exec(`
+	UPDATE product_images
+	SET position = CASE WHEN id = $2 THEN 0 WHEN position < $3 THEN position + 1 ELSE position END
+	WHERE product_id = $1`, productId, imageId, position)
+	if err != nil {
+		return nil, err
+	}
+	return finishGalleryChangeTx(tx, productId)
+}
+
+// isImageOrder reports whether imageIds lists every image exactly once.
+func isImageOrder(images []domain.ProductImage, imageIds []string) bool {
+	if len(images) != len(imageIds) {
+		return false
+	}
+	seen := make(map[string]bool, len(imageIds))
+	for _, imageId := range imageIds {
+		if seen[imageId] || imagePosition(images, imageId) < 0 {
+			return false
+		}
+		seen[imageId] = true
+	}
+	return true
+}
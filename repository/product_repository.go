@@ -2,39 +2,75 @@ package repository
 
 import (
 	"database/sql"
+	"time"
 
-	"shopifyx/config"
 	"shopifyx/domain"
 
 	"github.com/lib/pq"
 )
 
-func CreateProduct(product *domain.Product, userId string) error {
-	query := `INSERT INTO products (name, price, image_url, stock, condition, tags, is_purchaseable, user_id) VALUES($1, $2, $3, $4, $5, $6, $7, $8)`
-	_, err := config.GetDB().Exec(
+type ProductRepository struct {
+	db *sql.DB
+}
+
+func NewProductRepository(db *sql.DB) *ProductRepository {
+	return &ProductRepository{db: db}
+}
+
+func (r *ProductRepository) CreateProduct(product *domain.Product, userId string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// a product with variants starts empty, each variant then adds its stock
+	stock := product.Stock
+	if len(product.Variants) > 0 {
+		stock = 0
+	}
+
+	var productId string
+	query := `INSERT INTO products (name, price, image_url, stock, condition, tags, is_purchaseable, user_id) VALUES($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`
+	err = tx.QueryRow(
 		query,
 		product.Name,
 		product.Price,
 		product.ImageURL,
-		product.Stock,
+		stock,
 		product.Condition,
 		pq.Array(product.Tags),
 		product.IsPurchaseable, userId,
-	)
+	).Scan(&productId)
+	if err != nil {
+		return err
+	}
 
+	err = InsertStockMovementTx(tx, &domain.StockMovement{ProductId: productId, Delta: stock, Reason: domain.Restock, ActorId: userId})
 	if err != nil {
 		return err
 	}
-	return nil
+
+	if err := InsertProductVariantsTx(tx, productId, userId, product.Variants); err != nil {
+		return err
+	}
+
+	if err := InsertProductImagesTx(tx, productId, galleryURLs(product)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func GetProductById(productId string) (domain.ProductResponse, domain.SellerResponse, error) {
+// GetProductById leaves out archived products unless withArchived is set.
+func (r *ProductRepository) GetProductById(productId string, withArchived bool) (domain.ProductResponse, domain.SellerResponse, error) {
 	var product domain.ProductResponse
 	var seller domain.SellerResponse
 	var arrBankAccountId []sql.NullString
 	var arrBankNames []sql.NullString
 	var arrBankAccountNames []sql.NullString
 	var arrBankAccountNumbers []sql.NullString
+	var images []byte
 
 	query := `
 	SELECT 
@@ -42,10 +78,11 @@ func GetProductById(productId string) (domain.ProductResponse, domain.SellerResp
 		p.name,
 		p.price,
 		p.image_url,
-		p.stock,
+		GREATEST(p.stock - ` + reservedProductStock + `, 0) AS stock,
 		p.condition,
 		p.tags,
 		p.is_purchaseable,
+		p.deleted_at,
 		COALESCE(tps.total_sold, 0) AS total_product_sold,
 		u.name AS seller_name,
 		COALESCE(sls.total_sold, 0) AS total_seller_sold,
@@ -68,7 +105,8 @@ func GetProductById(productId string) (domain.ProductResponse, domain.SellerResp
 			SELECT ARRAY_AGG(ba.bank_account_number) 
 			FROM bank_accounts ba 
 			WHERE ba.user_id = u.id
-		) AS bank_account_numbers
+		) AS bank_account_numbers,
+		` + productImagesColumn + ` AS images
 	FROM 
 		products p
 	LEFT JOIN 
@@ -79,10 +117,14 @@ func GetProductById(productId string) (domain.ProductResponse, domain.SellerResp
 		total_users_sold sls ON u.id = sls.user_id
 	WHERE 
 		p.id = $1
+		AND ($2 OR p.deleted_at IS NULL)
 	GROUP BY 
 		p.id, p.name, u.name, u.id, sls.total_sold, tps.total_sold;`
 
-	rows, err := config.GetDB().Query(query, productId)
+	rows, err := r.db.Query(query, productId, withArchived)
+	if IdNotFound(err) {
+		return domain.ProductResponse{}, domain.SellerResponse{}, ErrProductNotFound
+	}
 	if err != nil {
 		return domain.ProductResponse{}, domain.SellerResponse{}, err
 	}
@@ -98,6 +140,7 @@ func GetProductById(productId string) (domain.ProductResponse, domain.SellerResp
 			&product.Condition,
 			pq.Array(&product.Tags),
 			&product.IsPurchaseable,
+			&product.DeletedAt,
 			&product.PurchaseCount,
 			&seller.Name,
 			&seller.ProductSoldTotal,
@@ -105,10 +148,22 @@ func GetProductById(productId string) (domain.ProductResponse, domain.SellerResp
 			pq.Array(&arrBankNames),
 			pq.Array(&arrBankAccountNames),
 			pq.Array(&arrBankAccountNumbers),
+			&images,
 		)
 		if err != nil {
 			return domain.ProductResponse{}, domain.SellerResponse{}, err
 		}
+
+		product.Images, err = decodeProductImages(images)
+		if err != nil {
+			return domain.ProductResponse{}, domain.SellerResponse{}, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return domain.ProductResponse{}, domain.SellerResponse{}, err
+	}
+	if product.Id == "" {
+		return domain.ProductResponse{}, domain.SellerResponse{}, ErrProductNotFound
 	}
 
 	var bankAccounts []domain.BankAccounts
@@ -123,27 +178,38 @@ func GetProductById(productId string) (domain.ProductResponse, domain.SellerResp
 	}
 
 	seller.BankAccounts = bankAccounts
+
+	variants, err := r.getProductVariants(product.Id)
+	if err != nil {
+		return domain.ProductResponse{}, domain.SellerResponse{}, err
+	}
+	product.Variants = variants[product.Id]
 	return product, seller, nil
 }
 
-func UpdateProduct(product *domain.Product, productId, userId string) (int, error) {
+func (r *ProductRepository) UpdateProduct(product *domain.Product, productId, userId string) (int, error) {
 	query := `
 		WITH updated AS (
 			UPDATE products
 			SET name = $1, price = $2, image_url = $3, condition = $4, tags = $5, is_purchaseable = $6
-			WHERE id = $7 AND user_id = $8
+			WHERE id = $7 AND user_id = $8 AND deleted_at IS NULL
 			RETURNING *
+		), primary_image AS (
+			-- imageUrl is the primary image of the gallery
+			UPDATE product_images
+			SET url = $3
+			WHERE product_id = $7 AND position = 0 AND EXISTS (SELECT 1 FROM updated)
 		)
 		SELECT 
 			CASE 
 				WHEN EXISTS (SELECT 1 FROM updated) THEN 1 
-				WHEN NOT EXISTS (SELECT 1 FROM products WHERE id = $7) THEN 2 
+				WHEN NOT EXISTS (SELECT 1 FROM products WHERE id = $7 AND deleted_at IS NULL) THEN 2 
 				ELSE 3 
 			END AS result_code;
 	`
 
 	var resultCode int
-	err := config.GetDB().QueryRow(query,
+	err := r.db.QueryRow(query,
 		product.Name, product.Price, product.ImageURL, product.Condition, pq.Array(product.Tags), product.IsPurchaseable, productId, userId,
 	).Scan(&resultCode)
 
@@ -153,22 +219,53 @@ func UpdateProduct(product *domain.Product, productId, userId string) (int, erro
 	return resultCode, err
 }
 
-func DeleteProductById(productId, userId string) (int, error) {
+// DeleteProductById archives the product, payments keep referencing it and
+// it can be brought back with RestoreProduct.
+func (r *ProductRepository) DeleteProductById(productId, userId string) (int, error) {
 	query :=
 		`WITH deleted AS (
-		DELETE FROM products 
-		WHERE id = $1 AND user_id = $2
+		UPDATE products 
+		SET deleted_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
 		RETURNING *
 	)
 	SELECT 
 		CASE 
 			WHEN EXISTS (SELECT 1 FROM deleted) THEN 1 
-			WHEN NOT EXISTS (SELECT 1 FROM products WHERE id = $1) THEN 2 
+			WHEN NOT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NULL) THEN 2 
 			ELSE 3 
 		END AS result_code;`
 
 	var resultCode int
-	err := config.GetDB().QueryRow(query, productId, userId).Scan(&resultCode)
+	err := r.db.QueryRow(query, productId, userId).Scan(&resultCode)
+	if err != nil {
+		return 0, err
+	}
+
+	return resultCode, nil
+}
+
+// RestoreProduct brings back a product archived at or after deletedSince.
+// Besides the codes of DeleteProductById it returns 4 when the product was
+// archived too long ago.
+func (r *ProductRepository) RestoreProduct(productId, userId string, deletedSince time.Time) (int, error) {
+	query :=
+		`WITH restored AS (
+		UPDATE products 
+		SET deleted_at = NULL
+		WHERE id = $1 AND user_id = $2 AND deleted_at >= $3
+		RETURNING *
+	)
+	SELECT 
+		CASE 
+			WHEN EXISTS (SELECT 1 FROM restored) THEN 1 
+			WHEN NOT EXISTS (SELECT 1 FROM products WHERE id = $1 AND deleted_at IS NOT NULL) THEN 2 
+			WHEN NOT EXISTS (SELECT 1 FROM products WHERE id = $1 AND user_id = $2) THEN 3 
+			ELSE 4 
+		END AS result_code;`
+
+	var resultCode int
+	err := r.db.QueryRow(query, productId, userId, deletedSince).Scan(&resultCode)
 	if err != nil {
 		return 0, err
 	}
@@ -185,33 +282,97 @@ func GetProductStockTx(tx *sql.Tx, productId string) (int, error) {
 	return stock, nil
 }
 
-func UpdateProductStockTx(tx *sql.Tx, productId string, newStock int) error {
+// RestoreProductStockTx gives quantity back to a product and, when the
+// purchase was of a variant, to that variant.
+func RestoreProductStockTx(tx *sql.Tx, productId, variantId string, quantity int) error {
 	_, err := tx.Exec(
-		`UPDATE products SET stock = $1 WHERE id = $2`,
-		newStock, productId,
+		`UPDATE products SET stock = stock + $1 WHERE id = $2`,
+		quantity, productId,
 	)
+	if err != nil || variantId == "" {
+		return err
+	}
+
+	_, err = tx.Exec(
+		`UPDATE product_variants SET stock = stock + $1 WHERE id = $2`,
+		quantity, variantId,
+	)
+	return err
+}
+
+func (r *ProductRepository) UnlistProduct(productId string) error {
+	result, err := r.db.Exec(`UPDATE products SET is_purchaseable = false WHERE id = $1`, productId)
+	if IdNotFound(err) {
+		return ErrProductNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if rowsAffected == 0 {
+		return ErrProductNotFound
+	}
 	return nil
 }
 
-func GetUserIdFromProductId(productId string) (string, error) {
+func (r *ProductRepository) GetUserIdFromProductId(productId string) (string, error) {
 	var userId string
-	err := config.GetDB().QueryRow("SELECT user_id FROM products WHERE id = $1", productId).Scan(&userId)
+	err := r.db.QueryRow("SELECT user_id FROM products WHERE id = $1 AND deleted_at IS NULL", productId).Scan(&userId)
 	if err != nil {
 		return "", err
 	}
 	return userId, nil
 }
 
-func UpdateProductStock(productId string, newStock int) error {
-	_, err := config.GetDB().Exec(
-		`UPDATE products SET stock = $1 WHERE id = $2`,
-		newStock, productId,
-	)
+// UpdateProductStock applies a seller's stock update, records it in the
+// ledger and returns the new stock. It refuses products with variants, their
+// stock is set per variant with UpdateVariantStock.
+func (r *ProductRepository) UpdateProductStock(productId, actorId string, update *domain.StockUpdate) (int, error) {
+	tx, err := r.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
-	return nil
+	defer tx.Rollback()
+
+	// the row lock queues this update behind reservations of the product, so
+	// the reserved quantity cannot grow before it commits
+	var stock, reserved int
+	var hasVariants bool
+	err = tx.QueryRow(`
+	SELECT p.stock, `+reservedProductStock+`, EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+	FROM products p
+	WHERE p.id = $1
+	FOR UPDATE`, productId).Scan(&stock, &reserved, &hasVariants)
+	if err == sql.ErrNoRows || IdNotFound(err) {
+		return 0, ErrProductNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if hasVariants {
+		return 0, ErrProductHasVariants
+	}
+
+	delta, reason := update.Movement(stock)
+	if stock+delta < 0 {
+		return 0, ErrStockBelowZero
+	}
+	if stock+delta < reserved {
+		return 0, ErrStockBelowReserved
+	}
+
+	if _, err := tx.Exec(`UPDATE products SET stock = stock + $1 WHERE id = $2`, delta, productId); err != nil {
+		return 0, err
+	}
+
+	err = InsertStockMovementTx(tx, &domain.StockMovement{ProductId: productId, Delta: delta, Reason: reason, ActorId: actorId})
+	if err != nil {
+		return 0, err
+	}
+
+	return stock + delta, tx.Commit()
 }
@@ -0,0 +1,18 @@
+package repository
+
+import "testing"
+
+func TestMissingProductIsNotFound(t *testing.T) {
+	for name, stores := range testPurchaseStores(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, productId := range []string{"6f1c2d3e-4b5a-4c6d-8e7f-9a0b1c2d3e4f", "not-a-uuid"} {
+				if _, _, err := stores.products.GetProductById(productId, true); err != ErrProductNotFound {
+					t.Errorf("GetProductById(%q): got %v, want ErrProductNotFound", productId, err)
+				}
+				if err := stores.products.UnlistProduct(productId); err != ErrProductNotFound {
+					t.Errorf("UnlistProduct(%q): got %v, want ErrProductNotFound", productId, err)
+				}
+			}
+		})
+	}
+}
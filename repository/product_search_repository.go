@@ -2,25 +2,130 @@ package repository
 
 import (
 	"fmt"
-	"shopifyx/config"
+	"html"
 	"shopifyx/domain"
 	"shopifyx/util"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/lib/pq"
 )
 
-func SearchProduct(searchPagination *util.SearchPagination, userId string) ([]domain.ProductResponse, int, error) {
+// searchSortColumn whitelists what sortBy may order by, anything else falls
+// back to price. Relevance is the ranking expression itself so it can also be
+// compared against a cursor.
+func searchSortColumn(sortBy util.SortEnum, relevance string) string {
+	switch sortBy {
+	case util.Date:
+		return "p.created_at"
+	case util.Relevance:
+		return relevance
+	default:
+		return "p.price"
+	}
+}
 
-	query := `
-		SELECT p.id, p.name, p.price, p.image_url, p.stock, p.condition, p.tags, p.is_purchaseable, p.created_at as date,
-		
-		ps.total_sold  --baru
-		FROM products p
+// searchSortValue renders the sort column of a row for a cursor, in a form
+// Postgres parses back into the same value.
+func searchSortValue(sortBy util.SortEnum, price int, createdAt time.Time, relevance float64) string {
+	switch sortBy {
+	case util.Date:
+		return createdAt.Format(time.RFC3339Nano)
+	case util.Relevance:
+		return strconv.FormatFloat(relevance, 'g', -1, 64)
+	default:
+		return strconv.Itoa(price)
+	}
+}
+
+func reverseOrder(order util.OrderEnum) util.OrderEnum {
+	if order == util.Descending {
+		return util.Ascending
+	}
+	return util.Descending
+}
+
+// searchPageCursors drops the extra row fetched to detect another page, puts
+// a backward page back into display order and returns the cursors around it.
+// sortValues holds the sort column of each product.
+func searchPageCursors(searchPagination *util.SearchPagination, products []domain.ProductResponse, sortValues []string) ([]domain.ProductResponse, string, string) {
+	cursor := searchPagination.Cursor
+	backward := cursor != nil && cursor.Backward
+
+	hasMore := searchPagination.Limit > 0 && len(products) > searchPagination.Limit
+	if hasMore {
+		products, sortValues = products[:searchPagination.Limit], sortValues[:searchPagination.Limit]
+	}
+	if backward {
+		for i, j := 0, len(products)-1; i < j; i, j = i+1, j-1 {
+			products[i], products[j] = products[j], products[i]
+			sortValues[i], sortValues[j] = sortValues[j], sortValues[i]
+		}
+	}
+	if len(products) == 0 {
+		return products, "", ""
+	}
+
+	hasNext, hasPrev := hasMore, cursor != nil || searchPagination.Offset > 0
+	if backward {
+		hasNext, hasPrev = true, hasMore
+	}
+
+	newCursor := func(i int, backward bool) string {
+		return util.EncodeSearchCursor(util.SearchCursor{
+			SortBy:   searchPagination.SortBy,
+			Order:    searchPagination.Order(),
+			Value:    sortValues[i],
+			Id:       products[i].Id,
+			Backward: backward,
+		})
+	}
+
+	var next, prev string
+	if hasNext {
+		next = newCursor(len(products)-1, false)
+	}
+	if hasPrev {
+		prev = newCursor(0, true)
+	}
+	return products, next, prev
+}
+
+// ts_headline returns the seller's text verbatim, so matched words are
+// wrapped in sentinels that are not HTML. markHighlight escapes the snippet
+// and only then turns the sentinels into <mark> tags.
+const (
+	highlightStart = "\ue000"
+	highlightStop  = "\ue001"
 
-		JOIN total_product_sold ps ON p.id = ps.product_id --baru
+	searchHeadlineOptions = "StartSel=" + highlightStart + ", StopSel=" + highlightStop + ", HighlightAll=true"
+)
+
+var highlightMarks = strings.NewReplacer(highlightStart, "<mark>", highlightStop, "</mark>")
+
+// markHighlight makes a snippet with sentinel delimiters safe to render as
+// HTML.
+func markHighlight(snippet string) string {
+	return highlightMarks.Replace(html.EscapeString(snippet))
+}
 
-		WHERE 1 = 1
+func (r *ProductRepository) SearchProduct(searchPagination *util.SearchPagination, userId string) ([]domain.ProductResponse, util.SearchPage, error) {
+
+	// Produk yang belum pernah terjual tetap tampil dengan total_sold 0
+	// Kolom relevance dan highlight baru diketahui setelah filter pencarian dibuat
+	// Stok yang sedang direservasi pembeli lain tidak ditampilkan
+	selectQuery := `
+		SELECT p.id, p.name, p.price, p.image_url, GREATEST(p.stock - ` + reservedProductStock + `, 0) AS stock, p.condition, p.tags, p.is_purchaseable, p.deleted_at, p.created_at as date,
+		COALESCE(ps.total_sold, 0) AS total_sold, %s AS relevance, %s AS highlight
+		FROM products p
+		LEFT JOIN total_product_sold ps ON p.id = ps.product_id
 	`
+	// Produk yang diarsipkan hanya tampil jika diminta
+	query := " WHERE p.deleted_at IS NULL"
+	if searchPagination.Archived {
+		query = " WHERE p.deleted_at IS NOT NULL"
+	}
 	// Buat slice untuk menyimpan nilai parameter prepared statement
 	var args []interface{}
 
@@ -46,66 +151,171 @@ func SearchProduct(searchPagination *util.SearchPagination, userId string) ([]do
 		}
 	}
 
-	// Tambahkan filter untuk menampilkan produk dengan stok kosong jika showEmptyStock=true
+	// Stok kosong dan rentang harga dicek per varian untuk produk yang punya varian,
+	// cukup satu varian yang cocok
+	var productFilters, variantFilters []string
 	if !searchPagination.ShowEmptyStock {
-		query += " AND stock > 0"
+		productFilters = append(productFilters, "p.stock > "+reservedProductStock)
+		variantFilters = append(variantFilters, "v.stock > "+reservedVariantStock)
 	}
-
-	// Tambahkan filter berdasarkan rentang harga
 	if searchPagination.MaxPrice != 0 {
-		query += fmt.Sprintf(" AND price <= $%d", paramIndex)
+		productFilters = append(productFilters, fmt.Sprintf("p.price <= $%d", paramIndex))
+		variantFilters = append(variantFilters, fmt.Sprintf("COALESCE(v.price, p.price) <= $%d", paramIndex))
 		args = append(args, searchPagination.MaxPrice)
 		paramIndex++
 	}
 	if searchPagination.MinPrice != 0 {
-		query += fmt.Sprintf(" AND price >= $%d", paramIndex)
+		productFilters = append(productFilters, fmt.Sprintf("p.price >= $%d", paramIndex))
+		variantFilters = append(variantFilters, fmt.Sprintf("COALESCE(v.price, p.price) >= $%d", paramIndex))
 		args = append(args, searchPagination.MinPrice)
 		paramIndex++
 	}
+	if len(productFilters) > 0 {
+		query += fmt.Sprintf(`
+		AND (EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id AND %s)
+		OR (NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id) AND %s))`,
+			strings.Join(variantFilters, " AND "), strings.Join(productFilters, " AND "))
+	}
+
+	// Tambahkan filter berdasarkan tags, && untuk salah satu tag dan @> untuk semua tag
+	if len(searchPagination.Tags) > 0 {
+		operator := "&&"
+		if searchPagination.TagMatch == util.TagMatchAll {
+			operator = "@>"
+		}
+		query += fmt.Sprintf(" AND p.tags %s $%d", operator, paramIndex)
+		args = append(args, pq.Array(searchPagination.Tags))
+		paramIndex++
+	}
 
-	// Tambahkan filter berdasarkan pencarian nama produk
+	// Pencarian full-text di nama dan tags, trigram untuk salah ketik
+	relevance, highlight := "0", "''"
 	if searchPagination.Search != "" {
-		query += fmt.Sprintf(" AND name LIKE $%d", paramIndex)
-		args = append(args, "%"+searchPagination.Search+"%")
+		tsQuery := fmt.Sprintf("websearch_to_tsquery('simple', $%d)", paramIndex)
+		query += fmt.Sprintf(" AND (p.search_vector @@ %s OR $%d <%% p.name)", tsQuery, paramIndex)
+		relevance = fmt.Sprintf("ts_rank(p.search_vector, %s) + word_similarity($%d, p.name)", tsQuery, paramIndex)
+		highlight = fmt.Sprintf("ts_headline('simple', p.name || ' ' || array_to_string(p.tags, ' '), %s, '%s')", tsQuery, searchHeadlineOptions)
+		args = append(args, searchPagination.Search)
 		paramIndex++
 	}
+	query = fmt.Sprintf(selectQuery, relevance, highlight) + query
 
-	// Hitung jumlah total produk tanpa paging
-	totalQuery := "SELECT COUNT(*) FROM (" + query + ") AS total"
-	var total int
-	err := config.GetDB().QueryRow(totalQuery, args...).Scan(&total)
-	if err != nil {
-		return nil, 0, err
+	// Hitung jumlah total produk tanpa paging, hanya jika diminta
+	page := util.SearchPage{}
+	if searchPagination.WithTotal {
+		var total int
+		totalQuery := "SELECT COUNT(*) FROM (" + query + ") AS total"
+		if err := r.db.QueryRow(totalQuery, args...).Scan(&total); err != nil {
+			return nil, page, err
+		}
+		page.Total = &total
+	}
+
+	// Facet dihitung dengan filter yang sama sebelum cursor dan paging
+	if len(searchPagination.Facets) > 0 {
+		facets, err := r.searchFacets(searchPagination, query, args)
+		if err != nil {
+			return nil, page, err
+		}
+		page.Facets = facets
+	}
+
+	// Keyset pagination: lanjut dari baris cursor, mundur jika cursor menunjuk halaman sebelumnya
+	sortColumn := searchSortColumn(searchPagination.SortBy, relevance)
+	order, offset := searchPagination.Order(), searchPagination.Offset
+	if cursor := searchPagination.Cursor; cursor != nil {
+		if cursor.Backward {
+			order = reverseOrder(order)
+		}
+		comparison := ">"
+		if order == util.Descending {
+			comparison = "<"
+		}
+		query += fmt.Sprintf(" AND (%s, p.id) %s ($%d, $%d)", sortColumn, comparison, paramIndex, paramIndex+1)
+		args = append(args, cursor.Value, cursor.Id)
+		paramIndex += 2
+		offset = 0
 	}
 
-	// Tambahkan fitur pagination ke query
-	query += fmt.Sprintf(" ORDER BY %s %s LIMIT $%d OFFSET $%d", searchPagination.SortBy, searchPagination.OrdedBy, paramIndex, paramIndex+1)
-	args = append(args, searchPagination.Limit, searchPagination.Offset)
+	// Ambil satu baris lebih untuk tahu apakah masih ada halaman berikutnya
+	query += fmt.Sprintf(" ORDER BY %s %s, p.id %s LIMIT $%d OFFSET $%d", sortColumn, order, order, paramIndex, paramIndex+1)
+	args = append(args, searchPagination.Limit+1, offset)
 
 	// Eksekusi query
-	rows, err := config.GetDB().Query(query, args...)
+	rows, err := r.db.Query(query, args...)
 	if err != nil {
-		return nil, 0, err
+		return nil, page, err
 	}
 	defer rows.Close()
 
 	var products []domain.ProductResponse
+	var sortValues []string
 	for rows.Next() {
 		var product domain.ProductResponse
-		var date string
+		var date time.Time
+		var rank float64
 
 		err := rows.Scan(&product.Id, &product.Name, &product.Price, &product.ImageURL, &product.Stock, &product.Condition, pq.Array(&product.Tags),
-			&product.IsPurchaseable, &date, &product.PurchaseCount)
+			&product.IsPurchaseable, &product.DeletedAt, &date, &product.PurchaseCount, &rank, &product.Highlight)
 		if err != nil {
-			return nil, 0, err
+			return nil, page, err
 		}
+		product.Highlight = markHighlight(product.Highlight)
 
 		products = append(products, product)
+		sortValues = append(sortValues, searchSortValue(searchPagination.SortBy, product.Price, date, rank))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, page, err
 	}
 
+	products, page.NextCursor, page.PrevCursor = searchPageCursors(searchPagination, products, sortValues)
 	if len(products) == 0 {
-		return nil, 0, nil
+		return nil, page, nil
 	}
 
-	return products, total, nil
+	productIds := make([]string, len(products))
+	for i := range products {
+		productIds[i] = products[i].Id
+	}
+	variants, err := r.getProductVariants(productIds...)
+	if err != nil {
+		return nil, page, err
+	}
+	images, err := r.getProductImages(productIds...)
+	if err != nil {
+		return nil, page, err
+	}
+	for i := range products {
+		products[i].Variants = variants[products[i].Id]
+		products[i].Images = images[products[i].Id]
+	}
+
+	return products, page, nil
+}
+
+// GetPopularTags counts the products carrying each tag, most used first.
+func (r *ProductRepository) GetPopularTags(limit int) ([]domain.TagCount, error) {
+	rows, err := r.db.Query(`
+		SELECT tag, COUNT(*) AS total
+		FROM products, unnest(tags) AS tag
+		WHERE deleted_at IS NULL
+		GROUP BY tag
+		ORDER BY total DESC, tag
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []domain.TagCount
+	for rows.Next() {
+		var tag domain.TagCount
+		if err := rows.Scan(&tag.Tag, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
 }
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	"shopifyx/domain"
+
+	"github.com/lib/pq"
+)
+
+// InsertProductVariantsTx adds the variants of a new product, moving the
+// stock of each one into the product and the ledger.
+func InsertProductVariantsTx(tx *sql.Tx, productId, actorId string, variants []domain.ProductVariant) error {
+	for _, variant := range variants {
+		options, err := json.Marshal(variant.Options)
+		if err != nil {
+			return err
+		}
+
+		var variantId string
+		err = tx.QueryRow(
+			`INSERT INTO product_variants (product_id, sku, options, price, stock) VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+			productId, variant.SKU, options, variant.Price, variant.Stock,
+		).Scan(&variantId)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`UPDATE products SET stock = stock + $1 WHERE id = $2`, variant.Stock, productId); err != nil {
+			return err
+		}
+
+		movement := &domain.StockMovement{ProductId: productId, VariantId: variantId, Delta: variant.Stock, Reason: domain.Restock, ActorId: actorId}
+		if err := InsertStockMovementTx(tx, movement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getProductVariants loads the variants of the given products, keyed by
+// product id, with the price already falling back to the product price and
+// the stock reserved by buyers left out.
+func (r *ProductRepository) getProductVariants(productIds ...string) (map[string][]domain.ProductVariantResponse, error) {
+	rows, err := r.db.Query(`
+	SELECT v.product_id, v.id, v.sku, v.options, COALESCE(v.price, p.price), GREATEST(v.stock - `+reservedVariantStock+`, 0)
+	FROM product_variants v
+	JOIN products p ON p.id = v.product_id
+	WHERE v.product_id = ANY($1)
+	ORDER BY v.product_id, v.created_at, v.sku`, pq.Array(productIds))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := make(map[string][]domain.ProductVariantResponse)
+	for rows.Next() {
+		var productId string
+		var options []byte
+		var variant domain.ProductVariantResponse
+		if err := rows.Scan(&productId, &variant.Id, &variant.SKU, &options, &variant.Price, &variant.Stock); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(options, &variant.Options); err != nil {
+			return nil, err
+		}
+		variants[productId] = append(variants[productId], variant)
+	}
+	return variants, rows.Err()
+}
+
+// UpdateVariantStock applies a seller's stock update to one variant, keeps
+// the product stock at the sum of its variants and returns the new stock of
+// the variant.
+func (r *ProductRepository) UpdateVariantStock(productId, variantId, actorId string, update *domain.StockUpdate) (int, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// purchases lock the product before its variant, taking the locks in
+	// the same order keeps the two from deadlocking
+	if err := lockProductTx(tx, productId); err != nil {
+		if IdNotFound(err) {
+			return 0, ErrVariantNotFound
+		}
+		return 0, err
+	}
+
+	var stock, reserved int
+	err = tx.QueryRow(
+		`SELECT v.stock, `+reservedVariantStock+` FROM product_variants v WHERE v.id = $1 AND v.product_id = $2 FOR UPDATE`,
+		variantId, productId,
+	).Scan(&stock, &reserved)
+	if err == sql.ErrNoRows || IdNotFound(err) {
+		return 0, ErrVariantNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	delta, reason := update.Movement(stock)
+	if stock+delta < 0 {
+		return 0, ErrStockBelowZero
+	}
+	if stock+delta < reserved {
+		return 0, ErrStockBelowReserved
+	}
+
+	if _, err := tx.Exec(`UPDATE product_variants SET stock = stock + $1 WHERE id = $2`, delta, variantId); err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+	UPDATE products
+	SET stock = (SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = $1)
+	WHERE id = $1`, productId)
+	if err != nil {
+		return 0, err
+	}
+
+	movement := &domain.StockMovement{ProductId: productId, VariantId: variantId, Delta: delta, Reason: reason, ActorId: actorId}
+	if err := InsertStockMovementTx(tx, movement); err != nil {
+		return 0, err
+	}
+
+	return stock + delta, tx.Commit()
+}
+
+// DecrementVariantStockTx takes quantity out of a variant of productId. It
+// returns sql.ErrNoRows when the variant does not exist or has too little
+// stock that is not reserved by other buyers.
+func DecrementVariantStockTx(tx *sql.Tx, productId, variantId string, quantity int) error {
+	result, err := tx.Exec(
+		`UPDATE product_variants v SET stock = v.stock - $1
+		WHERE v.id = $2 AND v.product_id = $3 AND v.stock - `+reservedVariantStock+` >= $1`,
+		quantity, variantId, productId,
+	)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CheckVariantPurchaseTx explains why a stock decrement found nothing to
+// update once the product and bank account are known to be fine.
+func CheckVariantPurchaseTx(tx *sql.Tx, productId, variantId string, quantity int) error {
+	if variantId == "" {
+		var hasVariants bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM product_variants WHERE product_id = $1)`, productId).Scan(&hasVariants)
+		if err != nil {
+			return err
+		}
+		if hasVariants {
+			return ErrVariantRequired
+		}
+		return ErrInsufficientStock
+	}
+
+	var stock int
+	err := tx.QueryRow(`SELECT stock FROM product_variants WHERE id = $1 AND product_id = $2`, variantId, productId).Scan(&stock)
+	if err == sql.ErrNoRows || IdNotFound(err) {
+		return ErrVariantNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return ErrInsufficientStock
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+
+	"shopifyx/domain"
+	"shopifyx/util"
+)
+
+// InsertStockMovementTx records a stock change made earlier in tx, reading
+// the product stock it left behind. A zero delta changed nothing and is not
+// recorded.
+func InsertStockMovementTx(tx *sql.Tx, movement *domain.StockMovement) error {
+	if movement.Delta == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+	INSERT INTO stock_movements (product_id, variant_id, delta, reason, actor_id, payment_id, stock_after)
+	SELECT id, NULLIF($2, '')::UUID, $3, $4, NULLIF($5, '')::UUID, NULLIF($6, '')::UUID, stock
+	FROM products
+	WHERE id = $1`,
+		movement.ProductId,
+		movement.VariantId,
+		movement.Delta,
+		movement.Reason,
+		movement.ActorId,
+		movement.PaymentId,
+	)
+	return err
+}
+
+// GetStockHistory lists the ledger of a product, newest first, with the
+// number of entries matching the filters.
+func (r *ProductRepository) GetStockHistory(productId string, stockPagination *util.StockHistoryPagination) ([]domain.StockMovement, int, error) {
+	query := `
+		SELECT sm.id, sm.product_id, COALESCE(sm.variant_id::TEXT, ''), sm.delta, sm.reason,
+			COALESCE(sm.actor_id::TEXT, ''), COALESCE(sm.payment_id::TEXT, ''), sm.stock_after, sm.created_at
+		FROM stock_movements sm
+		WHERE sm.product_id = $1
+	`
+	args := []interface{}{productId}
+	paramIndex := 2
+
+	if stockPagination.VariantId != "" {
+		query += fmt.Sprintf(" AND sm.variant_id = $%d", paramIndex)
+		args = append(args, stockPagination.VariantId)
+		paramIndex++
+	}
+
+	if stockPagination.Reason != "" {
+		query += fmt.Sprintf(" AND sm.reason = $%d", paramIndex)
+		args = append(args, stockPagination.Reason)
+		paramIndex++
+	}
+
+	if !stockPagination.From.IsZero() {
+		query += fmt.Sprintf(" AND sm.created_at >= $%d", paramIndex)
+		args = append(args, stockPagination.From)
+		paramIndex++
+	}
+	if !stockPagination.To.IsZero() {
+		query += fmt.Sprintf(" AND sm.created_at < $%d", paramIndex)
+		args = append(args, stockPagination.To)
+		paramIndex++
+	}
+
+	totalQuery := "SELECT COUNT(*) FROM (" + query + ") AS total"
+	var total int
+	if err := r.db.QueryRow(totalQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query += fmt.Sprintf(" ORDER BY sm.created_at DESC, sm.id DESC LIMIT $%d OFFSET $%d", paramIndex, paramIndex+1)
+	args = append(args, stockPagination.Limit, stockPagination.Offset)
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var movements []domain.StockMovement
+	for rows.Next() {
+		var movement domain.StockMovement
+		err := rows.Scan(
+			&movement.Id,
+			&movement.ProductId,
+			&movement.VariantId,
+			&movement.Delta,
+			&movement.Reason,
+			&movement.ActorId,
+			&movement.PaymentId,
+			&movement.StockAfter,
+			&movement.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+		movements = append(movements, movement)
+	}
+
+	return movements, total, rows.Err()
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"shopifyx/domain"
+)
+
+// reservedStock sums the unexpired reservations matching condition, which
+// refers to the reservation as r.
+func reservedStock(condition string) string {
+	return `(SELECT COALESCE(SUM(r.quantity), 0) FROM stock_reservations r WHERE ` + condition + ` AND r.expires_at > NOW())`
+}
+
+var (
+	// reservedProductStock is held out of products p
+	reservedProductStock = reservedStock("r.product_id = p.id")
+	// reservedVariantStock is held out of product_variants v
+	reservedVariantStock = reservedStock("r.variant_id = v.id")
+)
+
+// lockProductTx locks a product row so that reservations and purchases of it
+// queue up and every later statement of tx sees the reservations committed
+// before it.
+func lockProductTx(tx *sql.Tx, productId string) error {
+	_, err := tx.Exec(`SELECT 1 FROM products WHERE id = $1 FOR UPDATE`, productId)
+	return err
+}
+
+// ReserveStock holds reservation.Quantity of a purchaseable product for the
+// buyer until reservation.ExpiresAt, if that much is not already held for
+// someone else. A buyer holding an unexpired reservation on the product gets
+// ErrReservationExists, so nobody can hoard its stock one reservation at a
+// time.
+func (r *PaymentRepository) ReserveStock(reservation *domain.StockReservation, productId, buyerId string) error {
+	if reservation.Quantity < 1 {
+		return ErrInvalidQuantity
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := lockProductTx(tx, productId); err != nil {
+		if IdNotFound(err) {
+			return ErrPaymentDetailsInvalid
+		}
+		return err
+	}
+
+	var available int
+	var purchaseable, hasVariants bool
+	err = tx.QueryRow(`
+	SELECT p.stock - `+reservedProductStock+`, p.is_purchaseable AND p.deleted_at IS NULL,
+		EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+	FROM products p
+	WHERE p.id = $1`, productId).Scan(&available, &purchaseable, &hasVariants)
+	if err == sql.ErrNoRows || (err == nil && !purchaseable) {
+		return ErrPaymentDetailsInvalid
+	}
+	if err != nil {
+		return err
+	}
+
+	// the product lock keeps two reservations of the buyer from both passing
+	var held bool
+	err = tx.QueryRow(`
+	SELECT EXISTS (
+		SELECT 1 FROM stock_reservations
+		WHERE product_id = $1 AND buyer_id = $2 AND expires_at > NOW()
+	)`, productId, buyerId).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrReservationExists
+	}
+
+	switch {
+	case reservation.VariantId != "":
+		err := tx.QueryRow(
+			`SELECT v.stock - `+reservedVariantStock+` FROM product_variants v WHERE v.id = $1 AND v.product_id = $2`,
+			reservation.VariantId, productId,
+		).Scan(&available)
+		if err == sql.ErrNoRows || IdNotFound(err) {
+			return ErrVariantNotFound
+		}
+		if err != nil {
+			return err
+		}
+	case hasVariants:
+		return ErrVariantRequired
+	}
+	if available < reservation.Quantity {
+		return ErrInsufficientStock
+	}
+
+	err = tx.QueryRow(`
+	INSERT INTO stock_reservations (product_id, variant_id, buyer_id, quantity, expires_at)
+	VALUES ($1, NULLIF($2, '')::UUID, $3, $4, $5)
+	RETURNING id`,
+		productId, reservation.VariantId, buyerId, reservation.Quantity, reservation.ExpiresAt,
+	).Scan(&reservation.Id)
+	if err != nil {
+		return err
+	}
+	reservation.ProductId = productId
+
+	return tx.Commit()
+}
+
+// ClaimReservationTx removes the reservation a payment is made with, so the
+// stock it held becomes available to that payment only. A payment without a
+// variant takes the one of the reservation.
+func ClaimReservationTx(tx *sql.Tx, payment *domain.Payment, productId, buyerId string) error {
+	var variantId string
+	var quantity int
+	var live bool
+	err := tx.QueryRow(`
+	DELETE FROM stock_reservations
+	WHERE id = $1 AND product_id = $2 AND buyer_id = $3
+	RETURNING COALESCE(variant_id::TEXT, ''), quantity, expires_at > NOW()`,
+		payment.ReservationId, productId, buyerId,
+	).Scan(&variantId, &quantity, &live)
+	if err == sql.ErrNoRows || IdNotFound(err) {
+		return ErrReservationNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !live {
+		return ErrReservationExpired
+	}
+
+	if payment.VariantId == "" {
+		payment.VariantId = variantId
+	}
+	if payment.VariantId != variantId || payment.Quantity != quantity {
+		return ErrReservationMismatch
+	}
+	return nil
+}
+
+// ReleaseExpiredReservations deletes reservations that expired before
+// expiredBefore. Expired ones already hold nothing, this only keeps the
+// table small.
+func (r *PaymentRepository) ReleaseExpiredReservations(expiredBefore time.Time) (int64, error) {
+	result, err := r.db.Exec(`DELETE FROM stock_reservations WHERE expires_at < $1`, expiredBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"time"
+
+	"shopifyx/domain"
+	"shopifyx/util"
+)
+
+type ProductStore interface {
+	CreateProduct(product *domain.Product, userId string) error
+	GetProductById(productId string, withArchived bool) (domain.ProductResponse, domain.SellerResponse, error)
+	UpdateProduct(product *domain.Product, productId, userId string) (int, error)
+	DeleteProductById(productId, userId string) (int, error)
+	RestoreProduct(productId, userId string, deletedSince time.Time) (int, error)
+	GetUserIdFromProductId(productId string) (string, error)
+	UpdateProductStock(productId, actorId string, update *domain.StockUpdate) (int, error)
+	UpdateVariantStock(productId, variantId, actorId string, update *domain.StockUpdate) (int, error)
+	GetStockHistory(productId string, stockPagination *util.StockHistoryPagination) ([]domain.StockMovement, int, error)
+	AddProductImage(productId, url string) ([]domain.ProductImage, error)
+	RemoveProductImage(productId, imageId string) ([]domain.ProductImage, error)
+	ReorderProductImages(productId string, imageIds []string) ([]domain.ProductImage, error)
+	SetPrimaryProductImage(productId, imageId string) ([]domain.ProductImage, error)
+	UnlistProduct(productId string) error
+	SearchProduct(searchPagination *util.SearchPagination, userId string) ([]domain.ProductResponse, util.SearchPage, error)
+	GetPopularTags(limit int) ([]domain.TagCount, error)
+}
+
+type UserStore interface {
+	RegisterUser(username, name, password string) (domain.User, error)
+	LoginUser(username, password string) (domain.User, error)
+	UpdateUserRoles(userId string, roles []domain.RoleEnum) error
+	SetUserBanned(userId string, banned bool) error
+}
+
+type BankAccountStore interface {
+	AddBankAccount(bankAccount *domain.BankAccount, userId string) error
+	GetBankAccounts(userId string) ([]domain.BankAccount, error)
+	UpdateBankAccount(bankAccount *domain.BankAccount, bankAccountId, userId string) (int, error)
+	DeleteBankAccount(bankAccountId, userId string) error
+}
+
+type PaymentStore interface {
+	CreatePayment(payment *domain.Payment, productId, buyerId string) error
+	ReserveStock(reservation *domain.StockReservation, productId, buyerId string) error
+	ReleaseExpiredReservations(expiredBefore time.Time) (int64, error)
+	GetPayment(paymentId, userId string) (domain.PaymentResponse, error)
+	SubmitPaymentProof(paymentId, buyerId, paymentProofImageUrl string) (domain.PaymentResponse, error)
+	UpdatePaymentStatus(paymentId, userId string, status domain.PaymentStatusEnum) (domain.PaymentResponse, error)
+	GetSellerPayments(sellerId string, paymentPagination *util.PaymentPagination) ([]domain.SellerPaymentResponse, int, error)
+	GetPurchases(buyerId string, paymentPagination *util.PaymentPagination) ([]domain.PurchaseResponse, int, error)
+}
+
+type TokenStore interface {
+	CreateRefreshToken(userId, tokenHash string, expiresAt time.Time) error
+	RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (domain.User, error)
+	RevokeRefreshToken(userId, tokenHash string) error
+	RevokeAccessToken(jti string, expiresAt time.Time) error
+	IsAccessTokenRevoked(jti, userId string) (bool, error)
+	DeleteExpiredTokens(expiredBefore time.Time) error
+}
+
+type CartStore interface {
+	AddCartItem(userId, productId string, quantity int) error
+	UpdateCartItem(userId, productId string, quantity int) error
+	RemoveCartItem(userId, productId string) error
+	GetCart(userId string) ([]domain.CartItemResponse, error)
+	Checkout(userId string, checkout *domain.Checkout) ([]domain.CheckoutOrderResponse, error)
+}
+
+type IdempotencyStore interface {
+	ClaimIdempotencyKey(userId, key, method, path, requestHash string, expiredBefore, staleBefore time.Time) (domain.IdempotencyRecord, bool, error)
+	SaveIdempotencyResponse(userId, key string, statusCode int, contentType string, body []byte) error
+	ReleaseIdempotencyKey(userId, key string) error
+	DeleteExpiredIdempotencyKeys(expiredBefore time.Time) (int64, error)
+}
+
+type AssetStore interface {
+	CreateAsset(asset *domain.Asset) error
+	GetAssetByURL(url string) (domain.Asset, error)
+	GetOrphanedAssets(createdBefore time.Time, limit int) ([]domain.Asset, error)
+	DeleteAsset(assetId string) error
+}
+
+var (
+	_ ProductStore     = (*ProductRepository)(nil)
+	_ UserStore        = (*UserRepository)(nil)
+	_ BankAccountStore = (*BankAccountRepository)(nil)
+	_ PaymentStore     = (*PaymentRepository)(nil)
+	_ TokenStore       = (*TokenRepository)(nil)
+	_ CartStore        = (*CartRepository)(nil)
+	_ IdempotencyStore = (*IdempotencyRepository)(nil)
+	_ AssetStore       = (*AssetRepository)(nil)
+
+	_ ProductStore     = (*MemoryStore)(nil)
+	_ UserStore        = (*MemoryStore)(nil)
+	_ BankAccountStore = (*MemoryStore)(nil)
+	_ PaymentStore     = (*MemoryStore)(nil)
+	_ TokenStore       = (*MemoryStore)(nil)
+	_ CartStore        = (*MemoryStore)(nil)
+	_ IdempotencyStore = (*MemoryStore)(nil)
+	_ AssetStore       = (*MemoryStore)(nil)
+)
@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"database/sql"
+	"time"
+
+	"shopifyx/domain"
+
+	"github.com/lib/pq"
+)
+
+type TokenRepository struct {
+	db *sql.DB
+}
+
+func NewTokenRepository(db *sql.DB) *TokenRepository {
+	return &TokenRepository{db: db}
+}
+
+func (r *TokenRepository) CreateRefreshToken(userId, tokenHash string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`,
+		userId, tokenHash, expiresAt,
+	)
+	return err
+}
+
+// RotateRefreshToken exchanges a valid refresh token for a new one and returns
+// its owner. Presenting a token that was already rotated is treated as theft
+// and revokes every refresh token of the user.
+func (r *TokenRepository) RotateRefreshToken(tokenHash, newTokenHash string, expiresAt time.Time) (domain.User, error) {
+	var user domain.User
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	var tokenId string
+	var tokenExpiresAt time.Time
+	var revokedAt, bannedAt sql.NullTime
+	var roles []string
+	err = tx.QueryRow(`
+	SELECT rt.id, rt.expires_at, rt.revoked_at, u.id, u.username, u.name, u.roles, u.banned_at
+	FROM refresh_tokens rt
+	JOIN users u ON u.id = rt.user_id
+	WHERE rt.token_hash = $1
+	FOR UPDATE OF rt`,
+		tokenHash,
+	).Scan(&tokenId, &tokenExpiresAt, &revokedAt, &user.Id, &user.Username, &user.Name, pq.Array(&roles), &bannedAt)
+	if err == sql.ErrNoRows {
+		return domain.User{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	if revokedAt.Valid {
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, user.Id); err != nil {
+			return domain.User{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return domain.User{}, err
+		}
+		return domain.User{}, ErrRefreshTokenInvalid
+	}
+	if tokenExpiresAt.Before(time.Now()) {
+		return domain.User{}, ErrRefreshTokenInvalid
+	}
+	if bannedAt.Valid {
+		return domain.User{}, ErrUserBanned
+	}
+	user.Roles = domain.RolesFromStrings(roles)
+
+	var newTokenId string
+	err = tx.QueryRow(
+		`INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id`,
+		user.Id, newTokenHash, expiresAt,
+	).Scan(&newTokenId)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	_, err = tx.Exec(`UPDATE refresh_tokens SET revoked_at = NOW(), replaced_by = $1 WHERE id = $2`, newTokenId, tokenId)
+	if err != nil {
+		return domain.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return domain.User{}, err
+	}
+	return user, nil
+}
+
+func (r *TokenRepository) RevokeRefreshToken(userId, tokenHash string) error {
+	_, err := r.db.Exec(
+		`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND token_hash = $2 AND revoked_at IS NULL`,
+		userId, tokenHash,
+	)
+	return err
+}
+
+func (r *TokenRepository) RevokeAccessToken(jti string, expiresAt time.Time) error {
+	_, err := r.db.Exec(
+		`INSERT INTO revoked_access_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`,
+		jti, expiresAt,
+	)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the token was revoked on logout or its
+// owner has been banned since it was issued.
+func (r *TokenRepository) IsAccessTokenRevoked(jti, userId string) (bool, error) {
+	var revoked bool
+	err := r.db.QueryRow(`
+	SELECT EXISTS (SELECT 1 FROM revoked_access_tokens WHERE jti = $1 AND $1 <> '')
+		OR EXISTS (SELECT 1 FROM users WHERE id = $2 AND banned_at IS NOT NULL)`,
+		jti, userId,
+	).Scan(&revoked)
+	if err != nil {
+		return false, err
+	}
+	return revoked, nil
+}
+
+func (r *TokenRepository) DeleteExpiredTokens(expiredBefore time.Time) error {
+	if _, err := r.db.Exec(`DELETE FROM revoked_access_tokens WHERE expires_at < $1`, expiredBefore); err != nil {
+		return err
+	}
+	_, err := r.db.Exec(`DELETE FROM refresh_tokens WHERE expires_at < $1`, expiredBefore)
+	return err
+}
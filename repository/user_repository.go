@@ -3,11 +3,20 @@ package repository
 import (
 	"database/sql"
 	"shopifyx/auth"
-	"shopifyx/config"
 	"shopifyx/domain"
+
+	"github.com/lib/pq"
 )
 
-func RegisterUser(username, name, password string) (domain.User, error) {
+type UserRepository struct {
+	db *sql.DB
+}
+
+func NewUserRepository(db *sql.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) RegisterUser(username, name, password string) (domain.User, error) {
 	var user domain.User
 
 	hashedPassword, err := auth.HashPassword(password)
@@ -15,30 +24,36 @@ func RegisterUser(username, name, password string) (domain.User, error) {
 		return user, err
 	}
 
+	var roles []string
 	query := `INSERT INTO users (username, name, password) VALUES ($1, $2, $3) 
-			  RETURNING id, name, username`
-	err = config.GetDB().QueryRow(
+			  RETURNING id, name, username, roles`
+	err = r.db.QueryRow(
 		query,
 		username,
 		name,
-		hashedPassword).Scan(&user.Id, &user.Name, &user.Username)
+		hashedPassword).Scan(&user.Id, &user.Name, &user.Username, pq.Array(&roles))
 	if err != nil {
 		return user, err
 	}
+	user.Roles = domain.RolesFromStrings(roles)
 	return user, nil
 }
 
-func LoginUser(username, password string) (domain.User, error) {
+func (r *UserRepository) LoginUser(username, password string) (domain.User, error) {
 	var storedPassword string
 	var user domain.User
+	var roles []string
+	var bannedAt sql.NullTime
 
-	query := `SELECT id, username, name, password FROM users WHERE username = $1`
-	err := config.GetDB().QueryRow(query,
+	query := `SELECT id, username, name, password, roles, banned_at FROM users WHERE username = $1`
+	err := r.db.QueryRow(query,
 		username).Scan(
 		&user.Id,
 		&user.Username,
 		&user.Name,
-		&storedPassword)
+		&storedPassword,
+		pq.Array(&roles),
+		&bannedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return user, ErrUsernameNotFound
@@ -48,8 +63,73 @@ func LoginUser(username, password string) (domain.User, error) {
 
 	err = auth.VerifyPassword(storedPassword, password)
 	if err != nil {
-		return user, ErrUsernameNotFound
+		return user, ErrPasswordWrong
+	}
+
+	if bannedAt.Valid {
+		return domain.User{}, ErrUserBanned
 	}
 
+	user.Roles = domain.RolesFromStrings(roles)
 	return user, nil
 }
+
+func (r *UserRepository) UpdateUserRoles(userId string, roles []domain.RoleEnum) error {
+	result, err := r.db.Exec(
+		`UPDATE users SET roles = $1 WHERE id = $2`,
+		pq.Array(domain.RolesToStrings(roles)), userId,
+	)
+	if err != nil {
+		if IdNotFound(err) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetUserBanned bans or unbans a user. Banning also revokes every refresh
+// token of the user so no new access token can be obtained.
+func (r *UserRepository) SetUserBanned(userId string, banned bool) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`UPDATE users SET banned_at = CASE WHEN $1 THEN COALESCE(banned_at, NOW()) ELSE NULL END WHERE id = $2`,
+		banned, userId,
+	)
+	if err != nil {
+		if IdNotFound(err) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrUserNotFound
+	}
+
+	if banned {
+		_, err := tx.Exec(`UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`, userId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"shopifyx/config"
+)
+
+var ErrObjectNotFound = errors.New("object not found")
+
+const (
+	// UploadsPrefix holds processed images, the only objects ever linked to.
+	UploadsPrefix = "uploads/"
+	// IncomingPrefix holds raw presigned uploads until they are confirmed.
+	// They are never registered as assets nor served back.
+	IncomingPrefix = "incoming/"
+)
+
+// PresignedUpload is a request the client sends itself to upload straight to
+// storage. Headers must be sent exactly as given, they are part of the
+// signature.
+type PresignedUpload struct {
+	URL     string
+	Method  string
+	Headers http.Header
+}
+
+type ObjectInfo struct {
+	Size        int64
+	ContentType string
+}
+
+// ImageStore saves uploaded images and returns the public URL they can be
+// fetched from. Clients may also upload directly with a presigned PUT, which
+// is then checked with Stat and read back with Open for processing. List
+// returns the keys under prefix last modified before modifiedBefore.
+type ImageStore interface {
+	Save(ctx context.Context, key string, body io.Reader, contentType string) (string, error)
+	PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (PresignedUpload, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string, modifiedBefore time.Time) ([]string, error)
+	URL(key string) string
+}
+
+func NewImageStore(ctx context.Context, cfg config.ImageStorageConfig) (ImageStore, error) {
+	switch cfg.Driver {
+	case config.ImageStorageS3, config.ImageStorageS3Compatible:
+		return NewS3ImageStore(ctx, cfg)
+	case config.ImageStorageLocal:
+		return NewLocalImageStore(cfg.LocalDir, cfg.PublicBaseURL, cfg.LocalURLPath, cfg.LocalUploadSecret)
+	}
+	return nil, fmt.Errorf("unknown image storage driver %q", cfg.Driver)
+}
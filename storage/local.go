@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DirectUploadPath is where the local driver accepts presigned PUTs.
+const DirectUploadPath = "/v1/image/direct"
+
+var ErrInvalidSignature = errors.New("upload signature is invalid or expired")
+
+// LocalImageStore keeps images on disk for development and CI. Processed
+// images are served back by the static route registered for PublicDir, and
+// presigned uploads are PUT to DirectUploadPath with an HMAC signature.
+type LocalImageStore struct {
+	dir     string
+	baseURL string
+	urlPath string
+	secret  []byte
+}
+
+func NewLocalImageStore(dir, baseURL, urlPath, secret string) (*LocalImageStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LocalImageStore{
+		dir:     dir,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		urlPath: "/" + strings.Trim(urlPath, "/"),
+		secret:  key,
+	}, nil
+}
+
+func (s *LocalImageStore) Dir() string {
+	return s.dir
+}
+
+func (s *LocalImageStore) URLPath() string {
+	return s.urlPath
+}
+
+// PublicDir is the URL path and folder of processed images. Raw uploads
+// under IncomingPrefix sit next to it and must not be served.
+func (s *LocalImageStore) PublicDir() (string, string) {
+	prefix := strings.TrimSuffix(UploadsPrefix, "/")
+	return s.urlPath + "/" + prefix, filepath.Join(s.dir, prefix)
+}
+
+func (s *LocalImageStore) URL(key string) string {
+	return s.baseURL + s.urlPath + "/" + key
+}
+
+// path maps key to a file under dir. Keys must already be clean, otherwise
+// "a/../b" would silently address another folder than the one it names.
+func (s *LocalImageStore) path(key string) (string, error) {
+	if key == "" || path.Clean(key) != key || strings.Contains(key, "..") || path.IsAbs(key) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+
+	file := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(file, filepath.Clean(s.dir)+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object key %q", key)
+	}
+	return file, nil
+}
+
+func (s *LocalImageStore) Save(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(path)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return s.URL(key), nil
+}
+
+func (s *LocalImageStore) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (PresignedUpload, error) {
+	expiresAt := strconv.FormatInt(time.Now().Add(expires).Unix(), 10)
+
+	query := url.Values{}
+	query.Set("contentType", contentType)
+	query.Set("size", strconv.FormatInt(size, 10))
+	query.Set("expires", expiresAt)
+	query.Set("signature", s.sign(key, contentType, size, expiresAt))
+
+	headers := make(http.Header)
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Length", strconv.FormatInt(size, 10))
+
+	return PresignedUpload{
+		URL:     s.baseURL + DirectUploadPath + "/" + key + "?" + query.Encode(),
+		Method:  http.MethodPut,
+		Headers: headers,
+	}, nil
+}
+
+// VerifyPresigned checks the query of a presigned upload and returns the
+// content type and size it was issued for.
+func (s *LocalImageStore) VerifyPresigned(key string, query url.Values) (string, int64, error) {
+	contentType := query.Get("contentType")
+	expiresAt := query.Get("expires")
+
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil {
+		return "", 0, ErrInvalidSignature
+	}
+	expires, err := strconv.ParseInt(expiresAt, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", 0, ErrInvalidSignature
+	}
+
+	expected := s.sign(key, contentType, size, expiresAt)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return "", 0, ErrInvalidSignature
+	}
+	return contentType, size, nil
+}
+
+func (s *LocalImageStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	return ObjectInfo{Size: info.Size(), ContentType: http.DetectContentType(head[:n])}, nil
+}
+
+func (s *LocalImageStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, ErrObjectNotFound
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *LocalImageStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalImageStore) List(ctx context.Context, prefix string, modifiedBefore time.Time) ([]string, error) {
+	root := filepath.Join(s.dir, filepath.FromSlash(prefix))
+	var keys []string
+	err := filepath.WalkDir(root, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(modifiedBefore) {
+			key, err := filepath.Rel(s.dir, file)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, filepath.ToSlash(key))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (s *LocalImageStore) sign(key, contentType string, size int64, expiresAt string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", key, contentType, size, expiresAt)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"shopifyx/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3ImageStore uploads to AWS S3 or, with a custom endpoint, to any S3
+// compatible server such as MinIO.
+type S3ImageStore struct {
+	client    *s3.Client
+	presigner *s3.PresignClient
+	uploader  *manager.Uploader
+	bucket    string
+	publicURL string
+}
+
+func NewS3ImageStore(ctx context.Context, cfg config.ImageStorageConfig) (*S3ImageStore, error) {
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.S3AccessKeyId, cfg.S3SecretAccessKey, "")),
+		awsconfig.WithRegion(cfg.S3Region),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		if cfg.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(cfg.S3Endpoint)
+		}
+		o.UsePathStyle = cfg.S3UsePathStyle
+	})
+
+	publicURL := strings.TrimSuffix(cfg.S3PublicURL, "/")
+	if publicURL == "" {
+		if cfg.S3Endpoint != "" && cfg.S3UsePathStyle {
+			publicURL = strings.TrimSuffix(cfg.S3Endpoint, "/") + "/" + cfg.S3Bucket
+		} else if cfg.S3Endpoint == "" {
+			publicURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", cfg.S3Bucket, cfg.S3Region)
+		}
+	}
+
+	return &S3ImageStore{
+		client:    client,
+		presigner: s3.NewPresignClient(client),
+		uploader:  manager.NewUploader(client),
+		bucket:    cfg.S3Bucket,
+		publicURL: publicURL,
+	}, nil
+}
+
+func (s *S3ImageStore) Save(ctx context.Context, key string, body io.Reader, contentType string) (string, error) {
+	uploadResult, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        body,
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if s.publicURL != "" {
+		return s.URL(key), nil
+	}
+	return uploadResult.Location, nil
+}
+
+// PresignPut signs the content type and length, so S3 rejects an upload of
+// any other type or size.
+func (s *S3ImageStore) PresignPut(ctx context.Context, key, contentType string, size int64, expires time.Duration) (PresignedUpload, error) {
+	request, err := s.presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+
+	headers := make(http.Header)
+	for name, values := range request.SignedHeader {
+		if strings.EqualFold(name, "host") {
+			continue
+		}
+		headers[name] = values
+	}
+	return PresignedUpload{URL: request.URL, Method: request.Method, Headers: headers}, nil
+}
+
+func (s *S3ImageStore) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+
+	return ObjectInfo{Size: aws.ToInt64(output.ContentLength), ContentType: aws.ToString(output.ContentType)}, nil
+}
+
+func (s *S3ImageStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+func (s *S3ImageStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func (s *S3ImageStore) List(ctx context.Context, prefix string, modifiedBefore time.Time) ([]string, error) {
+	var keys []string
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range page.Contents {
+			if aws.ToTime(object.LastModified).Before(modifiedBefore) {
+				keys = append(keys, aws.ToString(object.Key))
+			}
+		}
+	}
+	return keys, nil
+}
+
+// URL is the public address of key. Without S3_PUBLIC_URL a virtual hosted
+// S3 compatible server has no predictable address, so the key is returned.
+func (s *S3ImageStore) URL(key string) string {
+	if s.publicURL == "" {
+		return key
+	}
+	return s.publicURL + "/" + key
+}
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"shopifyx/domain"
+	"time"
+)
+
+type PaymentPagination struct {
+	ProductId string                   `json:"productId"`
+	Status    domain.PaymentStatusEnum `json:"status"`
+	From      time.Time                `json:"from"`
+	To        time.Time                `json:"to"`
+	Limit     int                      `json:"limit"`
+	Offset    int                      `json:"offset"`
+}
@@ -6,14 +6,6 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
-func ErrorHandler(c echo.Context, code int, message string) error {
-	return c.JSON(code,
-		map[string]string{
-			"error": message,
-		},
-	)
-}
-
 func ResponseHandler(c echo.Context, code int, message string) error {
 	return c.JSON(code,
 		map[string]string{
@@ -22,32 +14,62 @@ func ResponseHandler(c echo.Context, code int, message string) error {
 	)
 }
 
-func UserSuccesResponseHandler(c echo.Context, code int, message, username, name, token string) error {
+func UserSuccesResponseHandler(c echo.Context, code int, message, username, name, token, refreshToken string) error {
 	return c.JSON(code, map[string]interface{}{
 		"message": message,
 		"data": map[string]interface{}{
-			"username":    username,
-			"name":        name,
-			"accessToken": token,
+			"username":     username,
+			"name":         name,
+			"accessToken":  token,
+			"refreshToken": refreshToken,
 		},
 	})
 }
 
-func SerachProductPaginationResponseHandler(c echo.Context, code int, products []domain.ProductResponse, limit, offset, total int) error {
+// SerachProductPaginationResponseHandler leaves total and facets out when
+// they were not asked for and sets a cursor to null when there is no page
+// that way.
+func SerachProductPaginationResponseHandler(c echo.Context, code int, products []domain.ProductResponse, limit, offset int, page SearchPage) error {
+	meta := map[string]interface{}{
+		"limit":      limit,
+		"offset":     offset,
+		"nextCursor": nullableString(page.NextCursor),
+		"prevCursor": nullableString(page.PrevCursor),
+	}
+	if page.Total != nil {
+		meta["total"] = *page.Total
+	}
+	if page.Facets != nil {
+		meta["facets"] = page.Facets
+	}
+
 	return c.JSON(code, map[string]interface{}{
 		"message": "ok",
 		"data":    products,
-		"meta": map[string]interface{}{
-			"limit":  limit,
-			"offset": offset,
-			"total":  total,
-		},
+		"meta":    meta,
+	})
+}
+
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func TagsResponseHandler(c echo.Context, code int, tags []domain.TagCount) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": "ok",
+		"data":    tags,
 	})
 }
 
-func UploadImageResponseHandler(c echo.Context, code int, url string) error {
+// UploadImageResponseHandler keeps image_url pointing at the original for
+// older clients and lists every rendition under renditions.
+func UploadImageResponseHandler(c echo.Context, code int, renditions map[string]domain.ImageRendition) error {
 	return c.JSON(code, map[string]interface{}{
-		"image_url": url,
+		"image_url":  renditions["original"].URL,
+		"renditions": renditions,
 	},
 	)
 }
@@ -62,9 +84,73 @@ func GetProductResponseHandler(c echo.Context, code int, product domain.ProductR
 	})
 }
 
+func ProductImagesResponseHandler(c echo.Context, code int, message string, images []domain.ProductImage) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": message,
+		"data":    images,
+	})
+}
+
+func StockResponseHandler(c echo.Context, code int, message string, stock int) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": message,
+		"data": map[string]interface{}{
+			"stock": stock,
+		},
+	})
+}
+
+func StockHistoryResponseHandler(c echo.Context, code int, movements []domain.StockMovement, limit, offset, total int) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": "ok",
+		"data":    movements,
+		"meta": map[string]interface{}{
+			"limit":  limit,
+			"offset": offset,
+			"total":  total,
+		},
+	})
+}
+
 func GetBankAccountsResposesHandler(c echo.Context, code int, bankAccounts []domain.BankAccounts) error {
 	return c.JSON(code, map[string]interface{}{
 		"message": "success",
 		"data":    bankAccounts,
 	})
 }
+
+func PaymentResponseHandler(c echo.Context, code int, message string, payment interface{}) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": message,
+		"data":    payment,
+	})
+}
+
+func AssetResponseHandler(c echo.Context, code int, message string, asset interface{}) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": message,
+		"data":    asset,
+	})
+}
+
+func PaymentPaginationResponseHandler(c echo.Context, code int, payments interface{}, limit, offset, total int) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": "ok",
+		"data":    payments,
+		"meta": map[string]interface{}{
+			"limit":  limit,
+			"offset": offset,
+			"total":  total,
+		},
+	})
+}
+
+func CartResponseHandler(c echo.Context, code int, items []domain.CartItemResponse, totalPrice int) error {
+	return c.JSON(code, map[string]interface{}{
+		"message": "ok",
+		"data": map[string]interface{}{
+			"items":      items,
+			"totalPrice": totalPrice,
+		},
+	})
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SearchCursor points at a row of a search result by the value of its sort
+// column and its id. Clients only ever see it encoded, so the format can change.
+type SearchCursor struct {
+	SortBy SortEnum  `json:"s"`
+	Order  OrderEnum `json:"o"`
+	Value  string    `json:"v"`
+	Id     string    `json:"id"`
+	// Backward asks for the page before the row instead of after it
+	Backward bool `json:"b,omitempty"`
+}
+
+func EncodeSearchCursor(cursor SearchCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func DecodeSearchCursor(encoded string) (*SearchCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var cursor SearchCursor
+	if err := json.Unmarshal(b, &cursor); err != nil || !cursor.SortBy.IsValid() || cursor.Id == "" {
+		return nil, ErrInvalidCursor
+	}
+	if cursor.Order != Ascending && cursor.Order != Descending {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := uuid.ParseHex(cursor.Id); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	// The value ends up in a query, make sure it parses as the sort column
+	switch cursor.SortBy {
+	case Date:
+		_, err = time.Parse(time.RFC3339Nano, cursor.Value)
+	case Relevance:
+		_, err = strconv.ParseFloat(cursor.Value, 64)
+	default:
+		_, err = strconv.Atoi(cursor.Value)
+	}
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
@@ -0,0 +1,90 @@
+package util
+
+import "shopifyx/domain"
+
+type FacetEnum string
+
+const (
+	FacetCondition FacetEnum = "condition"
+	FacetPrice     FacetEnum = "price"
+	FacetTags      FacetEnum = "tags"
+	FacetStock     FacetEnum = "stock"
+)
+
+func (f FacetEnum) IsValid() bool {
+	return f == FacetCondition || f == FacetPrice || f == FacetTags || f == FacetStock
+}
+
+const (
+	StockAvailable   = "in_stock"
+	StockUnavailable = "out_of_stock"
+)
+
+// PriceFacetBounds split prices into buckets [0, 50000), [50000, 100000), ...
+// [1000000, ∞), the same thresholds Postgres' width_bucket gets.
+var PriceFacetBounds = []int{50000, 100000, 250000, 500000, 1000000}
+
+// MaxTagFacets caps the tags facet to the most used ones.
+const MaxTagFacets = 20
+
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type PriceBucket struct {
+	Min   int  `json:"min"`
+	Max   *int `json:"max"`
+	Count int  `json:"count"`
+}
+
+// SearchFacets counts the products matching a search by a few attributes.
+// Only requested facets are set.
+type SearchFacets struct {
+	Condition []FacetCount      `json:"condition,omitempty"`
+	Price     []PriceBucket     `json:"price,omitempty"`
+	Tags      []domain.TagCount `json:"tags,omitempty"`
+	Stock     []FacetCount      `json:"stock,omitempty"`
+}
+
+func (p *SearchPagination) HasFacet(facet FacetEnum) bool {
+	for _, requested := range p.Facets {
+		if requested == facet {
+			return true
+		}
+	}
+	return false
+}
+
+// ConditionFacet lists every condition, including those without products.
+func ConditionFacet(counts map[domain.ConditionEnum]int) []FacetCount {
+	return []FacetCount{
+		{Value: string(domain.New), Count: counts[domain.New]},
+		{Value: string(domain.Second), Count: counts[domain.Second]},
+	}
+}
+
+// PriceFacet turns counts per width_bucket index, 0 being below the first
+// bound, into every bucket of PriceFacetBounds.
+func PriceFacet(counts map[int]int) []PriceBucket {
+	buckets := make([]PriceBucket, 0, len(PriceFacetBounds)+1)
+	for i := 0; i <= len(PriceFacetBounds); i++ {
+		bucket := PriceBucket{Count: counts[i]}
+		if i > 0 {
+			bucket.Min = PriceFacetBounds[i-1]
+		}
+		if i < len(PriceFacetBounds) {
+			upper := PriceFacetBounds[i]
+			bucket.Max = &upper
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets
+}
+
+func StockFacet(available, unavailable int) []FacetCount {
+	return []FacetCount{
+		{Value: StockAvailable, Count: available},
+		{Value: StockUnavailable, Count: unavailable},
+	}
+}
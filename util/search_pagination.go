@@ -1,14 +1,35 @@
 package util
 
-import "shopifyx/domain"
+import (
+	"shopifyx/domain"
+	"strings"
+)
 
 type SortEnum string
 
 const (
 	Price SortEnum = "price"
 	Date  SortEnum = "date"
+	// Relevance ranks full-text matches first and only makes sense with a search term
+	Relevance SortEnum = "relevance"
+)
+
+func (s SortEnum) IsValid() bool {
+	return s == Price || s == Date || s == Relevance
+}
+
+// TagMatchEnum decides whether a product needs any or all of the searched tags.
+type TagMatchEnum string
+
+const (
+	TagMatchAny TagMatchEnum = "any"
+	TagMatchAll TagMatchEnum = "all"
 )
 
+func (m TagMatchEnum) IsValid() bool {
+	return m == TagMatchAny || m == TagMatchAll
+}
+
 type OrderEnum string
 
 const (
@@ -21,6 +42,7 @@ type SearchPagination struct {
 	Limit          int                  `json:"limit"`
 	Offset         int                  `json:"offset"`
 	Tags           []string             `json:"tags"`
+	TagMatch       TagMatchEnum         `json:"tagMatch"`
 	Condition      domain.ConditionEnum `json:"condition"`
 	ShowEmptyStock bool                 `json:"showEmptyStock"`
 	MaxPrice       int                  `json:"maxPrice"`
@@ -28,4 +50,32 @@ type SearchPagination struct {
 	SortBy         SortEnum             `json:"sortBy"`
 	OrdedBy        OrderEnum            `json:"orderBy"`
 	Search         string               `json:"search"`
+	// Cursor switches to keyset pagination after (or before) the given row
+	Cursor *SearchCursor `json:"cursor"`
+	// WithTotal counts every matching product, which costs a second query
+	WithTotal bool `json:"withTotal"`
+	// Facets asks for aggregations over the same filters as the results
+	Facets []FacetEnum `json:"facets"`
+	// Archived lists the archived products of the user instead of live ones
+	Archived bool `json:"archived"`
+}
+
+// Order is the effective order: relevance defaults to best match first,
+// everything else to ascending.
+func (p *SearchPagination) Order() OrderEnum {
+	if strings.EqualFold(string(p.OrdedBy), string(Descending)) {
+		return Descending
+	}
+	if p.SortBy == Relevance && p.OrdedBy == "" {
+		return Descending
+	}
+	return Ascending
+}
+
+// SearchPage describes where a page of search results sits in the full list.
+type SearchPage struct {
+	Total      *int
+	NextCursor string
+	PrevCursor string
+	Facets     *SearchFacets
 }
@@ -0,0 +1,15 @@
+package util
+
+import (
+	"shopifyx/domain"
+	"time"
+)
+
+type StockHistoryPagination struct {
+	VariantId string                 `json:"variantId"`
+	Reason    domain.StockReasonEnum `json:"reason"`
+	From      time.Time              `json:"from"`
+	To        time.Time              `json:"to"`
+	Limit     int                    `json:"limit"`
+	Offset    int                    `json:"offset"`
+}
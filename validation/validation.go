@@ -0,0 +1,230 @@
+package validation
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Rules are declared on struct fields with a validate tag, e.g.
+//
+//	Name string `json:"name" validate:"required,min=5,max=60"`
+//
+// Supported rules:
+//   - required: strings must not be blank, slices must be present
+//   - min, max: length of strings and slices, value of numbers, other kinds
+//     such as bool are not checked
+//   - oneof: space separated list of allowed values
+//   - url: absolute http or https url
+//   - uuid: a well formed uuid
+//
+// Rules other than required are skipped for empty values, so optional fields
+// only need to be valid when they are sent. Nested structs and slices of
+// structs are validated too, with fields reported as payments[0].sellerId.
+const tagName = "validate"
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, fieldError := range e {
+		messages = append(messages, fieldError.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Struct validates every field of v, which must be a struct or a pointer to
+// one. It returns nil when all rules pass.
+func Struct(v interface{}) Errors {
+	return StructFields(v)
+}
+
+// StructFields validates only the listed top level fields, named by their
+// json name. With no fields given every field is validated.
+func StructFields(v interface{}, fields ...string) Errors {
+	var errs Errors
+	validateStruct(reflect.Indirect(reflect.ValueOf(v)), "", fields, &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func validateStruct(value reflect.Value, prefix string, only []string, errs *Errors) {
+	if value.Kind() != reflect.Struct {
+		return
+	}
+
+	valueType := value.Type()
+	for i := 0; i < valueType.NumField(); i++ {
+		field := valueType.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := jsonName(field)
+		if name == "-" || (len(only) != 0 && !contains(only, name)) {
+			continue
+		}
+
+		fieldValue := value.Field(i)
+		if tag := field.Tag.Get(tagName); tag != "" {
+			if fieldError, ok := validateField(fieldValue, prefix+name, tag); !ok {
+				*errs = append(*errs, fieldError)
+				continue
+			}
+		}
+
+		switch fieldValue.Kind() {
+		case reflect.Struct:
+			if field.Anonymous {
+				validateStruct(fieldValue, prefix, nil, errs)
+			} else {
+				validateStruct(fieldValue, prefix+name+".", nil, errs)
+			}
+		case reflect.Slice:
+			for j := 0; j < fieldValue.Len(); j++ {
+				validateStruct(reflect.Indirect(fieldValue.Index(j)), fmt.Sprintf("%s%s[%d].", prefix, name, j), nil, errs)
+			}
+		}
+	}
+}
+
+// validateField checks the rules of one field in order and reports the first
+// one that fails.
+func validateField(value reflect.Value, field, tag string) (FieldError, bool) {
+	rules := strings.Split(tag, ",")
+
+	if contains(rules, "required") {
+		if isBlank(value) {
+			return FieldError{Field: field, Rule: "required", Message: field + " is required"}, false
+		}
+	} else if isBlank(value) && !isNumber(value) {
+		return FieldError{}, true
+	}
+	value = reflect.Indirect(value)
+
+	for _, rule := range rules {
+		name, param, _ := strings.Cut(rule, "=")
+
+		var message string
+		switch name {
+		case "min":
+			limit, _ := strconv.Atoi(param)
+			if n, ok := size(value); ok && n < limit {
+				message = fmt.Sprintf("%s must be at least %s", field, describe(value, param))
+			}
+		case "max":
+			limit, _ := strconv.Atoi(param)
+			if n, ok := size(value); ok && n > limit {
+				message = fmt.Sprintf("%s must be at most %s", field, describe(value, param))
+			}
+		case "oneof":
+			allowed := strings.Fields(param)
+			if !contains(allowed, fmt.Sprint(value.Interface())) {
+				message = fmt.Sprintf("%s must be one of: %s", field, strings.Join(allowed, ", "))
+			}
+		case "url":
+			if !isURL(value.String()) {
+				message = field + " must be a valid http or https url"
+			}
+		case "uuid":
+			if !uuidPattern.MatchString(value.String()) {
+				message = field + " must be a valid id"
+			}
+		}
+
+		if message != "" {
+			return FieldError{Field: field, Rule: name, Message: message}, false
+		}
+	}
+	return FieldError{}, true
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func isBlank(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
+
+func isNumber(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// size is what min and max compare, ok is false for kinds they do not apply to.
+func size(value reflect.Value) (int, bool) {
+	switch value.Kind() {
+	case reflect.String:
+		return utf8.RuneCountInString(value.String()), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len(), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(value.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n := value.Uint(); n <= math.MaxInt {
+			return int(n), true
+		}
+		return math.MaxInt, true
+	case reflect.Float32, reflect.Float64:
+		return int(value.Float()), true
+	}
+	return 0, false
+}
+
+func describe(value reflect.Value, param string) string {
+	switch value.Kind() {
+	case reflect.String:
+		return param + " characters long"
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return param + " items"
+	}
+	return param
+}
+
+func isURL(value string) bool {
+	parsed, err := url.ParseRequestURI(value)
+	if err != nil {
+		return false
+	}
+	return (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
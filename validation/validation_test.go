@@ -0,0 +1,38 @@
+package validation
+
+import "testing"
+
+type sizedFields struct {
+	Count    uint   `json:"count" validate:"min=1,max=10"`
+	Small    uint8  `json:"small" validate:"max=3"`
+	Enabled  bool   `json:"enabled" validate:"min=1"`
+	Quantity int    `json:"quantity" validate:"min=0"`
+	Name     string `json:"name" validate:"max=5"`
+}
+
+func TestMinMaxByKind(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  sizedFields
+		fields []string
+	}{
+		{"valid", sizedFields{Count: 3, Small: 2, Enabled: true, Name: "short"}, nil},
+		{"uint below min", sizedFields{Count: 0}, []string{"count"}},
+		{"uint above max", sizedFields{Count: 11, Small: 4}, []string{"count", "small"}},
+		{"negative int", sizedFields{Count: 1, Quantity: -1}, []string{"quantity"}},
+		{"long string", sizedFields{Count: 1, Name: "too long"}, []string{"name"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := Struct(&tt.value)
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("got %v, want errors on %v", errs, tt.fields)
+			}
+			for i, field := range tt.fields {
+				if errs[i].Field != field {
+					t.Fatalf("got %v, want errors on %v", errs, tt.fields)
+				}
+			}
+		})
+	}
+}